@@ -74,4 +74,16 @@ type ApplicationConfiguration struct {
 			KeyPath  string `yaml:"keypath"`
 		} `yaml:"tls"`
 	} `yaml:"webserver"`
+	Ssh struct {
+		MaxSessions          int `yaml:"maxsessions"`          // Executions connected over SSH at once across all servers, further ones wait in line
+		MaxSessionsPerServer int `yaml:"maxsessionsperserver"` // Executions sharing the SSH connection to a server at once, unless the server sets its own limit
+	} `yaml:"ssh"`
+	Retention struct {
+		Enabled    bool `yaml:"enabled"`    // Whether the Action Retention Timer is enabled on start
+		ActionDays int  `yaml:"actiondays"` // Days after which finished actions are deleted along with their executions
+		OutputDays int  `yaml:"outputdays"` // Days after which the output of executions is trimmed to its end
+	} `yaml:"retention"`
+	Actions struct {
+		LocalExecutor bool `yaml:"localexecutor"` // Whether action templates may run their scripts in a local shell on the NAM host
+	} `yaml:"actions"`
 }
@@ -10,11 +10,15 @@ import (
 
 // Loads secrets based on the configuration, returning the secrets
 func ParseSecrets(AppConfig ApplicationConfiguration) (*tls.Config, error) {
-	// Setup
+	// Setup, the configured CAs are trusted in addition to the ones of the system
+	rootCAs, err := x509.SystemCertPool()
+	if err != nil {
+		rootCAs = x509.NewCertPool()
+	}
 	tlsConfig := &tls.Config{
-		RootCAs:            x509.NewCertPool(),
-		Certificates:       make([]tls.Certificate, 0),
-		InsecureSkipVerify: true, // TODO: Properly control
+		RootCAs:      rootCAs,
+		Certificates: make([]tls.Certificate, 0),
+		// Certificates are verified unless a healthcheck opts out of it, see Healthcheck.VerifySSL
 	}
 	// Parse keys
 	if AppConfig.Keys.CaCertsPath != "" {
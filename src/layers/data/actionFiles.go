@@ -0,0 +1,149 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ActionFile CRUD operations
+
+// CreateActionFile stores a file to be uploaded by action templates, its size and checksum are calculated from the content
+func CreateActionFile(pool *pgxpool.Pool, file *ActionFile) (*ActionFile, error) {
+	checksum := sha256.Sum256(file.Content)
+	query := `
+		INSERT INTO action_file (name, description, content, size, sha256, created_by_user_id)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, name, COALESCE(description, ''), size, sha256, created_by_user_id, created_at, updated_at
+	`
+
+	var result ActionFile
+	err := pool.QueryRow(context.Background(), query,
+		file.Name, file.Description, file.Content, len(file.Content), hex.EncodeToString(checksum[:]), file.CreatedByUserId).Scan(
+		&result.Id, &result.Name, &result.Description, &result.Size, &result.Sha256, &result.CreatedByUserId, &result.CreatedAt, &result.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// GetActionFileAll retrieves all stored files without their content
+func GetActionFileAll(pool *pgxpool.Pool) (*[]ActionFile, error) {
+	query := `
+		SELECT id, name, COALESCE(description, '') as description, size, sha256, created_by_user_id, created_at, updated_at
+		FROM action_file
+		ORDER BY name
+	`
+
+	rows, err := pool.Query(context.Background(), query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	files, err := pgx.CollectRows(rows, pgx.RowToStructByNameLax[ActionFile])
+	if err != nil {
+		return nil, err
+	}
+
+	return &files, nil
+}
+
+// GetActionFileById retrieves a stored file including its content, nil if it doesn't exist
+func GetActionFileById(pool *pgxpool.Pool, id uint) (*ActionFile, error) {
+	query := `
+		SELECT id, name, COALESCE(description, ''), content, size, sha256, created_by_user_id, created_at, updated_at
+		FROM action_file
+		WHERE id = $1
+	`
+
+	var file ActionFile
+	err := pool.QueryRow(context.Background(), query, id).Scan(
+		&file.Id, &file.Name, &file.Description, &file.Content, &file.Size, &file.Sha256, &file.CreatedByUserId, &file.CreatedAt, &file.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &file, nil
+}
+
+// DeleteActionFile deletes a stored file. Templates still uploading it fail until they are changed
+func DeleteActionFile(pool *pgxpool.Pool, id uint) error {
+	query := `DELETE FROM action_file WHERE id = $1`
+	_, err := pool.Exec(context.Background(), query, id)
+	return err
+}
+
+// ActionExecutionArtifact CRUD operations
+
+// CreateActionExecutionArtifact stores a file downloaded by an execution
+func CreateActionExecutionArtifact(pool *pgxpool.Pool, artifact *ActionExecutionArtifact) (*ActionExecutionArtifact, error) {
+	query := `
+		INSERT INTO action_execution_artifact (action_execution_id, name, remote_path, content, size)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at
+	`
+
+	result := *artifact
+	result.Size = int64(len(artifact.Content))
+	err := pool.QueryRow(context.Background(), query,
+		artifact.ActionExecutionId, artifact.Name, artifact.RemotePath, artifact.Content, result.Size).Scan(&result.Id, &result.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// GetActionExecutionArtifactsByActionId retrieves the artifacts of all executions of an action without their content
+func GetActionExecutionArtifactsByActionId(pool *pgxpool.Pool, actionId uint) (*[]ActionExecutionArtifact, error) {
+	query := `
+		SELECT a.id, a.action_execution_id, a.name, a.remote_path, a.size, a.created_at
+		FROM action_execution_artifact a
+		JOIN action_execution ae ON a.action_execution_id = ae.id
+		WHERE ae.action_id = $1
+		ORDER BY a.action_execution_id, a.id
+	`
+
+	rows, err := pool.Query(context.Background(), query, actionId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	artifacts, err := pgx.CollectRows(rows, pgx.RowToStructByNameLax[ActionExecutionArtifact])
+	if err != nil {
+		return nil, err
+	}
+
+	return &artifacts, nil
+}
+
+// GetActionExecutionArtifactById retrieves an artifact including its content, nil if it doesn't exist
+func GetActionExecutionArtifactById(pool *pgxpool.Pool, id uint) (*ActionExecutionArtifact, error) {
+	query := `
+		SELECT id, action_execution_id, name, remote_path, content, size, created_at
+		FROM action_execution_artifact
+		WHERE id = $1
+	`
+
+	var artifact ActionExecutionArtifact
+	err := pool.QueryRow(context.Background(), query, id).Scan(
+		&artifact.Id, &artifact.ActionExecutionId, &artifact.Name, &artifact.RemotePath, &artifact.Content, &artifact.Size, &artifact.CreatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &artifact, nil
+}
@@ -0,0 +1,60 @@
+package data
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Retention of the history of actions
+
+// Prepended to output which was cut by the retention
+const TrimmedOutputMarker = "[output trimmed by NAM retention, only its end was kept]\n"
+
+// DeleteActionsFinishedBefore deletes the actions which finished before the given time, along with their executions, steps and artifacts.
+// Rejected actions count as finished once they were reviewed. Returns the number of deleted actions
+func DeleteActionsFinishedBefore(pool *pgxpool.Pool, before time.Time) (int64, error) {
+	query := `
+		DELETE FROM action
+		WHERE status IN ('completed', 'failed', 'cancelled', 'rejected')
+		  AND COALESCE(completed_at, reviewed_at, updated_at) < $1
+	`
+	tag, err := pool.Exec(context.Background(), query, before)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// TrimActionOutputsFinishedBefore cuts the output of executions and their steps which finished before the given time to at most their last keep bytes,
+// prefixed with the TrimmedOutputMarker. Output which was trimmed already is left as it is. Returns the number of trimmed executions and steps
+func TrimActionOutputsFinishedBefore(pool *pgxpool.Pool, before time.Time, keep int) (int64, error) {
+	var trimmed int64
+	for _, table := range []string{"action_execution", "action_execution_step"} {
+		query := `
+			UPDATE ` + table + `
+			SET output = CASE WHEN octet_length(output) > $2 + octet_length($3) THEN $3 || ` + outputEnd("output") + ` ELSE output END,
+			    error_output = CASE WHEN octet_length(error_output) > $2 + octet_length($3) THEN $3 || ` + outputEnd("error_output") + ` ELSE error_output END
+			WHERE completed_at < $1
+			  AND (octet_length(output) > $2 + octet_length($3) OR octet_length(error_output) > $2 + octet_length($3))
+		`
+		tag, err := pool.Exec(context.Background(), query, before, keep, TrimmedOutputMarker)
+		if err != nil {
+			return trimmed, err
+		}
+		trimmed += tag.RowsAffected()
+	}
+	return trimmed, nil
+}
+
+// Returns the SQL expression for the last $2 bytes of the text column, without the bytes of a character cut at their start.
+// These are UTF-8 continuation bytes (10xxxxxx), of which a character has up to three. Only valid for columns longer than $2 bytes
+func outputEnd(column string) string {
+	end := `substring(convert_to(` + column + `, 'UTF8') FROM octet_length(` + column + `) - $2 + 1)`
+	return `convert_from(substring(` + end + ` FROM CASE
+		WHEN get_byte(` + end + `, 0) & 192 <> 128 THEN 1
+		WHEN get_byte(` + end + `, 1) & 192 <> 128 THEN 2
+		WHEN get_byte(` + end + `, 2) & 192 <> 128 THEN 3
+		ELSE 4 END), 'UTF8')`
+}
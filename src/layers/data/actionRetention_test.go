@@ -0,0 +1,188 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Queries are tested against the Postgres database in NAM_TEST_POSTGRES_DSN, which is migrated first.
+// Retention applies to the whole database, so it has to be one used only by tests
+func testDatabasePool(t *testing.T) *pgxpool.Pool {
+	dsn := os.Getenv("NAM_TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("NAM_TEST_POSTGRES_DSN is not set")
+	}
+	if err := AutoMigrate(dsn); err != nil {
+		t.Fatal(err)
+	}
+	pool, err := pgxpool.New(context.Background(), dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pool.Close)
+	return pool
+}
+
+func TestDeleteActionsFinishedBefore(t *testing.T) {
+	pool := testDatabasePool(t)
+	ctx := context.Background()
+	before := time.Now().AddDate(0, 0, -30).Truncate(time.Second)
+	older, newer := before.Add(-time.Minute), before.Add(time.Minute)
+
+	var templateId uint
+	err := pool.QueryRow(ctx, `INSERT INTO action_template (name, bash_script) VALUES ('retention test', 'true') RETURNING id`).Scan(&templateId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pool.Exec(ctx, `DELETE FROM action_template WHERE id = $1`, templateId) })
+
+	tests := []struct {
+		name        string
+		status      string
+		completedAt *time.Time
+		reviewedAt  *time.Time
+		updatedAt   time.Time
+		deleted     bool
+	}{
+		{"completed before", "completed", &older, nil, older, true},
+		{"completed after", "completed", &newer, nil, older, false},
+		{"completed at the cutoff", "completed", &before, nil, older, false},
+		{"failed before", "failed", &older, nil, older, true},
+		{"cancelled before", "cancelled", &older, nil, older, true},
+		{"rejected before", "rejected", nil, &older, newer, true},
+		{"rejected after", "rejected", nil, &newer, older, false},
+		{"completed time before the update", "completed", &newer, nil, older, false},
+		{"finished without times", "failed", nil, nil, older, true},
+		{"running", "running", nil, nil, older, false},
+		{"pending", "pending", nil, nil, older, false},
+		{"pending approval", "pending_approval", nil, nil, older, false},
+	}
+	ids := make([]uint, len(tests))
+	for i, tt := range tests {
+		err := pool.QueryRow(ctx, `
+			INSERT INTO action (action_template_id, name, status, created_at, updated_at, completed_at, reviewed_at, created_by_user_id)
+			VALUES ($1, $2, $3, $4, $4, $5, $6, 0) RETURNING id`,
+			templateId, tt.name, tt.status, tt.updatedAt, tt.completedAt, tt.reviewedAt).Scan(&ids[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := DeleteActionsFinishedBefore(pool, before); err != nil {
+		t.Fatal(err)
+	}
+	for i, tt := range tests {
+		var exists bool
+		if err := pool.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM action WHERE id = $1)`, ids[i]).Scan(&exists); err != nil {
+			t.Fatal(err)
+		}
+		if exists == tt.deleted {
+			t.Errorf("%s: deleted = %v, want %v", tt.name, !exists, tt.deleted)
+		}
+	}
+}
+
+func TestTrimActionOutputsFinishedBefore(t *testing.T) {
+	pool := testDatabasePool(t)
+	ctx := context.Background()
+	before := time.Now().AddDate(0, 0, -30).Truncate(time.Second)
+	older, newer := before.Add(-time.Minute), before.Add(time.Minute)
+	const keep = 10
+	limit := keep + len(TrimmedOutputMarker) // Output up to this many bytes is kept as it is
+
+	// Executions belong to an instance, which needs a server and an application
+	suffix := fmt.Sprint(time.Now().UnixNano())
+	var templateId, actionId, instanceId uint
+	err := pool.QueryRow(ctx, `
+		WITH node AS (INSERT INTO topology_node (type) VALUES ('application_instance') RETURNING id),
+		     srv AS (INSERT INTO server (alias, hostname) VALUES ('retention test', $1) RETURNING id),
+		     app AS (INSERT INTO application_definition (name, port, type) VALUES ($1, 80, 'retention test') RETURNING id)
+		INSERT INTO application_instance (topology_node_id, name, server_id, application_definition_id)
+		SELECT node.id, $1, srv.id, app.id FROM node, srv, app RETURNING id`, "retention-test-"+suffix).Scan(&instanceId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		var serverId, definitionId, nodeId uint
+		pool.QueryRow(ctx, `DELETE FROM application_instance WHERE id = $1 RETURNING server_id, application_definition_id, topology_node_id`, instanceId).Scan(&serverId, &definitionId, &nodeId)
+		pool.Exec(ctx, `DELETE FROM server WHERE id = $1`, serverId)
+		pool.Exec(ctx, `DELETE FROM application_definition WHERE id = $1`, definitionId)
+		pool.Exec(ctx, `DELETE FROM topology_node WHERE id = $1`, nodeId)
+	})
+	if err := pool.QueryRow(ctx, `INSERT INTO action_template (name, bash_script) VALUES ('retention test', 'true') RETURNING id`).Scan(&templateId); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pool.Exec(ctx, `DELETE FROM action_template WHERE id = $1`, templateId) })
+	// A running action, so the deletion does not take it
+	if err := pool.QueryRow(ctx, `INSERT INTO action (action_template_id, name, status, created_by_user_id) VALUES ($1, 'retention test', 'running', 0) RETURNING id`, templateId).Scan(&actionId); err != nil {
+		t.Fatal(err)
+	}
+
+	long := strings.Repeat("a", limit-keep) + "0123456789"
+	tests := []struct {
+		name        string
+		completedAt *time.Time
+		output      string
+		errorOutput string
+		want        string
+		wantError   string
+	}{
+		{"longer than the limit", &older, long + "!", "", TrimmedOutputMarker + "123456789!", ""},
+		{"exactly the limit", &older, long, "", long, ""},
+		{"error output only", &older, "ok", "é" + long, "ok", TrimmedOutputMarker + "0123456789"},
+		// The limit counts bytes, of which é takes two and € three. A character cut at the start is dropped
+		{"two byte characters", &older, strings.Repeat("é", limit), "", TrimmedOutputMarker + "ééééé", ""},
+		{"character cut at the start", &older, strings.Repeat("a", limit) + "€€€€", "", TrimmedOutputMarker + "€€€", ""},
+		{"multibyte output within the limit", &older, strings.Repeat("é", limit/2), "", strings.Repeat("é", limit/2), ""},
+		{"finished at the cutoff", &before, long + "!", "", long + "!", ""},
+		{"finished after", &newer, long + "!", "", long + "!", ""},
+		{"not finished", nil, long + "!", "", long + "!", ""},
+		{"trimmed already", &older, TrimmedOutputMarker + "0123456789", "", TrimmedOutputMarker + "0123456789", ""},
+	}
+	for _, table := range []string{"action_execution", "action_execution_step"} {
+		ids := make([]uint, len(tests))
+		for i, tt := range tests {
+			var executionId uint
+			err := pool.QueryRow(ctx, `
+				INSERT INTO action_execution (action_id, application_instance_id, status, output, error_output, completed_at)
+				VALUES ($1, $2, 'completed', $3, $4, $5) RETURNING id`,
+				actionId, instanceId, tt.output, tt.errorOutput, tt.completedAt).Scan(&executionId)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ids[i] = executionId
+			if table == "action_execution_step" {
+				// The execution itself is kept short, so only the step is trimmed
+				if _, err := pool.Exec(ctx, `UPDATE action_execution SET output = '', error_output = '' WHERE id = $1`, executionId); err != nil {
+					t.Fatal(err)
+				}
+				err := pool.QueryRow(ctx, `
+					INSERT INTO action_execution_step (action_execution_id, position, name, type, status, output, error_output, completed_at)
+					VALUES ($1, 0, $2, 'script', 'completed', $3, $4, $5) RETURNING id`,
+					executionId, tt.name, tt.output, tt.errorOutput, tt.completedAt).Scan(&ids[i])
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+
+		if _, err := TrimActionOutputsFinishedBefore(pool, before, keep); err != nil {
+			t.Fatal(err)
+		}
+		for i, tt := range tests {
+			var output, errorOutput string
+			if err := pool.QueryRow(ctx, `SELECT output, error_output FROM `+table+` WHERE id = $1`, ids[i]).Scan(&output, &errorOutput); err != nil {
+				t.Fatal(err)
+			}
+			if output != tt.want || errorOutput != tt.wantError {
+				t.Errorf("%s %s: output = %q, %q, want %q, %q", table, tt.name, output, errorOutput, tt.want, tt.wantError)
+			}
+		}
+	}
+}
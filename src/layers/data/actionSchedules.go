@@ -0,0 +1,201 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ActionSchedule CRUD operations
+
+// Columns of a schedule, including the name of its template. Read with scanActionSchedule
+const actionScheduleColumns = `
+	s.id, s.name, s.action_template_id, s.instance_ids, s.cron_expression, s.run_at, s.next_run_at, s.last_run_at, s.enabled,
+	s.created_by_user_id, s.created_at, s.updated_at,
+	s.execution_strategy, s.batch_size, s.batch_size_percent, s.batch_pause_seconds, s.stop_on_failure,
+	s.wait_for_healthy, s.healthy_timeout_seconds, s.maintenance_mode, s.parameters,
+	at.name as template_name`
+
+func scanActionSchedule(row pgx.Row) (*ActionSchedule, error) {
+	var schedule ActionSchedule
+	err := row.Scan(
+		&schedule.Id, &schedule.Name, &schedule.ActionTemplateId, &schedule.InstanceIds, &schedule.CronExpression,
+		&schedule.RunAt, &schedule.NextRunAt, &schedule.LastRunAt, &schedule.Enabled,
+		&schedule.CreatedByUserId, &schedule.CreatedAt, &schedule.UpdatedAt,
+		&schedule.ExecutionStrategy, &schedule.BatchSize, &schedule.BatchSizePercent, &schedule.BatchPauseSeconds, &schedule.StopOnFailure,
+		&schedule.WaitForHealthy, &schedule.HealthyTimeoutSeconds, &schedule.MaintenanceMode, &schedule.Parameters,
+		&schedule.TemplateName)
+	if err != nil {
+		return nil, err
+	}
+	return &schedule, nil
+}
+
+// CreateActionSchedule creates a new action schedule
+func CreateActionSchedule(pool *pgxpool.Pool, schedule *ActionSchedule) (*ActionSchedule, error) {
+	query := `
+		INSERT INTO action_schedule (name, action_template_id, instance_ids, cron_expression, run_at, next_run_at, enabled, created_by_user_id,
+		                             execution_strategy, batch_size, batch_size_percent, batch_pause_seconds, stop_on_failure,
+		                             wait_for_healthy, healthy_timeout_seconds, maintenance_mode, parameters)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, COALESCE($17, '{}'::jsonb))
+		RETURNING id
+	`
+
+	var id uint
+	err := pool.QueryRow(context.Background(), query,
+		schedule.Name, schedule.ActionTemplateId, schedule.InstanceIds, schedule.CronExpression, schedule.RunAt, schedule.NextRunAt,
+		schedule.Enabled, schedule.CreatedByUserId,
+		schedule.ExecutionStrategy, schedule.BatchSize, schedule.BatchSizePercent, schedule.BatchPauseSeconds, schedule.StopOnFailure,
+		schedule.WaitForHealthy, schedule.HealthyTimeoutSeconds, schedule.MaintenanceMode, schedule.Parameters).Scan(&id)
+	if err != nil {
+		return nil, err
+	}
+
+	return GetActionScheduleById(pool, id)
+}
+
+// GetActionScheduleById retrieves an action schedule by ID, nil if it doesn't exist
+func GetActionScheduleById(pool *pgxpool.Pool, id uint) (*ActionSchedule, error) {
+	query := `
+		SELECT ` + actionScheduleColumns + `
+		FROM action_schedule s
+		JOIN action_template at ON s.action_template_id = at.id
+		WHERE s.id = $1
+	`
+
+	schedule, err := scanActionSchedule(pool.QueryRow(context.Background(), query, id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return schedule, nil
+}
+
+// GetActionScheduleAll retrieves all action schedules, ordered by their next run
+func GetActionScheduleAll(pool *pgxpool.Pool) (*[]ActionSchedule, error) {
+	query := `
+		SELECT ` + actionScheduleColumns + `
+		FROM action_schedule s
+		JOIN action_template at ON s.action_template_id = at.id
+		ORDER BY s.next_run_at ASC NULLS LAST, s.name
+	`
+	return queryActionSchedules(pool, query)
+}
+
+// GetDueActionSchedules retrieves the enabled schedules, whose next run is at or before now
+func GetDueActionSchedules(pool *pgxpool.Pool, now time.Time) (*[]ActionSchedule, error) {
+	query := `
+		SELECT ` + actionScheduleColumns + `
+		FROM action_schedule s
+		JOIN action_template at ON s.action_template_id = at.id
+		WHERE s.enabled AND s.next_run_at <= $1
+		ORDER BY s.next_run_at ASC
+	`
+	return queryActionSchedules(pool, query, now)
+}
+
+func queryActionSchedules(pool *pgxpool.Pool, query string, args ...any) (*[]ActionSchedule, error) {
+	rows, err := pool.Query(context.Background(), query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	schedules := []ActionSchedule{}
+	for rows.Next() {
+		schedule, err := scanActionSchedule(rows)
+		if err != nil {
+			return nil, err
+		}
+		schedules = append(schedules, *schedule)
+	}
+
+	return &schedules, rows.Err()
+}
+
+// UpdateActionScheduleEnabled enables or disables a schedule. The next run is nil when disabling
+func UpdateActionScheduleEnabled(pool *pgxpool.Pool, id uint, enabled bool, nextRunAt *time.Time) error {
+	query := `UPDATE action_schedule SET enabled = $2, next_run_at = $3, updated_at = NOW() WHERE id = $1`
+	_, err := pool.Exec(context.Background(), query, id, enabled, nextRunAt)
+	return err
+}
+
+// ClaimActionScheduleRun records the due run of a schedule, unless its next run changed since dueAt was read. Without a next run, the schedule is disabled.
+// Returns false if the run was already claimed, e.g. by another NAM instance sharing the database, or the schedule was changed or disabled meanwhile
+func ClaimActionScheduleRun(pool *pgxpool.Pool, id uint, dueAt time.Time, lastRunAt time.Time, nextRunAt *time.Time) (bool, error) {
+	query := `
+		UPDATE action_schedule SET last_run_at = $3, next_run_at = $4, enabled = $4 IS NOT NULL, updated_at = NOW()
+		WHERE id = $1 AND enabled AND next_run_at = $2
+		RETURNING id
+	`
+	var claimed uint
+	err := pool.QueryRow(context.Background(), query, id, dueAt, lastRunAt, nextRunAt).Scan(&claimed)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// DeleteActionSchedule deletes an action schedule, the actions it launched are kept
+func DeleteActionSchedule(pool *pgxpool.Pool, id uint) error {
+	query := `DELETE FROM action_schedule WHERE id = $1`
+	_, err := pool.Exec(context.Background(), query, id)
+	return err
+}
+
+// ValidateActionSchedule validates a schedule and applies the defaults of its action settings. The cron expression itself is parsed by the scheduler
+func ValidateActionSchedule(schedule *ActionSchedule) error {
+	if schedule.Name == "" {
+		return errors.New("schedule name is required")
+	}
+	if schedule.ActionTemplateId == 0 {
+		return errors.New("action template is required")
+	}
+	if len(schedule.InstanceIds) == 0 {
+		return errors.New("at least one instance is required")
+	}
+	if schedule.CronExpression == "" && schedule.RunAt == nil {
+		return errors.New("either a cron expression or a time to run at is required")
+	}
+	if schedule.CronExpression != "" && schedule.RunAt != nil {
+		return errors.New("a schedule either runs once or on a cron expression, not both")
+	}
+
+	action := schedule.NewAction()
+	if err := ValidateActionStrategy(action); err != nil {
+		return err
+	}
+	schedule.ExecutionStrategy = action.ExecutionStrategy
+	schedule.BatchSize = action.BatchSize
+	schedule.HealthyTimeoutSeconds = action.HealthyTimeoutSeconds
+	return nil
+}
+
+// NewAction creates a pending action with the settings of the schedule, linked to it
+func (schedule *ActionSchedule) NewAction() *Action {
+	scheduleId := schedule.Id
+	return &Action{
+		ActionTemplateId:      schedule.ActionTemplateId,
+		Name:                  schedule.Name,
+		Status:                "pending",
+		CreatedByUserId:       schedule.CreatedByUserId,
+		ExecutionStrategy:     schedule.ExecutionStrategy,
+		BatchSize:             schedule.BatchSize,
+		BatchSizePercent:      schedule.BatchSizePercent,
+		BatchPauseSeconds:     schedule.BatchPauseSeconds,
+		StopOnFailure:         schedule.StopOnFailure,
+		WaitForHealthy:        schedule.WaitForHealthy,
+		HealthyTimeoutSeconds: schedule.HealthyTimeoutSeconds,
+		MaintenanceMode:       schedule.MaintenanceMode,
+		Parameters:            schedule.Parameters,
+		ActionScheduleId:      &scheduleId,
+	}
+}
@@ -0,0 +1,95 @@
+package data
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ActionExecutionStep CRUD operations
+
+const actionExecutionStepColumns = `id, action_execution_id, position, name, type, status, attempts, output, error_output, exit_code, started_at, completed_at`
+
+// CreateActionExecutionStep creates the record of a step of an execution
+func CreateActionExecutionStep(pool *pgxpool.Pool, step *ActionExecutionStep) (*ActionExecutionStep, error) {
+	query := `
+		INSERT INTO action_execution_step (action_execution_id, position, name, type, status)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING ` + actionExecutionStepColumns
+
+	rows, err := pool.Query(context.Background(), query, step.ActionExecutionId, step.Position, step.Name, step.Type, step.Status)
+	if err != nil {
+		return nil, err
+	}
+	result, err := pgx.CollectExactlyOneRow(rows, pgx.RowToStructByName[ActionExecutionStep])
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// UpdateActionExecutionStep stores the status, attempts and output of a step
+func UpdateActionExecutionStep(pool *pgxpool.Pool, step *ActionExecutionStep) error {
+	query := `
+		UPDATE action_execution_step
+		SET status = $2, attempts = $3, output = $4, error_output = $5, exit_code = $6, started_at = $7, completed_at = $8
+		WHERE id = $1
+	`
+
+	_, err := pool.Exec(context.Background(), query,
+		step.Id, step.Status, step.Attempts, step.Output, step.ErrorOutput, step.ExitCode, step.StartedAt, step.CompletedAt)
+	return err
+}
+
+// DeleteActionExecutionSteps deletes the steps of an execution, before it runs again
+func DeleteActionExecutionSteps(pool *pgxpool.Pool, executionId uint) error {
+	query := `DELETE FROM action_execution_step WHERE action_execution_id = $1`
+	_, err := pool.Exec(context.Background(), query, executionId)
+	return err
+}
+
+// GetActionExecutionStepById retrieves a step of an execution, nil if it doesn't exist
+func GetActionExecutionStepById(pool *pgxpool.Pool, id uint) (*ActionExecutionStep, error) {
+	query := `SELECT ` + actionExecutionStepColumns + ` FROM action_execution_step WHERE id = $1`
+
+	rows, err := pool.Query(context.Background(), query, id)
+	if err != nil {
+		return nil, err
+	}
+	step, err := pgx.CollectExactlyOneRow(rows, pgx.RowToStructByName[ActionExecutionStep])
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &step, nil
+}
+
+// GetActionExecutionStepsByActionId retrieves the steps of all executions of an action, ordered by execution and position
+func GetActionExecutionStepsByActionId(pool *pgxpool.Pool, actionId uint) (*[]ActionExecutionStep, error) {
+	query := `
+		SELECT s.id, s.action_execution_id, s.position, s.name, s.type, s.status, s.attempts, s.output, s.error_output, s.exit_code, s.started_at, s.completed_at
+		FROM action_execution_step s
+		JOIN action_execution ae ON s.action_execution_id = ae.id
+		WHERE ae.action_id = $1
+		ORDER BY s.action_execution_id, s.position
+	`
+
+	rows, err := pool.Query(context.Background(), query, actionId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	steps, err := pgx.CollectRows(rows, pgx.RowToStructByName[ActionExecutionStep])
+	if err != nil {
+		return nil, err
+	}
+
+	return &steps, nil
+}
@@ -2,8 +2,13 @@ package data
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"regexp"
+	"slices"
+	"strconv"
+	"strings"
 
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
@@ -14,15 +19,15 @@ import (
 // CreateActionTemplate creates a new action template
 func CreateActionTemplate(pool *pgxpool.Pool, template *ActionTemplate) (*ActionTemplate, error) {
 	query := `
-		INSERT INTO action_template (name, description, bash_script)
-		VALUES ($1, $2, $3)
-		RETURNING id, name, description, bash_script, created_at, updated_at
+		INSERT INTO action_template (name, description, bash_script, maintenance_mode, requires_approval, parameters, file_transfers, steps, executor, webhook)
+		VALUES ($1, $2, $3, $4, $5, COALESCE($6, '[]'::jsonb), COALESCE($7, '[]'::jsonb), COALESCE($8, '[]'::jsonb), $9, $10)
+		RETURNING id, name, description, bash_script, maintenance_mode, requires_approval, parameters, file_transfers, steps, executor, webhook, created_at, updated_at
 	`
 
 	var result ActionTemplate
 	err := pool.QueryRow(context.Background(), query,
-		template.Name, template.Description, template.BashScript).Scan(
-		&result.Id, &result.Name, &result.Description, &result.BashScript,
+		template.Name, template.Description, template.BashScript, template.MaintenanceMode, template.RequiresApproval, template.Parameters, template.FileTransfers, template.Steps, template.Executor, template.Webhook).Scan(
+		&result.Id, &result.Name, &result.Description, &result.BashScript, &result.MaintenanceMode, &result.RequiresApproval, &result.Parameters, &result.FileTransfers, &result.Steps, &result.Executor, &result.Webhook,
 		&result.CreatedAt, &result.UpdatedAt)
 
 	if err != nil {
@@ -35,14 +40,14 @@ func CreateActionTemplate(pool *pgxpool.Pool, template *ActionTemplate) (*Action
 // GetActionTemplateById retrieves an action template by ID
 func GetActionTemplateById(pool *pgxpool.Pool, id uint) (*ActionTemplate, error) {
 	query := `
-		SELECT id, name, description, bash_script, created_at, updated_at
+		SELECT id, name, description, bash_script, maintenance_mode, requires_approval, parameters, file_transfers, steps, executor, webhook, created_at, updated_at
 		FROM action_template
 		WHERE id = $1
 	`
 
 	var template ActionTemplate
 	err := pool.QueryRow(context.Background(), query, id).Scan(
-		&template.Id, &template.Name, &template.Description, &template.BashScript,
+		&template.Id, &template.Name, &template.Description, &template.BashScript, &template.MaintenanceMode, &template.RequiresApproval, &template.Parameters, &template.FileTransfers, &template.Steps, &template.Executor, &template.Webhook,
 		&template.CreatedAt, &template.UpdatedAt)
 
 	if err != nil {
@@ -58,7 +63,7 @@ func GetActionTemplateById(pool *pgxpool.Pool, id uint) (*ActionTemplate, error)
 // GetActionTemplateAll retrieves all action templates
 func GetActionTemplateAll(pool *pgxpool.Pool) (*[]ActionTemplate, error) {
 	query := `
-		SELECT id, name, description, bash_script, created_at, updated_at
+		SELECT id, name, description, bash_script, maintenance_mode, requires_approval, parameters, file_transfers, steps, executor, webhook, created_at, updated_at
 		FROM action_template
 		ORDER BY created_at DESC
 	`
@@ -81,12 +86,14 @@ func GetActionTemplateAll(pool *pgxpool.Pool) (*[]ActionTemplate, error) {
 func UpdateActionTemplate(pool *pgxpool.Pool, template *ActionTemplate) error {
 	query := `
 		UPDATE action_template
-		SET name = $2, description = $3, bash_script = $4, updated_at = NOW()
+		SET name = $2, description = $3, bash_script = $4, maintenance_mode = $5, requires_approval = $6, parameters = COALESCE($7, '[]'::jsonb),
+		    file_transfers = COALESCE($8, '[]'::jsonb), steps = COALESCE($9, '[]'::jsonb),
+		    executor = $10, webhook = $11, updated_at = NOW()
 		WHERE id = $1
 	`
 
 	_, err := pool.Exec(context.Background(), query,
-		template.Id, template.Name, template.Description, template.BashScript)
+		template.Id, template.Name, template.Description, template.BashScript, template.MaintenanceMode, template.RequiresApproval, template.Parameters, template.FileTransfers, template.Steps, template.Executor, template.Webhook)
 
 	return err
 }
@@ -100,25 +107,56 @@ func DeleteActionTemplate(pool *pgxpool.Pool, id uint) error {
 
 // Action CRUD operations
 
-// CreateAction creates a new action
-func CreateAction(pool *pgxpool.Pool, action *Action) (*Action, error) {
+// Builds the copy of the template at, which is kept with an action. Its timestamps are converted, as the columns lack a time zone
+const actionTemplateSnapshot = `to_jsonb(at) || jsonb_build_object('created_at', at.created_at AT TIME ZONE 'UTC', 'updated_at', at.updated_at AT TIME ZONE 'UTC')`
+
+// CreateAction creates a new action, with a copy of its template as it is now. The copy is what is approved and run, whatever happens to the template later.
+// An execution is created for each instance, in the order in which they are executed. Either the action is created with all of its executions, or nothing is
+func CreateAction(pool *pgxpool.Pool, action *Action, instanceIds []uint) (*Action, error) {
+	tx, err := pool.Begin(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(context.Background())
+
 	query := `
-		INSERT INTO action (action_template_id, name, status, created_by_user_id)
-		VALUES ($1, $2, $3, $4)
-		RETURNING id, action_template_id, name, status, created_at, updated_at, started_at, completed_at, created_by_user_id
+		INSERT INTO action (action_template_id, name, status, created_by_user_id,
+		                    execution_strategy, batch_size, batch_size_percent, batch_pause_seconds, stop_on_failure,
+		                    wait_for_healthy, healthy_timeout_seconds, maintenance_mode, parameters, action_schedule_id, requires_approval,
+		                    template_snapshot)
+		SELECT $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, COALESCE($13, '{}'::jsonb), $14, $15, ` + actionTemplateSnapshot + `
+		FROM action_template at
+		WHERE at.id = $1
+		RETURNING id, action_template_id, name, status, created_at, updated_at, started_at, completed_at, created_by_user_id,
+		          execution_strategy, batch_size, batch_size_percent, batch_pause_seconds, stop_on_failure,
+		          wait_for_healthy, healthy_timeout_seconds, maintenance_mode, parameters, action_schedule_id, requires_approval
 	`
 
 	var result Action
-	err := pool.QueryRow(context.Background(), query,
-		action.ActionTemplateId, action.Name, action.Status, action.CreatedByUserId).Scan(
+	err = tx.QueryRow(context.Background(), query,
+		action.ActionTemplateId, action.Name, action.Status, action.CreatedByUserId,
+		action.ExecutionStrategy, action.BatchSize, action.BatchSizePercent, action.BatchPauseSeconds, action.StopOnFailure,
+		action.WaitForHealthy, action.HealthyTimeoutSeconds, action.MaintenanceMode, action.Parameters, action.ActionScheduleId, action.RequiresApproval).Scan(
 		&result.Id, &result.ActionTemplateId, &result.Name, &result.Status,
-		&result.CreatedAt, &result.UpdatedAt, &result.StartedAt, &result.CompletedAt, &result.CreatedByUserId)
+		&result.CreatedAt, &result.UpdatedAt, &result.StartedAt, &result.CompletedAt, &result.CreatedByUserId,
+		&result.ExecutionStrategy, &result.BatchSize, &result.BatchSizePercent, &result.BatchPauseSeconds, &result.StopOnFailure,
+		&result.WaitForHealthy, &result.HealthyTimeoutSeconds, &result.MaintenanceMode, &result.Parameters, &result.ActionScheduleId, &result.RequiresApproval)
 
 	if err != nil {
 		return nil, err
 	}
 
-	return &result, nil
+	for position, instanceId := range instanceIds {
+		_, err := tx.Exec(context.Background(), `
+			INSERT INTO action_execution (action_id, application_instance_id, status, position)
+			VALUES ($1, $2, 'pending', $3)
+		`, result.Id, instanceId, position)
+		if err != nil {
+			return nil, fmt.Errorf("unable to create execution for instance %d: %w", instanceId, err)
+		}
+	}
+
+	return &result, tx.Commit(context.Background())
 }
 
 // GetActionById retrieves an action by ID with related data
@@ -127,19 +165,28 @@ func GetActionById(pool *pgxpool.Pool, id uint) (*ActionFull, error) {
 	actionQuery := `
 		SELECT a.id, a.action_template_id, a.name, a.status, a.created_at, a.updated_at, 
 		       a.started_at, a.completed_at, a.created_by_user_id,
-		       at.name as template_name, u.username
+		       a.execution_strategy, a.batch_size, a.batch_size_percent, a.batch_pause_seconds, a.stop_on_failure,
+		       a.wait_for_healthy, a.healthy_timeout_seconds, a.maintenance_mode, a.parameters, a.action_schedule_id,
+		       a.requires_approval, a.reviewed_by_user_id, a.reviewed_at, COALESCE(a.review_comment, ''), a.template_snapshot,
+		       at.name as template_name, u.username, COALESCE(r.username, '')
 		FROM action a
 		JOIN action_template at ON a.action_template_id = at.id
 		LEFT JOIN "user" u ON a.created_by_user_id = u.id
+		LEFT JOIN "user" r ON a.reviewed_by_user_id = r.id
 		WHERE a.id = $1
 	`
 
 	var actionFull ActionFull
-	var templateName, username string
+	var templateName, username, reviewer string
+	var snapshot []byte
 	err := pool.QueryRow(context.Background(), actionQuery, id).Scan(
 		&actionFull.Id, &actionFull.ActionTemplateId, &actionFull.Name, &actionFull.Status,
 		&actionFull.CreatedAt, &actionFull.UpdatedAt, &actionFull.StartedAt, &actionFull.CompletedAt,
-		&actionFull.CreatedByUserId, &templateName, &username)
+		&actionFull.CreatedByUserId,
+		&actionFull.ExecutionStrategy, &actionFull.BatchSize, &actionFull.BatchSizePercent, &actionFull.BatchPauseSeconds, &actionFull.StopOnFailure,
+		&actionFull.WaitForHealthy, &actionFull.HealthyTimeoutSeconds, &actionFull.MaintenanceMode, &actionFull.Parameters, &actionFull.ActionScheduleId,
+		&actionFull.RequiresApproval, &actionFull.ReviewedByUserId, &actionFull.ReviewedAt, &actionFull.ReviewComment, &snapshot,
+		&templateName, &username, &reviewer)
 
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
@@ -154,9 +201,13 @@ func GetActionById(pool *pgxpool.Pool, id uint) (*ActionFull, error) {
 		return nil, err
 	}
 	actionFull.Template = ActionTemplate(*template)
+	if err := actionFull.useTemplateSnapshot(snapshot); err != nil {
+		return nil, err
+	}
 
 	// Get the user
 	actionFull.CreatedBy = User{Username: username}
+	actionFull.ReviewedBy = User{Username: reviewer}
 
 	// Get executions
 	executions, err := GetActionExecutionsByActionId(pool, actionFull.Id)
@@ -168,20 +219,49 @@ func GetActionById(pool *pgxpool.Pool, id uint) (*ActionFull, error) {
 	return &actionFull, nil
 }
 
+// Replaces the current template of the action with the copy kept when the action was created. Actions created before copies were kept have none
+func (action *ActionFull) useTemplateSnapshot(snapshot []byte) error {
+	if snapshot == nil {
+		return nil
+	}
+	var template ActionTemplate
+	if err := json.Unmarshal(snapshot, &template); err != nil {
+		return fmt.Errorf("invalid template copy of action %d: %w", action.Id, err)
+	}
+	action.TemplateChanged = !template.UpdatedAt.Equal(action.Template.UpdatedAt)
+	action.Template = template
+	return nil
+}
+
 // GetActionAll retrieves all actions with their template and user info
 func GetActionAll(pool *pgxpool.Pool, limit, offset int) (*[]ActionFull, error) {
+	return getActionsFull(pool, "", limit, offset)
+}
+
+// GetActionsByScheduleId retrieves the actions launched by a schedule, latest first
+func GetActionsByScheduleId(pool *pgxpool.Pool, scheduleId uint, limit, offset int) (*[]ActionFull, error) {
+	return getActionsFull(pool, "WHERE a.action_schedule_id = $3", limit, offset, scheduleId)
+}
+
+// Retrieves actions with their template, user info and executions. The filter may refer to arguments following limit and offset as $3 onwards
+func getActionsFull(pool *pgxpool.Pool, filter string, limit, offset int, args ...any) (*[]ActionFull, error) {
 	query := `
 		SELECT a.id, a.action_template_id, a.name, a.status, a.created_by_user_id, a.created_at, a.started_at, a.completed_at,
-		       at.id, at.name, at.description, at.bash_script, at.created_at, at.updated_at,
-		       u.id, u.username, u.email, u.color, u.password_hash, u.role_id
+		       a.execution_strategy, a.batch_size, a.batch_size_percent, a.batch_pause_seconds, a.stop_on_failure,
+		       a.wait_for_healthy, a.healthy_timeout_seconds, a.maintenance_mode, a.parameters, a.action_schedule_id,
+		       a.requires_approval, a.reviewed_by_user_id, a.reviewed_at, COALESCE(a.review_comment, ''), a.template_snapshot,
+		       at.id, at.name, at.description, at.bash_script, at.maintenance_mode, at.requires_approval, at.parameters, at.file_transfers, at.steps, at.executor, at.webhook, at.created_at, at.updated_at,
+		       u.id, u.username, u.email, u.color, u.password_hash, u.role_id, COALESCE(r.username, '')
 		FROM action a
 		JOIN action_template at ON a.action_template_id = at.id
 		JOIN "user" u ON a.created_by_user_id = u.id
+		LEFT JOIN "user" r ON a.reviewed_by_user_id = r.id
+		` + filter + `
 		ORDER BY a.created_at DESC
 		LIMIT $1 OFFSET $2
 	`
 
-	rows, err := pool.Query(context.Background(), query, limit, offset)
+	rows, err := pool.Query(context.Background(), query, append([]any{limit, offset}, args...)...)
 	if err != nil {
 		return nil, err
 	}
@@ -190,17 +270,25 @@ func GetActionAll(pool *pgxpool.Pool, limit, offset int) (*[]ActionFull, error)
 	var actions []ActionFull
 	for rows.Next() {
 		var action ActionFull
+		var snapshot []byte
 
 		err := rows.Scan(
 			&action.Id, &action.ActionTemplateId, &action.Name, &action.Status,
 			&action.CreatedByUserId, &action.CreatedAt, &action.StartedAt, &action.CompletedAt,
+			&action.ExecutionStrategy, &action.BatchSize, &action.BatchSizePercent, &action.BatchPauseSeconds, &action.StopOnFailure,
+			&action.WaitForHealthy, &action.HealthyTimeoutSeconds, &action.MaintenanceMode, &action.Parameters, &action.ActionScheduleId,
+			&action.RequiresApproval, &action.ReviewedByUserId, &action.ReviewedAt, &action.ReviewComment, &snapshot,
 			&action.Template.Id, &action.Template.Name, &action.Template.Description,
-			&action.Template.BashScript, &action.Template.CreatedAt, &action.Template.UpdatedAt,
+			&action.Template.BashScript, &action.Template.MaintenanceMode, &action.Template.RequiresApproval, &action.Template.Parameters, &action.Template.FileTransfers, &action.Template.Steps, &action.Template.Executor, &action.Template.Webhook,
+			&action.Template.CreatedAt, &action.Template.UpdatedAt,
 			&action.CreatedBy.Id, &action.CreatedBy.Username, &action.CreatedBy.Email,
-			&action.CreatedBy.Color, &action.CreatedBy.PasswordHash, &action.CreatedBy.RoleId)
+			&action.CreatedBy.Color, &action.CreatedBy.PasswordHash, &action.CreatedBy.RoleId, &action.ReviewedBy.Username)
 		if err != nil {
 			return nil, err
 		}
+		if err := action.useTemplateSnapshot(snapshot); err != nil {
+			return nil, err
+		}
 
 		// Get executions for this action
 		executions, err := GetActionExecutionsByActionId(pool, action.Id)
@@ -234,7 +322,7 @@ func UpdateActionStatus(pool *pgxpool.Pool, id uint, status string) error {
 	case "running":
 		query = `UPDATE action SET status = $2, started_at = NOW(), updated_at = NOW() WHERE id = $1`
 		args = []interface{}{id, status}
-	case "completed", "failed":
+	case "completed", "failed", "cancelled":
 		query = `UPDATE action SET status = $2, completed_at = NOW(), updated_at = NOW() WHERE id = $1`
 		args = []interface{}{id, status}
 	default:
@@ -246,20 +334,49 @@ func UpdateActionStatus(pool *pgxpool.Pool, id uint, status string) error {
 	return err
 }
 
+// StartAction marks a pending action as running. Returns false if the action is not pending (anymore), e.g. because it was started concurrently
+func StartAction(pool *pgxpool.Pool, id uint) (bool, error) {
+	query := `UPDATE action SET status = 'running', started_at = NOW(), updated_at = NOW() WHERE id = $1 AND status = 'pending'`
+	tag, err := pool.Exec(context.Background(), query, id)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// ReviewAction approves or rejects an action awaiting approval. Approved actions become pending, so they can be started.
+// Returns false if the action is not awaiting approval (anymore)
+func ReviewAction(pool *pgxpool.Pool, id uint, approved bool, reviewerId uint64, comment string) (bool, error) {
+	status := "rejected"
+	if approved {
+		status = "pending"
+	}
+	query := `
+		UPDATE action
+		SET status = $2, reviewed_by_user_id = $3, reviewed_at = NOW(), review_comment = $4, updated_at = NOW()
+		WHERE id = $1 AND status = 'pending_approval'
+	`
+	tag, err := pool.Exec(context.Background(), query, id, status, reviewerId, comment)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
 // ActionExecution CRUD operations
 
 // CreateActionExecution creates a new action execution
 func CreateActionExecution(pool *pgxpool.Pool, execution *ActionExecution) (*ActionExecution, error) {
 	query := `
-		INSERT INTO action_execution (action_id, application_instance_id, status)
-		VALUES ($1, $2, $3)
-		RETURNING id, action_id, application_instance_id, status, output, error_output, exit_code, started_at, completed_at
+		INSERT INTO action_execution (action_id, application_instance_id, status, position)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, action_id, application_instance_id, status, position, COALESCE(output, ''), COALESCE(error_output, ''), exit_code, started_at, completed_at
 	`
 
 	var result ActionExecution
 	err := pool.QueryRow(context.Background(), query,
-		execution.ActionId, execution.ApplicationInstanceId, execution.Status).Scan(
-		&result.Id, &result.ActionId, &result.ApplicationInstanceId, &result.Status,
+		execution.ActionId, execution.ApplicationInstanceId, execution.Status, execution.Position).Scan(
+		&result.Id, &result.ActionId, &result.ApplicationInstanceId, &result.Status, &result.Position,
 		&result.Output, &result.ErrorOutput, &result.ExitCode, &result.StartedAt, &result.CompletedAt)
 
 	if err != nil {
@@ -272,7 +389,7 @@ func CreateActionExecution(pool *pgxpool.Pool, execution *ActionExecution) (*Act
 // GetActionExecutionsByActionId retrieves all executions for an action
 func GetActionExecutionsByActionId(pool *pgxpool.Pool, actionId uint) (*[]ActionExecution, error) {
 	query := `
-		SELECT ae.id, ae.action_id, ae.application_instance_id, ae.status, ae.output, ae.error_output, 
+		SELECT ae.id, ae.action_id, ae.application_instance_id, ae.status, ae.position, COALESCE(ae.output, ''), COALESCE(ae.error_output, ''),
 		       ae.exit_code, ae.started_at, ae.completed_at,
 		       ai.name as instance_name, ad.name as application_name, s.hostname as server_hostname
 		FROM action_execution ae
@@ -280,7 +397,7 @@ func GetActionExecutionsByActionId(pool *pgxpool.Pool, actionId uint) (*[]Action
 		JOIN application_definition ad ON ai.application_definition_id = ad.id
 		JOIN server s ON ai.server_id = s.server_id
 		WHERE ae.action_id = $1
-		ORDER BY ai.name
+		ORDER BY ae.position, ai.name
 	`
 
 	rows, err := pool.Query(context.Background(), query, actionId)
@@ -292,11 +409,10 @@ func GetActionExecutionsByActionId(pool *pgxpool.Pool, actionId uint) (*[]Action
 	var executions []ActionExecution
 	for rows.Next() {
 		var exec ActionExecution
-		var instanceName, applicationName, serverHostname string
 
-		err := rows.Scan(&exec.Id, &exec.ActionId, &exec.ApplicationInstanceId, &exec.Status,
+		err := rows.Scan(&exec.Id, &exec.ActionId, &exec.ApplicationInstanceId, &exec.Status, &exec.Position,
 			&exec.Output, &exec.ErrorOutput, &exec.ExitCode, &exec.StartedAt, &exec.CompletedAt,
-			&instanceName, &applicationName, &serverHostname)
+			&exec.InstanceName, &exec.ApplicationName, &exec.ServerHostname)
 		if err != nil {
 			return nil, err
 		}
@@ -322,17 +438,41 @@ func UpdateActionExecution(pool *pgxpool.Pool, execution *ActionExecution) error
 	return err
 }
 
+// UpdateActionExecutionOutput updates only the captured output of an action execution, used while the execution is still running
+func UpdateActionExecutionOutput(pool *pgxpool.Pool, id uint, output, errorOutput string) error {
+	query := `
+		UPDATE action_execution
+		SET output = $2, error_output = $3
+		WHERE id = $1
+	`
+
+	_, err := pool.Exec(context.Background(), query, id, output, errorOutput)
+	return err
+}
+
+// CancelActionExecutions marks all unfinished executions of an action as cancelled, keeping their output
+func CancelActionExecutions(pool *pgxpool.Pool, actionId uint) error {
+	query := `
+		UPDATE action_execution
+		SET status = 'cancelled', completed_at = NOW()
+		WHERE action_id = $1 AND status IN ('pending', 'running')
+	`
+
+	_, err := pool.Exec(context.Background(), query, actionId)
+	return err
+}
+
 // GetActionExecutionById retrieves a single action execution
 func GetActionExecutionById(pool *pgxpool.Pool, id uint) (*ActionExecution, error) {
 	query := `
-		SELECT id, action_id, application_instance_id, status, output, error_output, exit_code, started_at, completed_at
+		SELECT id, action_id, application_instance_id, status, position, COALESCE(output, ''), COALESCE(error_output, ''), exit_code, started_at, completed_at
 		FROM action_execution
 		WHERE id = $1
 	`
 
 	var execution ActionExecution
 	err := pool.QueryRow(context.Background(), query, id).Scan(
-		&execution.Id, &execution.ActionId, &execution.ApplicationInstanceId, &execution.Status,
+		&execution.Id, &execution.ActionId, &execution.ApplicationInstanceId, &execution.Status, &execution.Position,
 		&execution.Output, &execution.ErrorOutput, &execution.ExitCode, &execution.StartedAt, &execution.CompletedAt)
 
 	if err != nil {
@@ -371,8 +511,359 @@ func ValidateActionTemplate(template *ActionTemplate) error {
 	if template.Name == "" {
 		return errors.New("template name is required")
 	}
-	if template.BashScript == "" {
-		return errors.New("bash script is required")
+	if template.BashScript == "" && len(template.Steps) == 0 {
+		return errors.New("bash script or steps are required")
+	}
+	if template.BashScript != "" && len(template.Steps) > 0 {
+		return errors.New("a template runs either its bash script or its steps, not both")
+	}
+	if err := validateActionTemplateParameters(template.Parameters); err != nil {
+		return err
+	}
+	if err := validateActionTemplateSteps(template.Steps); err != nil {
+		return err
+	}
+	if err := validateActionTemplateFileTransfers(template.FileTransfers); err != nil {
+		return err
+	}
+	return validateActionTemplateExecutor(template)
+}
+
+// Executors which only Admins may configure: the local one runs scripts on the NAM host itself, and webhooks receive the
+// scripts along with the values of their secrets
+var adminActionTemplateExecutors = []string{"local", "webhook"}
+
+// CheckActionTemplatePrivileges checks that the user may save the template, existing is the stored template or nil for a new one.
+// Only Admins may change whether the actions of a template require approval, as clearing it skips the review of what they run,
+// and only they may save templates using the local or webhook executor
+func CheckActionTemplatePrivileges(user *User, existing, template *ActionTemplate) error {
+	if user != nil && user.HasRole("Admin") {
+		return nil
+	}
+	if existing != nil && existing.RequiresApproval != template.RequiresApproval {
+		return errors.New("only Admins can change whether actions of the template require approval")
+	}
+	if slices.Contains(adminActionTemplateExecutors, template.Executor) || existing != nil && slices.Contains(adminActionTemplateExecutors, existing.Executor) {
+		return errors.New("only Admins can save templates with the local or webhook executor")
+	}
+	return nil
+}
+
+// Validates the executor of a template, defaulting to ssh. Only the ssh executor can transfer files, and only the webhook executor keeps its webhook
+func validateActionTemplateExecutor(template *ActionTemplate) error {
+	switch template.Executor {
+	case "":
+		template.Executor = "ssh"
+	case "ssh", "local", "webhook":
+	default:
+		return fmt.Errorf("unknown executor %q, expected ssh, local or webhook", template.Executor)
+	}
+	if template.Executor == "ssh" {
+		template.Webhook = ActionTemplateWebhook{}
+		return nil
+	}
+
+	transfersFiles := len(template.FileTransfers) > 0 ||
+		slices.ContainsFunc(template.Steps, func(step ActionTemplateStep) bool { return step.Type == "file_copy" })
+	if transfersFiles {
+		return fmt.Errorf("files can only be transferred by the ssh executor, not the %s executor", template.Executor)
+	}
+	if template.Executor == "local" {
+		template.Webhook = ActionTemplateWebhook{}
+		return nil
+	}
+
+	template.Webhook.Url = strings.TrimSpace(template.Webhook.Url)
+	if template.Webhook.Url == "" {
+		return errors.New("the webhook executor needs a webhook URL")
+	}
+	for name := range template.Webhook.Headers {
+		if strings.TrimSpace(name) == "" {
+			return errors.New("webhook header names must not be empty")
+		}
+	}
+	return nil
+}
+
+// Pipeline returns the steps which are run for the template: its steps, or a single script step running its bash script
+func (template *ActionTemplate) Pipeline() []ActionTemplateStep {
+	if len(template.Steps) > 0 {
+		return template.Steps
+	}
+	return []ActionTemplateStep{{Name: "Script", Type: "script", Script: template.BashScript, OnFailure: "abort"}}
+}
+
+// Script returns everything of the template which is rendered for an instance, so it can be checked and previewed at once:
+// its bash script, or its steps each headed by a comment, with the scripts of script steps and the settings of the others as comments
+func (template *ActionTemplate) Script() string {
+	if len(template.Steps) == 0 {
+		return template.BashScript
+	}
+	var script strings.Builder
+	comment := func(format string, args ...any) {
+		for _, line := range strings.Split(fmt.Sprintf(format, args...), "\n") {
+			script.WriteString("# " + line + "\n")
+		}
+	}
+	// Step names are not rendered
+	plain := strings.NewReplacer("{{", "", "}}", "")
+	for i, step := range template.Steps {
+		if i > 0 {
+			script.WriteString("\n")
+		}
+		comment("Step %d: %s (%s)", i+1, plain.Replace(step.Name), step.Type)
+		switch step.Type {
+		case "script":
+			script.WriteString(strings.TrimRight(step.Script, "\n") + "\n")
+		case "file_copy":
+			if step.FileTransfer.Direction == "upload" {
+				comment("Upload file %d to %s", step.FileTransfer.FileId, step.FileTransfer.RemotePath)
+			} else {
+				comment("Download %s", step.FileTransfer.RemotePath)
+			}
+		case "wait_for_healthy":
+			comment("Wait for the instance to report healthy")
+		case "http":
+			comment("%s %s", step.Http.Method, step.Http.Url)
+			names := make([]string, 0, len(step.Http.Headers))
+			for name := range step.Http.Headers {
+				names = append(names, name)
+			}
+			slices.Sort(names)
+			for _, name := range names {
+				comment("%s: %s", name, step.Http.Headers[name])
+			}
+			if step.Http.Body != "" {
+				comment("%s", step.Http.Body)
+			}
+		case "maintenance":
+			comment("%s maintenance mode", strings.ToUpper(step.Maintenance[:1])+step.Maintenance[1:])
+		case "confirmation":
+			comment("Wait for confirmation: %s", step.Message)
+		}
+	}
+	return script.String()
+}
+
+var httpMethods = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}
+
+// Validates the steps of a template and fills in their defaults. Settings of other types than the one of a step are dropped
+func validateActionTemplateSteps(steps []ActionTemplateStep) error {
+	for i := range steps {
+		step := &steps[i]
+		step.Name = strings.TrimSpace(step.Name)
+		if step.Name == "" {
+			step.Name = fmt.Sprintf("Step %d", i+1)
+		}
+		if step.TimeoutSeconds < 0 || step.Retries < 0 || step.RetryDelaySeconds < 0 {
+			return fmt.Errorf("step %s: timeout, retries and retry delay must not be negative", step.Name)
+		}
+		switch step.OnFailure {
+		case "":
+			step.OnFailure = "abort"
+		case "abort", "continue", "ignore":
+		default:
+			return fmt.Errorf("step %s: unknown on failure policy %q, expected abort, continue or ignore", step.Name, step.OnFailure)
+		}
+
+		script, transfer, request, maintenance, message := step.Script, step.FileTransfer, step.Http, step.Maintenance, step.Message
+		step.Script, step.FileTransfer, step.Http, step.Maintenance, step.Message = "", nil, nil, "", ""
+		switch step.Type {
+		case "script":
+			if strings.TrimSpace(script) == "" {
+				return fmt.Errorf("step %s needs a script", step.Name)
+			}
+			step.Script = script
+		case "file_copy":
+			if transfer == nil {
+				return fmt.Errorf("step %s needs a file transfer", step.Name)
+			}
+			transfers := []ActionTemplateFileTransfer{*transfer}
+			if err := validateActionTemplateFileTransfers(transfers); err != nil {
+				return fmt.Errorf("step %s: %w", step.Name, err)
+			}
+			step.FileTransfer = &transfers[0]
+		case "wait_for_healthy":
+			if step.TimeoutSeconds == 0 {
+				step.TimeoutSeconds = 300
+			}
+		case "http":
+			if request == nil || strings.TrimSpace(request.Url) == "" {
+				return fmt.Errorf("step %s needs a URL", step.Name)
+			}
+			request.Method = strings.ToUpper(request.Method)
+			if request.Method == "" {
+				request.Method = "GET"
+			} else if !slices.Contains(httpMethods, request.Method) {
+				return fmt.Errorf("step %s has an unknown HTTP method %s", step.Name, request.Method)
+			}
+			if request.ExpectedStatus != 0 && (request.ExpectedStatus < 100 || request.ExpectedStatus > 599) {
+				return fmt.Errorf("step %s has an invalid expected status %d", step.Name, request.ExpectedStatus)
+			}
+			step.Http = request
+		case "maintenance":
+			if maintenance != "enable" && maintenance != "disable" {
+				return fmt.Errorf("step %s must either enable or disable maintenance mode", step.Name)
+			}
+			step.Maintenance = maintenance
+		case "confirmation":
+			step.Message = strings.TrimSpace(message)
+			if step.Message == "" {
+				step.Message = "Confirm to continue"
+			}
+		default:
+			return fmt.Errorf("step %s has an unknown type %q", step.Name, step.Type)
+		}
+	}
+	return nil
+}
+
+var fileModeRegex = regexp.MustCompile(`^0?[0-7]{3}$`)
+
+// Validates the file transfers of a template, uploads without a mode get 0644
+func validateActionTemplateFileTransfers(transfers []ActionTemplateFileTransfer) error {
+	for i := range transfers {
+		transfer := &transfers[i]
+		if strings.TrimSpace(transfer.RemotePath) == "" {
+			return fmt.Errorf("file transfer %d needs a remote path", i+1)
+		}
+		switch transfer.Direction {
+		case "upload":
+			if transfer.FileId == 0 {
+				return fmt.Errorf("upload to %s needs a file", transfer.RemotePath)
+			}
+			if transfer.Mode == "" {
+				transfer.Mode = "0644"
+			} else if !fileModeRegex.MatchString(transfer.Mode) {
+				return fmt.Errorf("upload to %s has an invalid mode %q, expected octal permissions like 0644", transfer.RemotePath, transfer.Mode)
+			}
+		case "download":
+			transfer.FileId = 0
+			transfer.Mode = ""
+		default:
+			return fmt.Errorf("file transfer %d has an unknown direction: %s", i+1, transfer.Direction)
+		}
+	}
+	return nil
+}
+
+var parameterNameRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Validates the parameter declarations of a template, including their defaults
+func validateActionTemplateParameters(parameters []ActionTemplateParameter) error {
+	seen := make(map[string]bool)
+	for _, parameter := range parameters {
+		if !parameterNameRegex.MatchString(parameter.Name) {
+			return fmt.Errorf("invalid parameter name %q, only letters, digits and underscores are allowed", parameter.Name)
+		}
+		if seen[parameter.Name] {
+			return fmt.Errorf("parameter %s is declared more than once", parameter.Name)
+		}
+		seen[parameter.Name] = true
+		switch parameter.Type {
+		case "string", "int", "bool", "secret":
+		case "enum":
+			if len(parameter.Options) == 0 {
+				return fmt.Errorf("enum parameter %s needs at least one option", parameter.Name)
+			}
+		default:
+			return fmt.Errorf("unknown type %q of parameter %s", parameter.Type, parameter.Name)
+		}
+		if _, err := regexp.Compile(parameter.Regex); err != nil {
+			return fmt.Errorf("invalid regex of parameter %s: %w", parameter.Name, err)
+		}
+		if parameter.Default != "" {
+			if _, err := validateParameterValue(parameter, parameter.Default); err != nil {
+				return fmt.Errorf("invalid default: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// ResolveActionParameters validates the parameter values of an action against the declarations of its template.
+// Parameters without a value get their default. Returns the values to store with the action, bools normalized to true/false
+func ResolveActionParameters(parameters []ActionTemplateParameter, values map[string]string) (map[string]string, error) {
+	resolved := make(map[string]string, len(parameters))
+	for _, parameter := range parameters {
+		value, found := values[parameter.Name]
+		if !found || value == "" {
+			value = parameter.Default
+		}
+		value, err := validateParameterValue(parameter, value)
+		if err != nil {
+			return nil, err
+		}
+		resolved[parameter.Name] = value
+	}
+	for name := range values {
+		if _, found := resolved[name]; !found {
+			return nil, fmt.Errorf("unknown parameter %s", name)
+		}
+	}
+	return resolved, nil
+}
+
+// Validates a single parameter value according to its type and regex, and returns it normalized
+func validateParameterValue(parameter ActionTemplateParameter, value string) (string, error) {
+	switch parameter.Type {
+	case "int":
+		if _, err := strconv.Atoi(value); err != nil {
+			return "", fmt.Errorf("parameter %s must be an integer", parameter.Name)
+		}
+	case "bool":
+		if value == "" {
+			return "false", nil
+		}
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return "", fmt.Errorf("parameter %s must be true or false", parameter.Name)
+		}
+		return strconv.FormatBool(b), nil
+	case "enum":
+		if !slices.Contains(parameter.Options, value) {
+			return "", fmt.Errorf("parameter %s must be one of %s", parameter.Name, strings.Join(parameter.Options, ", "))
+		}
+	case "secret":
+		if value == "" {
+			return "", fmt.Errorf("parameter %s needs the name of a secret", parameter.Name)
+		}
+	}
+	if parameter.Regex != "" && (parameter.Type == "string" || parameter.Type == "int") {
+		// Anchored, so the whole value has to match
+		if matched, err := regexp.MatchString(`^(?:`+parameter.Regex+`)$`, value); err != nil || !matched {
+			return "", fmt.Errorf("parameter %s must match %s", parameter.Name, parameter.Regex)
+		}
+	}
+	return value, nil
+}
+
+// ValidateActionStrategy validates the execution strategy of an action, an empty strategy defaults to parallel and the healthy timeout to 5 minutes
+func ValidateActionStrategy(action *Action) error {
+	switch action.ExecutionStrategy {
+	case "":
+		action.ExecutionStrategy = "parallel"
+	case "parallel", "sequential":
+	case "batch":
+		if action.BatchSize < 1 {
+			return errors.New("batch size must be at least 1")
+		}
+		if action.BatchSizePercent && action.BatchSize > 100 {
+			return errors.New("batch size percentage must be between 1 and 100")
+		}
+	default:
+		return fmt.Errorf("unknown execution strategy: %s", action.ExecutionStrategy)
+	}
+	if action.BatchSize < 1 {
+		action.BatchSize = 1
+	}
+	if action.BatchPauseSeconds < 0 {
+		return errors.New("pause between batches cannot be negative")
+	}
+	if action.HealthyTimeoutSeconds == 0 {
+		action.HealthyTimeoutSeconds = 300
+	} else if action.HealthyTimeoutSeconds < 0 {
+		return errors.New("timeout for instances to report healthy cannot be negative")
 	}
 	return nil
 }
@@ -0,0 +1,183 @@
+package data
+
+import (
+	"context"
+	"maps"
+	"sync"
+	"testing"
+)
+
+func TestValidateActionTemplateParameters(t *testing.T) {
+	tests := []struct {
+		name       string
+		parameters []ActionTemplateParameter
+		wantErr    bool
+	}{
+		{"none", nil, false},
+		{"all types", []ActionTemplateParameter{
+			{Name: "VERSION", Type: "string", Regex: `\d+\.\d+`, Default: "1.0"},
+			{Name: "COUNT", Type: "int", Default: "2"},
+			{Name: "DRY_RUN", Type: "bool"},
+			{Name: "MODE", Type: "enum", Options: []string{"fast", "safe"}, Default: "safe"},
+			{Name: "TOKEN", Type: "secret"},
+		}, false},
+		{"invalid name", []ActionTemplateParameter{{Name: "1ST", Type: "string"}}, true},
+		{"name with dash", []ActionTemplateParameter{{Name: "LOG-LEVEL", Type: "string"}}, true},
+		{"duplicate name", []ActionTemplateParameter{{Name: "A", Type: "string"}, {Name: "A", Type: "int"}}, true},
+		{"unknown type", []ActionTemplateParameter{{Name: "A", Type: "float"}}, true},
+		{"enum without options", []ActionTemplateParameter{{Name: "A", Type: "enum"}}, true},
+		{"invalid regex", []ActionTemplateParameter{{Name: "A", Type: "string", Regex: "("}}, true},
+		{"default not matching regex", []ActionTemplateParameter{{Name: "A", Type: "string", Regex: `\d+`, Default: "x"}}, true},
+		{"default not an option", []ActionTemplateParameter{{Name: "A", Type: "enum", Options: []string{"a"}, Default: "b"}}, true},
+		{"default not an int", []ActionTemplateParameter{{Name: "A", Type: "int", Default: "two"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateActionTemplateParameters(tt.parameters)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateActionTemplateParameters() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestResolveActionParameters(t *testing.T) {
+	parameters := []ActionTemplateParameter{
+		{Name: "VERSION", Type: "string", Regex: `\d+\.\d+`},
+		{Name: "NOTE", Type: "string"},
+		{Name: "COUNT", Type: "int", Default: "2"},
+		{Name: "DRY_RUN", Type: "bool"},
+		{Name: "MODE", Type: "enum", Options: []string{"fast", "safe"}, Default: "safe"},
+		{Name: "TOKEN", Type: "secret", Default: "deploy-token"},
+	}
+	defaults := map[string]string{"NOTE": "", "COUNT": "2", "DRY_RUN": "false", "MODE": "safe", "TOKEN": "deploy-token"}
+	with := func(values map[string]string) map[string]string {
+		resolved := maps.Clone(defaults)
+		maps.Copy(resolved, values)
+		return resolved
+	}
+
+	tests := []struct {
+		name    string
+		values  map[string]string
+		want    map[string]string
+		wantErr bool
+	}{
+		{"defaults", map[string]string{"VERSION": "1.2"}, with(map[string]string{"VERSION": "1.2"}), false},
+		{"empty values get defaults", map[string]string{"VERSION": "1.2", "COUNT": "", "MODE": ""}, with(map[string]string{"VERSION": "1.2"}), false},
+		{"bools are normalized", map[string]string{"VERSION": "1.2", "DRY_RUN": "1"}, with(map[string]string{"VERSION": "1.2", "DRY_RUN": "true"}), false},
+		{"free-form string", map[string]string{"VERSION": "1.2", "NOTE": "x; rm -rf ~"}, with(map[string]string{"VERSION": "1.2", "NOTE": "x; rm -rf ~"}), false},
+		{"regex is anchored", map[string]string{"VERSION": "1.2; reboot"}, nil, true},
+		{"missing value matching regex", map[string]string{}, nil, true},
+		{"int", map[string]string{"VERSION": "1.2", "COUNT": "2x"}, nil, true},
+		{"bool", map[string]string{"VERSION": "1.2", "DRY_RUN": "maybe"}, nil, true},
+		{"enum", map[string]string{"VERSION": "1.2", "MODE": "slow"}, nil, true},
+		{"unknown parameter", map[string]string{"VERSION": "1.2", "OTHER": "x"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ResolveActionParameters(parameters, tt.values)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ResolveActionParameters() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !maps.Equal(got, tt.want) {
+				t.Errorf("ResolveActionParameters() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckActionTemplatePrivileges(t *testing.T) {
+	admin, operator := &User{RoleId: 1}, &User{RoleId: 2}
+	tests := []struct {
+		name     string
+		user     *User
+		existing *ActionTemplate
+		template ActionTemplate
+		wantErr  bool
+	}{
+		{"operator creates ssh template", operator, nil, ActionTemplate{Executor: "ssh", RequiresApproval: false}, false},
+		{"operator creates template requiring approval", operator, nil, ActionTemplate{Executor: "ssh", RequiresApproval: true}, false},
+		{"operator keeps approval", operator, &ActionTemplate{Executor: "ssh", RequiresApproval: true}, ActionTemplate{Executor: "ssh", RequiresApproval: true}, false},
+		{"operator clears approval", operator, &ActionTemplate{Executor: "ssh", RequiresApproval: true}, ActionTemplate{Executor: "ssh"}, true},
+		{"operator sets approval", operator, &ActionTemplate{Executor: "ssh"}, ActionTemplate{Executor: "ssh", RequiresApproval: true}, true},
+		{"operator creates local template", operator, nil, ActionTemplate{Executor: "local"}, true},
+		{"operator creates webhook template", operator, nil, ActionTemplate{Executor: "webhook"}, true},
+		{"operator switches to local", operator, &ActionTemplate{Executor: "ssh"}, ActionTemplate{Executor: "local"}, true},
+		{"operator edits local template", operator, &ActionTemplate{Executor: "local"}, ActionTemplate{Executor: "local"}, true},
+		{"operator switches local template to ssh", operator, &ActionTemplate{Executor: "local"}, ActionTemplate{Executor: "ssh"}, true},
+		{"unknown user", nil, nil, ActionTemplate{Executor: "webhook"}, true},
+		{"admin clears approval", admin, &ActionTemplate{Executor: "ssh", RequiresApproval: true}, ActionTemplate{Executor: "ssh"}, false},
+		{"admin creates local template", admin, nil, ActionTemplate{Executor: "local"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckActionTemplatePrivileges(tt.user, tt.existing, &tt.template)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CheckActionTemplatePrivileges() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCreateActionWithExecutions(t *testing.T) {
+	pool := testDatabasePool(t)
+	ctx := context.Background()
+	var templateId uint
+	if err := pool.QueryRow(ctx, `INSERT INTO action_template (name, bash_script) VALUES ('create test', 'true') RETURNING id`).Scan(&templateId); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pool.Exec(ctx, `DELETE FROM action_template WHERE id = $1`, templateId) })
+
+	// Instance 0 never exists, so its execution can't be created and neither is the action
+	if _, err := CreateAction(pool, &Action{ActionTemplateId: templateId, Name: "create test", Status: "pending"}, []uint{0}); err == nil {
+		t.Fatal("expected the action to fail")
+	}
+	var count int
+	if err := pool.QueryRow(ctx, `SELECT COUNT(*) FROM action WHERE action_template_id = $1`, templateId).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Errorf("created %d actions without their executions", count)
+	}
+}
+
+// Of concurrent starts of an action only one succeeds, so its executions run once
+func TestStartActionOnce(t *testing.T) {
+	pool := testDatabasePool(t)
+	ctx := context.Background()
+	var templateId uint
+	if err := pool.QueryRow(ctx, `INSERT INTO action_template (name, bash_script) VALUES ('start test', 'true') RETURNING id`).Scan(&templateId); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pool.Exec(ctx, `DELETE FROM action_template WHERE id = $1`, templateId) })
+	action, err := CreateAction(pool, &Action{ActionTemplateId: templateId, Name: "start test", Status: "pending"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	results := make(chan bool, 10)
+	for range cap(results) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			started, err := StartAction(pool, action.Id)
+			if err != nil {
+				t.Error(err)
+			}
+			results <- started
+		}()
+	}
+	wg.Wait()
+	close(results)
+	starts := 0
+	for started := range results {
+		if started {
+			starts++
+		}
+	}
+	if starts != 1 {
+		t.Errorf("action was started %d times", starts)
+	}
+}
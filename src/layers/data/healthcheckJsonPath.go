@@ -0,0 +1,268 @@
+package data
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// This file provides the jsonpath response validation of healthchecks. Its expected response holds one assertion per line,
+// e.g. for a Spring actuator:
+//
+//	$.status == "UP"
+//	$.components.db.status == "UP"
+//	$.components.diskSpace.details.free > 1073741824
+//	$.components['discovery-client']
+//
+// An assertion is a path, starting at the document with $ and followed by .key, ['key'] or [index] (negative indexes
+// count from the end), and optionally an operator (==, !=, <, <=, >, >=, =~) with a JSON value. A path on its own
+// asserts that it exists. =~ matches a regular expression against strings, or against the JSON of other values.
+
+// Checked longest first, so <= is not taken for <
+var jsonPathOperators = []string{"==", "!=", "<=", ">=", "=~", "<", ">"}
+
+// Longest actual value quoted when an assertion fails
+const maxJsonPathReportedValue = 100
+
+type jsonPathAssertion struct {
+	expression string
+	path       []any // string keys and int indexes
+	operator   string
+	value      any
+	pattern    *regexp.Regexp
+}
+
+// Parses the assertions of a jsonpath validation, blank lines are skipped
+func parseJsonPathAssertions(expected string) ([]jsonPathAssertion, error) {
+	var assertions []jsonPathAssertion
+	for i, line := range strings.Split(expected, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		assertion, err := parseJsonPathAssertion(line)
+		if err != nil {
+			return nil, fmt.Errorf("invalid JSON path assertion on line %d: %w", i+1, err)
+		}
+		assertions = append(assertions, assertion)
+	}
+	if len(assertions) == 0 {
+		return nil, errors.New("JSON path validation requires at least one assertion")
+	}
+	return assertions, nil
+}
+
+func parseJsonPathAssertion(expression string) (jsonPathAssertion, error) {
+	assertion := jsonPathAssertion{expression: expression}
+	path, rest, err := parseJsonPath(expression)
+	if err != nil {
+		return assertion, err
+	}
+	assertion.path = path
+	rest = strings.TrimSpace(rest)
+	if rest == "" {
+		return assertion, nil
+	}
+	for _, operator := range jsonPathOperators {
+		if strings.HasPrefix(rest, operator) {
+			assertion.operator = operator
+			rest = strings.TrimSpace(rest[len(operator):])
+			break
+		}
+	}
+	if assertion.operator == "" {
+		return assertion, fmt.Errorf("unknown operator in %q, expected one of %s", rest, strings.Join(jsonPathOperators, " "))
+	}
+	if rest == "" {
+		return assertion, fmt.Errorf("missing value after %s", assertion.operator)
+	}
+	if err := json.Unmarshal([]byte(rest), &assertion.value); err != nil {
+		return assertion, fmt.Errorf("value %s is not JSON, strings need double quotes", rest)
+	}
+	switch assertion.operator {
+	case "<", "<=", ">", ">=":
+		if _, ok := assertion.value.(float64); !ok {
+			return assertion, fmt.Errorf("%s requires a number", assertion.operator)
+		}
+	case "=~":
+		pattern, ok := assertion.value.(string)
+		if !ok {
+			return assertion, errors.New("=~ requires a regular expression string")
+		}
+		if assertion.pattern, err = regexp.Compile(pattern); err != nil {
+			return assertion, err
+		}
+	}
+	return assertion, nil
+}
+
+// Parses the path at the start of the expression and returns what follows it
+func parseJsonPath(expression string) ([]any, string, error) {
+	if !strings.HasPrefix(expression, "$") {
+		return nil, "", errors.New("path must start with $")
+	}
+	path := []any{}
+	rest := expression[1:]
+	for {
+		switch {
+		case strings.HasPrefix(rest, "."):
+			end := strings.IndexAny(rest[1:], ".[ \t=!<>")
+			if end == -1 {
+				end = len(rest) - 1
+			}
+			if end == 0 {
+				return nil, "", errors.New("missing key after .")
+			}
+			path = append(path, rest[1:end+1])
+			rest = rest[end+1:]
+		case strings.HasPrefix(rest, "['"), strings.HasPrefix(rest, `["`):
+			end := strings.Index(rest[2:], rest[1:2]+"]")
+			if end == -1 {
+				return nil, "", errors.New("unterminated key in brackets")
+			}
+			path = append(path, rest[2:end+2])
+			rest = rest[end+4:]
+		case strings.HasPrefix(rest, "["):
+			end := strings.Index(rest, "]")
+			if end == -1 {
+				return nil, "", errors.New("unterminated index in brackets")
+			}
+			index, err := strconv.Atoi(strings.TrimSpace(rest[1:end]))
+			if err != nil {
+				return nil, "", fmt.Errorf("index %s is not a number", rest[1:end])
+			}
+			path = append(path, index)
+			rest = rest[end+1:]
+		default:
+			return path, rest, nil
+		}
+	}
+}
+
+// Resolves the path in the document, the second value is false if it does not exist
+func resolveJsonPath(document any, path []any) (any, bool) {
+	current := document
+	for _, segment := range path {
+		switch segment := segment.(type) {
+		case string:
+			object, ok := current.(map[string]any)
+			if !ok {
+				return nil, false
+			}
+			if current, ok = object[segment]; !ok {
+				return nil, false
+			}
+		case int:
+			array, ok := current.([]any)
+			if !ok {
+				return nil, false
+			}
+			if segment < 0 {
+				segment += len(array)
+			}
+			if segment < 0 || segment >= len(array) {
+				return nil, false
+			}
+			current = array[segment]
+		}
+	}
+	return current, true
+}
+
+// Evaluates the assertion against the document. Returns why it failed, empty if it holds
+func (assertion jsonPathAssertion) evaluate(document any) string {
+	actual, found := resolveJsonPath(document, assertion.path)
+	if !found {
+		return "not found"
+	}
+	encoded, _ := json.Marshal(actual)
+	got := "got " + truncateString(string(encoded), maxJsonPathReportedValue)
+	switch assertion.operator {
+	case "":
+		return ""
+	case "==":
+		if !reflect.DeepEqual(actual, assertion.value) {
+			return got
+		}
+	case "!=":
+		if reflect.DeepEqual(actual, assertion.value) {
+			return got
+		}
+	case "<", "<=", ">", ">=":
+		number, ok := actual.(float64)
+		if !ok {
+			return got + ", not a number"
+		}
+		limit := assertion.value.(float64)
+		holds := map[string]bool{"<": number < limit, "<=": number <= limit, ">": number > limit, ">=": number >= limit}
+		if !holds[assertion.operator] {
+			return got
+		}
+	case "=~":
+		text, ok := actual.(string)
+		if !ok {
+			text = string(encoded)
+		}
+		if !assertion.pattern.MatchString(text) {
+			return got
+		}
+	}
+	return ""
+}
+
+// Parses the expected response of the check once it is saved or loaded, so its probes don't have to.
+// Fails if its jsonpath assertions are invalid
+func (hc *Healthcheck) parseResponseValidation() error {
+	hc.jsonPathAssertions = nil
+	if hc.ResponseValidation != "jsonpath" {
+		return nil
+	}
+	assertions, err := parseJsonPathAssertions(hc.ExpectedResponseBody)
+	if err != nil {
+		return err
+	}
+	hc.jsonPathAssertions = assertions
+	return nil
+}
+
+// Validates the body against all jsonpath assertions of the check, the message lists every assertion that failed
+func (hc *Healthcheck) validateJsonPath(body string) (bool, string) {
+	assertions := hc.jsonPathAssertions
+	if assertions == nil {
+		// Invalid assertions were stored before they were validated, they fail every probe
+		var err error
+		if assertions, err = parseJsonPathAssertions(hc.ExpectedResponseBody); err != nil {
+			return false, err.Error()
+		}
+	}
+	var document any
+	if err := json.Unmarshal([]byte(body), &document); err != nil {
+		return false, "Response body is not valid JSON: " + err.Error()
+	}
+	var failed []string
+	for _, assertion := range assertions {
+		if reason := assertion.evaluate(document); reason != "" {
+			failed = append(failed, fmt.Sprintf("%s (%s)", assertion.expression, reason))
+		}
+	}
+	if len(failed) > 0 {
+		return false, fmt.Sprintf("%d of %d JSON path assertions failed: %s", len(failed), len(assertions), strings.Join(failed, "; "))
+	}
+	return true, ""
+}
+
+// Cuts the string to at most length bytes, without splitting a character
+func truncateString(s string, length int) string {
+	if len(s) <= length {
+		return s
+	}
+	for length > 0 && !utf8.RuneStart(s[length]) {
+		length--
+	}
+	return s[:length] + "..."
+}
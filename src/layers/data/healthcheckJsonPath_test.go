@@ -0,0 +1,171 @@
+package data
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestParseJsonPathAssertions(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected string
+		wantErr  bool
+	}{
+		{"existence", "$.status", false},
+		{"several with blank lines", "$.status == \"UP\"\n\n  $.components.db.status != \"DOWN\"  \n", false},
+		{"bracket keys and indexes", `$['discovery-client'].instances[0]["zone"] == "a"`, false},
+		{"negative index", "$.items[-1].id >= 3", false},
+		{"all operators", "$.a == 1\n$.a != 2\n$.a < 3\n$.a <= 3\n$.a > 0\n$.a >= 1\n$.b =~ \"^v\\\\d+\"", false},
+		{"document itself", "$ != null", false},
+		{"no assertions", "\n  \n", true},
+		{"not starting at the document", "status == \"UP\"", true},
+		{"missing key after dot", "$..status", true},
+		{"unterminated key", "$['status == 1", true},
+		{"unterminated index", "$.items[0 == 1", true},
+		{"index not a number", "$.items[first]", true},
+		{"unknown operator", "$.status = \"UP\"", true},
+		{"missing value", "$.status ==", true},
+		{"value not JSON", "$.status == UP", true},
+		{"comparison with a string", "$.free > \"1\"", true},
+		{"pattern not a string", "$.version =~ 1", true},
+		{"invalid pattern", "$.version =~ \"(\"", true},
+		{"error names the line", "$.status\n$.free > x", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseJsonPathAssertions(tt.expected)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseJsonPathAssertions(%q) error = %v, wantErr %v", tt.expected, err, tt.wantErr)
+			}
+		})
+	}
+	if _, err := parseJsonPathAssertions("$.status\n$.free > x"); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("error %v does not name line 2", err)
+	}
+}
+
+func TestValidateJsonPath(t *testing.T) {
+	body := `{
+		"status": "UP",
+		"version": "v2.14.1",
+		"components": {"db": {"status": "UP", "details": {"free": 2147483648}}, "cache": null},
+		"discovery-client": {"instances": [{"zone": "a"}, {"zone": "b"}]},
+		"items": [1, 2, 3],
+		"enabled": true,
+		"tags": []
+	}`
+	tests := []struct {
+		name       string
+		assertions string
+		valid      bool
+		message    string // Part of the message if the body is invalid
+	}{
+		{"equal string", `$.status == "UP"`, true, ""},
+		{"nested", `$.components.db.status == "UP"`, true, ""},
+		{"number comparison", "$.components.db.details.free > 1073741824", true, ""},
+		{"bool", "$.enabled == true", true, ""},
+		{"null exists", "$.components.cache", true, ""},
+		{"equal null", "$.components.cache == null", true, ""},
+		{"bracket key", `$['discovery-client'].instances[1].zone == "b"`, true, ""},
+		{"array index", "$.items[0] == 1", true, ""},
+		{"negative index", "$.items[-1] == 3", true, ""},
+		{"whole array", "$.items == [1, 2, 3]", true, ""},
+		{"empty array", "$.tags == []", true, ""},
+		{"object", `$['discovery-client'].instances[0] == {"zone": "a"}`, true, ""},
+		{"pattern", `$.version =~ "^v2\\."`, true, ""},
+		{"pattern against JSON of a number", `$.items[2] =~ "^3$"`, true, ""},
+
+		{"missing key", "$.components.disk.status", false, "$.components.disk.status (not found)"},
+		{"missing top level key", `$.state == "UP"`, false, "not found"},
+		{"index out of range", "$.items[3]", false, "$.items[3] (not found)"},
+		{"negative index out of range", "$.items[-4]", false, "not found"},
+		{"index into an object", "$.components[0]", false, "not found"},
+		{"key of an array", "$.items.length", false, "not found"},
+		{"key of a string", "$.status.value", false, "not found"},
+		{"not equal", `$.status == "DOWN"`, false, `got "UP"`},
+		{"number is not a string", `$.items[0] == "1"`, false, "got 1"},
+		{"string is not a number", "$.status > 1", false, "not a number"},
+		{"bool is not a string", `$.enabled == "true"`, false, "got true"},
+		{"pattern does not match", `$.version =~ "^v3"`, false, `got "v2.14.1"`},
+		{"all failures are listed", "$.status == \"DOWN\"\n$.enabled\n$.items[9]", false, "2 of 3 JSON path assertions failed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hc := &Healthcheck{ResponseValidation: "jsonpath", ExpectedResponseBody: tt.assertions}
+			if err := hc.parseResponseValidation(); err != nil {
+				t.Fatal(err)
+			}
+			valid, message := hc.validateResponse(body)
+			if valid != tt.valid {
+				t.Fatalf("valid = %v, want %v: %s", valid, tt.valid, message)
+			}
+			if !strings.Contains(message, tt.message) {
+				t.Errorf("message %q does not contain %q", message, tt.message)
+			}
+		})
+	}
+}
+
+func TestValidateJsonPathInvalidBody(t *testing.T) {
+	hc := &Healthcheck{ResponseValidation: "jsonpath", ExpectedResponseBody: "$.status"}
+	if err := hc.parseResponseValidation(); err != nil {
+		t.Fatal(err)
+	}
+	if valid, message := hc.validateResponse("<html>"); valid || !strings.Contains(message, "not valid JSON") {
+		t.Errorf("valid = %v, message = %q", valid, message)
+	}
+}
+
+// Assertions stored before they were validated fail every probe with the reason
+func TestValidateJsonPathUnparsed(t *testing.T) {
+	hc := &Healthcheck{ResponseValidation: "jsonpath", ExpectedResponseBody: "status"}
+	if err := hc.parseResponseValidation(); err == nil {
+		t.Fatal("expected an error")
+	}
+	if valid, message := hc.validateResponse(`{"status": "UP"}`); valid || !strings.Contains(message, "must start with $") {
+		t.Errorf("valid = %v, message = %q", valid, message)
+	}
+}
+
+func TestHealthcheckDTORejectsInvalidJsonPath(t *testing.T) {
+	jsonpath := "jsonpath"
+	dto := HealthcheckDTO{Name: "check", ReqUrl: "/health", ReqMethod: "GET", ReqTimeout: 5, CheckInterval: 30, ExpectedStatus: 200,
+		Protocol: "http", ResponseValidation: &jsonpath, ExpectedResponseBody: "$.status == UP"}
+	if _, err := dto.ToHealthcheck(); err == nil {
+		t.Fatal("expected invalid assertions to be rejected")
+	}
+	dto.ExpectedResponseBody = `$.status == "UP"`
+	hc, err := dto.ToHealthcheck()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hc.jsonPathAssertions) != 1 {
+		t.Errorf("assertions were not parsed: %+v", hc.jsonPathAssertions)
+	}
+}
+
+func TestTruncateString(t *testing.T) {
+	tests := []struct {
+		s      string
+		length int
+		want   string
+	}{
+		{"short", 10, "short"},
+		{"exactly", 7, "exactly"},
+		{"truncated", 5, "trunc..."},
+		{"héllo", 2, "h..."}, // é takes two bytes, it is not split
+		{"héllo", 3, "hé..."},
+		{"日本語", 4, "日..."},
+		{"日本語", 2, "..."},
+	}
+	for _, tt := range tests {
+		got := truncateString(tt.s, tt.length)
+		if got != tt.want {
+			t.Errorf("truncateString(%q, %d) = %q, want %q", tt.s, tt.length, got, tt.want)
+		}
+		if !utf8.ValidString(got) {
+			t.Errorf("truncateString(%q, %d) = %q is not valid UTF-8", tt.s, tt.length, got)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package data
 import (
 	"context"
 	"errors"
+	"math"
 	"time"
 
 	"github.com/jackc/pgx/v5"
@@ -22,13 +23,15 @@ func (hr HealthcheckResult) DbInsert(pool *pgxpool.Pool) (*uint64, error) {
 		INSERT INTO healthcheck_results (
 			healthcheck_id, application_instance_id, is_successful,
 			time_start, time_end, res_status, res_body,
-			res_time, error_message
+			res_time, error_message, warning_message,
+			cert_subject, cert_issuer, cert_sans, cert_not_after, cert_chain
 		) VALUES (
-			$1, $2, $3, $4, $5, $6, $7, $8, $9
+			$1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15
 		) RETURNING id;
 	`, hr.HealthcheckID, hr.ApplicationInstanceID, hr.IsSuccessful,
 		hr.TimeStart, hr.TimeEnd, hr.ResStatus, hr.ResBody,
-		hr.ResTime, hr.ErrorMessage).Scan(&hr.Id)
+		hr.ResTime, hr.ErrorMessage, hr.WarningMessage,
+		hr.CertSubject, hr.CertIssuer, hr.CertSans, hr.CertNotAfter, hr.CertChain).Scan(&hr.Id)
 	if err != nil {
 		return nil, err
 	}
@@ -259,13 +262,15 @@ func HealthcheckResultBatchInsert(pool *pgxpool.Pool, hrs *[]HealthcheckResult)
 		INSERT INTO healthcheck_results (
 			healthcheck_id, application_instance_id, is_successful,
 			time_start, time_end, res_status, res_body,
-			res_time, error_message
+			res_time, error_message, warning_message,
+			cert_subject, cert_issuer, cert_sans, cert_not_after, cert_chain
 		) VALUES (
-			$1, $2, $3, $4, $5, $6, $7, $8, $9
+			$1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15
 		) RETURNING id;
 		`, hr.HealthcheckID, hr.ApplicationInstanceID, hr.IsSuccessful,
 			hr.TimeStart, hr.TimeEnd, hr.ResStatus, hr.ResBody,
-			hr.ResTime, hr.ErrorMessage).Scan(&hr.Id)
+			hr.ResTime, hr.ErrorMessage, hr.WarningMessage,
+			hr.CertSubject, hr.CertIssuer, hr.CertSans, hr.CertNotAfter, hr.CertChain).Scan(&hr.Id)
 		if err != nil {
 			return err
 		}
@@ -325,3 +330,54 @@ func GetHealthcheckResultById(pool *pgxpool.Pool, id uint) (*HealthcheckResult,
 
 	return &res, tx.Commit(context.Background())
 }
+
+// InstanceCertificate is the certificate an instance presented to the latest https check which received one
+type InstanceCertificate struct {
+	ApplicationInstanceID uint                     `json:"application_instance_id" db:"application_instance_id"`
+	InstanceName          string                   `json:"instance_name" db:"instance_name"`
+	ServerHostname        *string                  `json:"server_hostname" db:"server_hostname"`
+	HealthcheckID         uint                     `json:"healthcheck_id" db:"healthcheck_id"`
+	HealthcheckName       string                   `json:"healthcheck_name" db:"healthcheck_name"`
+	CertWarnDays          int                      `json:"cert_warn_days" db:"cert_warn_days"`
+	CertFailDays          int                      `json:"cert_fail_days" db:"cert_fail_days"`
+	CheckedAt             time.Time                `json:"checked_at" db:"checked_at"`
+	CertSubject           *string                  `json:"cert_subject" db:"cert_subject"`
+	CertIssuer            *string                  `json:"cert_issuer" db:"cert_issuer"`
+	CertSans              []string                 `json:"cert_sans" db:"cert_sans"`
+	CertNotAfter          time.Time                `json:"cert_not_after" db:"cert_not_after"`
+	CertChain             []HealthcheckCertificate `json:"cert_chain" db:"cert_chain"`
+}
+
+// DaysLeft returns the whole days until the certificate expires, negative once it expired
+func (ic InstanceCertificate) DaysLeft() int {
+	return int(math.Floor(time.Until(ic.CertNotAfter).Hours() / 24))
+}
+
+// GetInstanceCertificates returns the latest certificate of every instance which is checked over https, the first to expire first.
+// Certificates of healthchecks the instance is no longer checked with are left out
+func GetInstanceCertificates(pool *pgxpool.Pool) (*[]InstanceCertificate, error) {
+	rows, err := pool.Query(context.Background(), `
+		SELECT * FROM (
+			SELECT DISTINCT ON (hcr.application_instance_id)
+				hcr.application_instance_id, ai.name AS instance_name, s.hostname AS server_hostname,
+				hc.id AS healthcheck_id, hc.name AS healthcheck_name, hc.cert_warn_days, hc.cert_fail_days,
+				hcr.time_start AS checked_at, hcr.cert_subject, hcr.cert_issuer, hcr.cert_sans, hcr.cert_not_after, hcr.cert_chain
+			FROM healthcheck_results hcr
+			JOIN application_instance ai ON ai.id = hcr.application_instance_id
+			JOIN application_definition ad ON ad.id = ai.application_definition_id AND ad.healthcheck_id = hcr.healthcheck_id
+			JOIN healthcheck hc ON hc.id = hcr.healthcheck_id
+			LEFT JOIN "server" s ON s.id = ai.server_id
+			WHERE hcr.cert_not_after IS NOT NULL
+			ORDER BY hcr.application_instance_id, hcr.time_start DESC
+		) certificates
+		ORDER BY cert_not_after ASC, instance_name ASC;
+	`)
+	if err != nil {
+		return nil, err
+	}
+	res, err := pgx.CollectRows(rows, pgx.RowToStructByName[InstanceCertificate])
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
@@ -3,12 +3,16 @@ package data
 import (
 	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"regexp"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/jackc/pgx/v5"
@@ -35,6 +39,9 @@ func GetHealthChecksAll(pool *pgxpool.Pool) (*[]Healthcheck, error) {
 	if err != nil {
 		return nil, err
 	}
+	for i := range res {
+		res[i].parseResponseValidation() // Invalid assertions fail the probes of the check
+	}
 
 	return &res, tx.Commit(context.Background())
 }
@@ -58,19 +65,21 @@ func (hc *Healthcheck) DbInsert(pool *pgxpool.Pool) (*uint, error) {
             name, description, url, method, headers, body, 
             timeout, check_interval, retry_count, retry_interval,
             expected_status, expected_response_body, response_validation,
-            verify_ssl, auth_type, auth_credentials, protocol
+            verify_ssl, auth_type, auth_credentials, protocol,
+            cert_warn_days, cert_fail_days
         ) VALUES (
             $1, $2, $3, $4, $5, $6, 
             $7, $8, $9, $10,
             $11, $12, $13,
             $14, $15, $16,
-            $17
+            $17, $18, $19
         ) RETURNING id
     `,
 		hc.Name, hc.Description, hc.ReqUrl, hc.ReqMethod, headersJSON, hc.ReqBody,
 		hc.ReqTimeout, hc.CheckInterval, hc.RetryCount, hc.RetryInterval,
 		hc.ExpectedStatus, hc.ExpectedResponseBody, hc.ResponseValidation,
 		hc.VerifySSL, hc.AuthType, hc.AuthCredentials, hc.Protocol,
+		hc.CertWarnDays, hc.CertFailDays,
 	).Scan(&hc.Id)
 
 	if err != nil {
@@ -103,6 +112,7 @@ func GetHealthCheckById(pool *pgxpool.Pool, id uint) (*Healthcheck, error) {
 	} else if err != nil {
 		return nil, err
 	}
+	hc.parseResponseValidation() // Invalid assertions fail the probes of the check
 
 	// Parse headers from JSON
 	/*var headers []http.Header
@@ -146,14 +156,17 @@ func (hc *Healthcheck) Update(pool *pgxpool.Pool) error {
             verify_ssl = $14,
             auth_type = $15,
             auth_credentials = $16,
-            protocol = $17
-        WHERE id = $18;
+            protocol = $17,
+            cert_warn_days = $18,
+            cert_fail_days = $19
+        WHERE id = $20;
     `,
 		hc.Name, hc.Description, hc.ReqUrl, hc.ReqMethod, headersJSON, hc.ReqBody,
 		hc.ReqTimeout, hc.CheckInterval, hc.RetryCount, hc.RetryInterval,
 		hc.ExpectedStatus, hc.ExpectedResponseBody, hc.ResponseValidation,
 		hc.VerifySSL,
 		hc.AuthType, hc.AuthCredentials, hc.Protocol,
+		hc.CertWarnDays, hc.CertFailDays,
 		hc.Id,
 	)
 
@@ -183,6 +196,12 @@ func DeleteHealthCheckById(pool *pgxpool.Pool, id uint) error {
 	return tx.Commit(context.Background())
 }
 
+// Only the start of the response to a TCP healthcheck is read
+const maxTcpResponseSize = 64 << 10
+
+// Timeout of checks without one of their own
+const defaultHealthcheckTimeout = 10 * time.Second
+
 type HealthcheckTarget struct {
 	HealthcheckID         uint   `db:"hc_id"`
 	ApplicationInstanceID uint   `db:"application_instance_id"`
@@ -191,30 +210,154 @@ type HealthcheckTarget struct {
 	Url                   string `db:"url"`
 }
 
-// Performs health check, returns the result
-func (hc *Healthcheck) PerformCheck(url string, tlsConfig *tls.Config) (*HealthcheckResult, error) {
-	tr := &http.Transport{
-		TLSClientConfig: tlsConfig,
+// Performs health check, returns the result. Failed checks are retried up to RetryCount times, RetryInterval apart,
+// and only the result of the last attempt is returned. Once the context is done no further attempt is made
+func (hc *Healthcheck) PerformCheck(ctx context.Context, url string, tlsConfig *tls.Config) (*HealthcheckResult, error) {
+	var perform func() (*HealthcheckResult, error)
+	switch hc.Protocol {
+	case "ssh":
+		return nil, errors.New("SSH healthchecks run commands, see PerformCommandCheck")
+	case "tcp":
+		perform = func() (*HealthcheckResult, error) { return hc.performTcpAttempt(ctx, url) }
+	default:
+		presented := &presentedCertificates{}
+		tr := &http.Transport{
+			TLSClientConfig: hc.tlsClientConfig(tlsConfig, presented),
+		}
+		defer tr.CloseIdleConnections()
+		httpClient := &http.Client{
+			Timeout:   hc.timeout(),
+			Transport: tr,
+		}
+		perform = func() (*HealthcheckResult, error) { return hc.performAttempt(ctx, httpClient, url, presented) }
+	}
+	return hc.retry(ctx, perform)
+}
+
+// PerformCommandCheck runs the command of an SSH healthcheck (ReqBody) with the runner, and judges its health by the exit code
+// (ExpectedStatus) and its standard output, like PerformCheck does with responses
+func (hc *Healthcheck) PerformCommandCheck(ctx context.Context, run HealthcheckCommandRunner) (*HealthcheckResult, error) {
+	return hc.retry(ctx, func() (*HealthcheckResult, error) { return hc.performCommandAttempt(run) })
+}
+
+// Performs attempts of the check until one succeeds, or RetryCount retries failed as well. Stops waiting for the next attempt
+// once the context is done, returning the result of the last one with the context's error
+func (hc *Healthcheck) retry(ctx context.Context, perform func() (*HealthcheckResult, error)) (*HealthcheckResult, error) {
+	attempts := max(hc.RetryCount, 0) + 1
+	for attempt := 1; ; attempt++ {
+		result, err := perform()
+		if result.IsSuccessful || attempt == attempts {
+			if !result.IsSuccessful && attempts > 1 {
+				result.ErrorMessage = fmt.Sprintf("%s (after %d attempts)", result.ErrorMessage, attempts)
+			}
+			return result, err
+		}
+		wait := time.NewTimer(hc.RetryInterval)
+		select {
+		case <-ctx.Done():
+			wait.Stop()
+			return result, ctx.Err()
+		case <-wait.C:
+		}
+	}
+}
+
+// Returns how long an attempt of the check may take. Without a timeout a check would never finish, or fail right away
+func (hc *Healthcheck) timeout() time.Duration {
+	if hc.ReqTimeout <= 0 {
+		return defaultHealthcheckTimeout
 	}
-	httpClient := &http.Client{
-		Timeout:   hc.ReqTimeout,
-		Transport: tr,
+	return hc.ReqTimeout
+}
+
+// Holds the certificates the target presented in the last TLS handshake of a check
+type presentedCertificates struct {
+	lock         sync.Mutex
+	certificates []*x509.Certificate
+}
+
+func (p *presentedCertificates) set(certificates []*x509.Certificate) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.certificates = certificates
+}
+
+func (p *presentedCertificates) get() []*x509.Certificate {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.certificates
+}
+
+// Returns the TLS configuration of the check, which only verifies the certificate of the target if VerifySSL is set.
+// Every handshake records the certificates presented by the target, so they are kept even if they fail verification
+func (hc *Healthcheck) tlsClientConfig(tlsConfig *tls.Config, presented *presentedCertificates) *tls.Config {
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{}
+	} else {
+		tlsConfig = tlsConfig.Clone()
 	}
+	// The certificates are verified in VerifyConnection, as Go does not call it if its own verification fails
+	tlsConfig.InsecureSkipVerify = true
+	roots := tlsConfig.RootCAs // The system roots if nil
+	tlsConfig.VerifyConnection = func(state tls.ConnectionState) error {
+		presented.set(state.PeerCertificates)
+		if !hc.VerifySSL {
+			return nil
+		}
+		if len(state.PeerCertificates) == 0 {
+			return errors.New("tls: target presented no certificate")
+		}
+		options := x509.VerifyOptions{
+			DNSName:       state.ServerName,
+			Roots:         roots,
+			Intermediates: x509.NewCertPool(),
+		}
+		for _, certificate := range state.PeerCertificates[1:] {
+			options.Intermediates.AddCert(certificate)
+		}
+		if _, err := state.PeerCertificates[0].Verify(options); err != nil {
+			return fmt.Errorf("tls: failed to verify certificate: %w", err)
+		}
+		return nil
+	}
+	return tlsConfig
+}
+
+// Sends the request of the check once and validates the response. The certificates presented by the target are recorded
+// with the result, whether the request succeeded or not
+func (hc *Healthcheck) performAttempt(ctx context.Context, httpClient *http.Client, url string, presented *presentedCertificates) (*HealthcheckResult, error) {
 	result := &HealthcheckResult{
 		HealthcheckID: *hc.Id,
 		TimeStart:     time.Now(),
 		IsSuccessful:  false,
 	}
-	req, err := http.NewRequest(hc.ReqMethod, url, nil)
+	var body io.Reader
+	if hc.ReqBody != "" {
+		body = strings.NewReader(hc.ReqBody)
+	}
+	req, err := http.NewRequestWithContext(ctx, hc.ReqMethod, url, body)
 	if err != nil {
+		result.ErrorMessage = err.Error()
 		return result, err
 	}
-	req.Header = hc.ReqHttpHeader
+	// The headers are shared by all probes of the check, so they are copied before authentication is added
+	req.Header = hc.ReqHttpHeader.Clone()
+	if req.Header == nil {
+		req.Header = http.Header{}
+	}
+	if err := hc.authenticate(req); err != nil {
+		result.ErrorMessage = err.Error()
+		return result, err
+	}
+	presented.set(nil) // Certificates of earlier attempts are not recorded with this one
 	resp, err := httpClient.Do(req)
 	result.TimeEnd = time.Now()
 	result.ResTime = int(result.TimeEnd.Sub(result.TimeStart).Milliseconds())
 	if err != nil {
 		result.ErrorMessage = err.Error()
+		if chain := presented.get(); len(chain) > 0 {
+			hc.checkCertificates(result, chain)
+		}
 		return result, err
 	} else {
 		result.ResStatus = resp.StatusCode
@@ -228,51 +371,225 @@ func (hc *Healthcheck) PerformCheck(url string, tlsConfig *tls.Config) (*Healthc
 		// Close response body
 		defer resp.Body.Close()
 		// Check if the response status matches the expected status
-		switch expression := hc.ResponseValidation; expression {
-		case "none", "":
-			if resp.StatusCode == hc.ExpectedStatus {
-				result.IsSuccessful = true
-			} else {
-				result.ErrorMessage = "Unexpected status code: " + resp.Status
-			}
-		case "contains":
-			if resp.StatusCode == hc.ExpectedStatus && hc.ExpectedResponseBody != "" {
-				if !strings.Contains(result.ResBody, hc.ExpectedResponseBody) {
-					result.ErrorMessage = "Response body does not contain expected content"
-				} else {
-					result.IsSuccessful = true
-				}
-			} else {
-				result.ErrorMessage = "Unexpected status code: " + resp.Status
-			}
-		case "exact":
-			if resp.StatusCode == hc.ExpectedStatus && hc.ExpectedResponseBody != "" {
-				if result.ResBody != hc.ExpectedResponseBody {
-					result.ErrorMessage = "Response body does not match expected content"
-				} else {
-					result.IsSuccessful = true
-				}
-			} else {
-				result.ErrorMessage = "Unexpected status code: " + resp.Status
-			}
-		case "regex":
-			if resp.StatusCode == hc.ExpectedStatus && hc.ExpectedResponseBody != "" {
-				matched, err := regexp.MatchString(hc.ExpectedResponseBody, result.ResBody)
-				if err != nil {
-					result.ErrorMessage = "Error matching regex: " + err.Error()
-				} else if !matched {
-					result.ErrorMessage = "Response body does not match expected regex"
-				} else {
-					result.IsSuccessful = true
-				}
-			} else {
-				result.ErrorMessage = "Unexpected status code: " + resp.Status
-			}
-		default:
-			result.ErrorMessage = "Invalid response validation expression: " + expression
+		if resp.StatusCode != hc.ExpectedStatus {
+			result.ErrorMessage = "Unexpected status code: " + resp.Status
+		} else {
+			result.IsSuccessful, result.ErrorMessage = hc.validateResponse(result.ResBody)
+		}
+		// A reused connection had its handshake in an earlier attempt
+		if resp.TLS != nil && len(resp.TLS.PeerCertificates) > 0 {
+			hc.checkCertificates(result, resp.TLS.PeerCertificates)
 		}
 	}
 	return result, nil
 }
 
+// Records the certificate chain presented by the instance with the result. The check warns or fails if the first certificate
+// of the chain to expire does so within the thresholds of the check
+func (hc *Healthcheck) checkCertificates(result *HealthcheckResult, chain []*x509.Certificate) {
+	leaf := chain[0]
+	subject, issuer := leaf.Subject.String(), leaf.Issuer.String()
+	result.CertSubject, result.CertIssuer = &subject, &issuer
+	result.CertSans = append(result.CertSans, leaf.DNSNames...)
+	for _, ip := range leaf.IPAddresses {
+		result.CertSans = append(result.CertSans, ip.String())
+	}
+	expiring := leaf
+	for _, certificate := range chain {
+		result.CertChain = append(result.CertChain, HealthcheckCertificate{
+			Subject:  certificate.Subject.String(),
+			Issuer:   certificate.Issuer.String(),
+			NotAfter: certificate.NotAfter,
+		})
+		if certificate.NotAfter.Before(expiring.NotAfter) {
+			expiring = certificate
+		}
+	}
+	result.CertNotAfter = &expiring.NotAfter
+
+	left := time.Until(expiring.NotAfter)
+	var message string
+	if left <= 0 {
+		message = fmt.Sprintf("Certificate %s expired on %s", expiring.Subject, expiring.NotAfter.Format(time.DateOnly))
+	} else {
+		message = fmt.Sprintf("Certificate %s expires in %d days, on %s", expiring.Subject, int(left.Hours()/24), expiring.NotAfter.Format(time.DateOnly))
+	}
+	switch {
+	case hc.CertFailDays > 0 && left < time.Duration(hc.CertFailDays)*24*time.Hour:
+		if result.IsSuccessful {
+			result.IsSuccessful = false
+			result.ErrorMessage = message
+		}
+	case hc.CertWarnDays > 0 && left < time.Duration(hc.CertWarnDays)*24*time.Hour:
+		result.WarningMessage = &message
+	}
+}
+
+// Opens a connection to the address of the URL (tcp://host:port), sends the payload in ReqBody if there is one,
+// and reads the response until it is valid, the connection is closed or the timeout is reached
+func (hc *Healthcheck) performTcpAttempt(ctx context.Context, url string) (*HealthcheckResult, error) {
+	result := &HealthcheckResult{
+		HealthcheckID: *hc.Id,
+		TimeStart:     time.Now(),
+		IsSuccessful:  false,
+	}
+	address := strings.TrimPrefix(url, "tcp://")
+	timeout := hc.timeout()
+	dialer := &net.Dialer{Timeout: timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		result.TimeEnd = time.Now()
+		result.ResTime = int(result.TimeEnd.Sub(result.TimeStart).Milliseconds())
+		result.ErrorMessage = err.Error()
+		return result, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(result.TimeStart.Add(timeout))
+
+	if hc.ReqBody != "" {
+		if _, err := io.WriteString(conn, hc.ReqBody); err != nil {
+			result.TimeEnd = time.Now()
+			result.ResTime = int(result.TimeEnd.Sub(result.TimeStart).Milliseconds())
+			result.ErrorMessage = "Unable to send payload: " + err.Error()
+			return result, err
+		}
+	}
+	if hc.ResponseValidation == "none" || hc.ResponseValidation == "" {
+		// Accepting the connection (and the payload) is all that is checked
+		result.TimeEnd = time.Now()
+		result.ResTime = int(result.TimeEnd.Sub(result.TimeStart).Milliseconds())
+		result.IsSuccessful = true
+		return result, nil
+	}
+
+	// Banners and responses don't announce their length, so they are read until they match
+	var response []byte
+	buffer := make([]byte, 4096)
+	for len(response) < maxTcpResponseSize {
+		n, err := conn.Read(buffer)
+		response = append(response, buffer[:n]...)
+		if err != nil {
+			break
+		}
+		if valid, _ := hc.validateResponse(string(response)); valid {
+			break
+		}
+	}
+	result.TimeEnd = time.Now()
+	result.ResTime = int(result.TimeEnd.Sub(result.TimeStart).Milliseconds())
+	result.ResBody = string(response)
+	result.IsSuccessful, result.ErrorMessage = hc.validateResponse(result.ResBody)
+	return result, nil
+}
+
+// HealthcheckCommandRunner runs the command of an SSH healthcheck on its target within the timeout.
+// Returns its exit code and the start of its output, or an error if it could not be run or did not finish in time
+type HealthcheckCommandRunner func(command string, timeout time.Duration) (exitCode int, stdout string, stderr string, err error)
+
+// Runs the command once and validates its exit code and output
+func (hc *Healthcheck) performCommandAttempt(run HealthcheckCommandRunner) (*HealthcheckResult, error) {
+	result := &HealthcheckResult{
+		HealthcheckID: *hc.Id,
+		TimeStart:     time.Now(),
+		IsSuccessful:  false,
+	}
+	exitCode, stdout, stderr, err := run(hc.ReqBody, hc.timeout())
+	result.TimeEnd = time.Now()
+	result.ResTime = int(result.TimeEnd.Sub(result.TimeStart).Milliseconds())
+	if err != nil {
+		result.ErrorMessage = err.Error()
+		return result, err
+	}
+	result.ResStatus = exitCode
+	result.ResBody = stdout
+	if exitCode != hc.ExpectedStatus {
+		result.ErrorMessage = fmt.Sprintf("Unexpected exit code: %d", exitCode)
+	} else {
+		result.IsSuccessful, result.ErrorMessage = hc.validateResponse(stdout)
+	}
+	// Commands tell why they failed on stderr
+	if stderr = strings.TrimSpace(stderr); !result.IsSuccessful && stderr != "" {
+		result.ErrorMessage += ": " + stderr
+	}
+	return result, nil
+}
+
+// Validates the response body, the response read from a TCP connection or the output of a command against the expected content of the check.
+// Returns the error message if it is not valid
+func (hc *Healthcheck) validateResponse(body string) (bool, string) {
+	switch expression := hc.ResponseValidation; expression {
+	case "none", "":
+		return true, ""
+	case "contains", "exact", "regex", "jsonpath":
+		if hc.ExpectedResponseBody == "" {
+			return false, "No expected response content configured for validation " + expression
+		}
+	default:
+		return false, "Invalid response validation expression: " + expression
+	}
+	switch hc.ResponseValidation {
+	case "contains":
+		if !strings.Contains(body, hc.ExpectedResponseBody) {
+			return false, "Response body does not contain expected content"
+		}
+	case "exact":
+		if body != hc.ExpectedResponseBody {
+			return false, "Response body does not match expected content"
+		}
+	case "regex":
+		matched, err := regexp.MatchString(hc.ExpectedResponseBody, body)
+		if err != nil {
+			return false, "Error matching regex: " + err.Error()
+		} else if !matched {
+			return false, "Response body does not match expected regex"
+		}
+	case "jsonpath":
+		return hc.validateJsonPath(body)
+	}
+	return true, ""
+}
+
+// Adds the credentials of the check to the request
+func (hc *Healthcheck) authenticate(req *http.Request) error {
+	if err := ValidateHealthcheckAuth(hc.AuthType, hc.AuthCredentials); err != nil {
+		return err
+	}
+	switch hc.AuthType {
+	case "basic":
+		username, password, _ := strings.Cut(hc.AuthCredentials, ":")
+		req.SetBasicAuth(username, password)
+	case "bearer":
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(hc.AuthCredentials))
+	case "custom":
+		name, value, _ := strings.Cut(hc.AuthCredentials, ":")
+		req.Header.Set(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+	return nil
+}
+
+// ValidateHealthcheckAuth checks the credentials against the authentication type: "username:password" for basic,
+// the token for bearer and "Header-Name: value" for custom, which sends the credentials in a header of its own
+func ValidateHealthcheckAuth(authType string, credentials string) error {
+	switch authType {
+	case "", "none":
+		return nil
+	case "basic":
+		if !strings.Contains(credentials, ":") {
+			return errors.New("basic authentication credentials must be username:password")
+		}
+	case "bearer":
+		if strings.TrimSpace(credentials) == "" {
+			return errors.New("bearer authentication requires a token")
+		}
+	case "custom":
+		name, _, found := strings.Cut(credentials, ":")
+		if !found || strings.TrimSpace(name) == "" || strings.ContainsAny(strings.TrimSpace(name), " \t") {
+			return errors.New("custom authentication credentials must be a header, e.g. X-Api-Key: secret")
+		}
+	default:
+		return fmt.Errorf("invalid authentication type %q, must be none, basic, bearer or custom", authType)
+	}
+	return nil
+}
+
 // TODO: Func to clean up old healthcheck records, e.g., older than 30 days or with non existent healthchecks id
@@ -0,0 +1,152 @@
+package data
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// The certificates presented by the target are recorded with every result, also when they fail verification
+func TestPerformCheckRecordsCertificates(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	trusted := x509.NewCertPool()
+	trusted.AddCert(server.Certificate())
+
+	tests := []struct {
+		name       string
+		verifySSL  bool
+		roots      *x509.CertPool
+		successful bool
+	}{
+		{"not verified", false, nil, true},
+		{"verified", true, trusted, true},
+		{"untrusted", true, x509.NewCertPool(), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id := uint(1)
+			hc := &Healthcheck{Id: &id, ReqMethod: "GET", ReqTimeout: 5 * time.Second, ExpectedStatus: 200, Protocol: "https", VerifySSL: tt.verifySSL}
+			result, _ := hc.PerformCheck(context.Background(), server.URL, &tls.Config{RootCAs: tt.roots})
+			if result.IsSuccessful != tt.successful {
+				t.Fatalf("successful = %v, want %v: %s", result.IsSuccessful, tt.successful, result.ErrorMessage)
+			}
+			if len(result.CertChain) != 1 || result.CertSubject == nil || result.CertNotAfter == nil {
+				t.Fatalf("certificates were not recorded: %+v", result)
+			}
+			if !result.CertNotAfter.Equal(server.Certificate().NotAfter) {
+				t.Errorf("not after = %s, want %s", result.CertNotAfter, server.Certificate().NotAfter)
+			}
+		})
+	}
+}
+
+func TestPerformCheckWithoutHandshake(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	url := server.URL
+	server.Close()
+
+	id := uint(1)
+	hc := &Healthcheck{Id: &id, ReqMethod: "GET", ReqTimeout: 5 * time.Second, ExpectedStatus: 200, Protocol: "https"}
+	result, err := hc.PerformCheck(context.Background(), url, nil)
+	if err == nil || result.IsSuccessful {
+		t.Fatal("expected the check to fail")
+	}
+	if len(result.CertChain) != 0 {
+		t.Errorf("recorded certificates without a handshake: %+v", result.CertChain)
+	}
+}
+
+func TestPerformCheckRetries(t *testing.T) {
+	id := uint(1)
+	failing := func(command string, timeout time.Duration) (int, string, string, error) { return 1, "", "", nil }
+
+	t.Run("until the attempts are used up", func(t *testing.T) {
+		attempts := 0
+		hc := &Healthcheck{Id: &id, RetryCount: 2, RetryInterval: time.Millisecond}
+		result, err := hc.PerformCommandCheck(context.Background(), func(command string, timeout time.Duration) (int, string, string, error) {
+			attempts++
+			return 1, "", "", nil
+		})
+		if err != nil || result.IsSuccessful || attempts != 3 {
+			t.Fatalf("attempts = %d, successful = %v, err = %v", attempts, result.IsSuccessful, err)
+		}
+	})
+
+	t.Run("stops waiting once the context is done", func(t *testing.T) {
+		hc := &Healthcheck{Id: &id, RetryCount: 1, RetryInterval: time.Hour}
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		result, err := hc.PerformCommandCheck(ctx, failing)
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("err = %v, want the context's error", err)
+		}
+		if result == nil || result.IsSuccessful {
+			t.Fatalf("expected the failed result of the first attempt, got %+v", result)
+		}
+		if waited := time.Since(start); waited > 5*time.Second {
+			t.Fatalf("waited %s for the retry", waited)
+		}
+	})
+}
+
+// Checks without a timeout of their own get the default one, rather than one which expires right away
+func TestPerformTcpCheckWithoutTimeout(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			time.Sleep(20 * time.Millisecond)
+			conn.Write([]byte("SSH-2.0-OpenSSH_9.6\r\n"))
+			conn.Close()
+		}
+	}()
+
+	id := uint(1)
+	hc := &Healthcheck{Id: &id, Protocol: "tcp", ResponseValidation: "contains", ExpectedResponseBody: "SSH-2.0"}
+	result, err := hc.PerformCheck(context.Background(), "tcp://"+listener.Addr().String(), nil)
+	if err != nil || !result.IsSuccessful {
+		t.Fatalf("successful = %v, err = %v: %s", result.IsSuccessful, err, result.ErrorMessage)
+	}
+}
+
+func TestHealthcheckDTORequiresMethod(t *testing.T) {
+	none := "none"
+	tests := []struct {
+		name     string
+		protocol string
+		method   string
+		wantErr  bool
+	}{
+		{"http with method", "http", "GET", false},
+		{"http without method", "http", "", true},
+		{"https without method", "https", "", true},
+		{"default protocol without method", "", "", true},
+		{"tcp without method", "tcp", "", false},
+		{"ssh without method", "ssh", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dto := HealthcheckDTO{Name: "check", ReqUrl: "/health", ReqMethod: tt.method, ReqBody: "true", ReqTimeout: 5, CheckInterval: 30,
+				ExpectedStatus: 200, Protocol: tt.protocol, ResponseValidation: &none}
+			_, err := dto.ToHealthcheck()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ToHealthcheck() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,10 @@
+-- Remove execution strategy fields from actions
+ALTER TABLE action
+DROP COLUMN IF EXISTS execution_strategy,
+DROP COLUMN IF EXISTS batch_size,
+DROP COLUMN IF EXISTS batch_size_percent,
+DROP COLUMN IF EXISTS batch_pause_seconds,
+DROP COLUMN IF EXISTS stop_on_failure;
+
+ALTER TABLE action_execution
+DROP COLUMN IF EXISTS position;
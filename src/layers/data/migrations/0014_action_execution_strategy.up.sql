@@ -0,0 +1,19 @@
+-- Add execution strategy fields to actions
+ALTER TABLE action
+ADD COLUMN execution_strategy VARCHAR(20) NOT NULL DEFAULT 'parallel', -- 'parallel', 'sequential' or 'batch'
+ADD COLUMN batch_size INTEGER NOT NULL DEFAULT 1,
+ADD COLUMN batch_size_percent BOOLEAN NOT NULL DEFAULT FALSE,
+ADD COLUMN batch_pause_seconds INTEGER NOT NULL DEFAULT 0,
+ADD COLUMN stop_on_failure BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Order in which the executions of an action are run
+ALTER TABLE action_execution
+ADD COLUMN position INTEGER NOT NULL DEFAULT 0;
+
+-- Add comments for documentation
+COMMENT ON COLUMN action.execution_strategy IS 'How the executions are run: parallel (all at once), sequential (one by one) or batch (batch_size at a time)';
+COMMENT ON COLUMN action.batch_size IS 'Number of executions per batch, or percentage of all executions if batch_size_percent is set';
+COMMENT ON COLUMN action.batch_size_percent IS 'Whether batch_size is a percentage of all executions';
+COMMENT ON COLUMN action.batch_pause_seconds IS 'Pause between batches (or between executions when sequential) in seconds';
+COMMENT ON COLUMN action.stop_on_failure IS 'Do not start further batches once an execution failed';
+COMMENT ON COLUMN action_execution.position IS 'Position of the execution within the action, executions are run in this order';
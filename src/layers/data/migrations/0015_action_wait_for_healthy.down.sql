@@ -0,0 +1,4 @@
+-- Remove healthcheck gating from actions
+ALTER TABLE action
+DROP COLUMN IF EXISTS wait_for_healthy,
+DROP COLUMN IF EXISTS healthy_timeout_seconds;
@@ -0,0 +1,8 @@
+-- Add healthcheck gating to actions
+ALTER TABLE action
+ADD COLUMN wait_for_healthy BOOLEAN NOT NULL DEFAULT FALSE,
+ADD COLUMN healthy_timeout_seconds INTEGER NOT NULL DEFAULT 300;
+
+-- Add comments for documentation
+COMMENT ON COLUMN action.wait_for_healthy IS 'Start the next batch only after the instances of the previous one report healthy again';
+COMMENT ON COLUMN action.healthy_timeout_seconds IS 'How long to wait for an instance to report healthy before the action fails';
@@ -0,0 +1,6 @@
+-- Remove automatic maintenance mode from action templates and actions
+ALTER TABLE action_template
+DROP COLUMN IF EXISTS maintenance_mode;
+
+ALTER TABLE action
+DROP COLUMN IF EXISTS maintenance_mode;
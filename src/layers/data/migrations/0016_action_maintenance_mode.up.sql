@@ -0,0 +1,10 @@
+-- Add automatic maintenance mode to action templates and actions
+ALTER TABLE action_template
+ADD COLUMN maintenance_mode BOOLEAN NOT NULL DEFAULT FALSE;
+
+ALTER TABLE action
+ADD COLUMN maintenance_mode BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Add comments for documentation
+COMMENT ON COLUMN action_template.maintenance_mode IS 'Default for actions created from this template';
+COMMENT ON COLUMN action.maintenance_mode IS 'Put each target instance into maintenance mode while its script runs';
@@ -0,0 +1,6 @@
+-- Remove typed parameters from action templates and actions
+ALTER TABLE action_template
+DROP COLUMN IF EXISTS parameters;
+
+ALTER TABLE action
+DROP COLUMN IF EXISTS parameters;
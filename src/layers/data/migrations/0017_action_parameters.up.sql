@@ -0,0 +1,10 @@
+-- Add typed parameters to action templates, and their values to actions
+ALTER TABLE action_template
+ADD COLUMN parameters JSONB NOT NULL DEFAULT '[]';
+
+ALTER TABLE action
+ADD COLUMN parameters JSONB NOT NULL DEFAULT '{}';
+
+-- Add comments for documentation
+COMMENT ON COLUMN action_template.parameters IS 'Declarations of the parameters prompted for when an action is created: name, type, default, description, regex and options';
+COMMENT ON COLUMN action.parameters IS 'Values of the template parameters, keyed by parameter name';
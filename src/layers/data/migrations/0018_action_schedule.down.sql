@@ -0,0 +1,5 @@
+-- Remove action schedules and the link of actions to them
+ALTER TABLE action
+DROP COLUMN IF EXISTS action_schedule_id;
+
+DROP TABLE IF EXISTS action_schedule;
@@ -0,0 +1,34 @@
+-- Schedules launch actions from a template once at a given time, or recurring on a cron expression
+CREATE TABLE action_schedule (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    action_template_id INTEGER NOT NULL REFERENCES action_template(id) ON DELETE CASCADE,
+    instance_ids INTEGER[] NOT NULL, -- In the order in which they are executed
+    cron_expression VARCHAR(255) NOT NULL DEFAULT '', -- Empty for a one-off schedule
+    run_at TIMESTAMPTZ, -- Time of a one-off schedule
+    next_run_at TIMESTAMPTZ,
+    last_run_at TIMESTAMPTZ,
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    -- Settings of the launched actions
+    execution_strategy VARCHAR(20) NOT NULL DEFAULT 'parallel',
+    batch_size INTEGER NOT NULL DEFAULT 1,
+    batch_size_percent BOOLEAN NOT NULL DEFAULT FALSE,
+    batch_pause_seconds INTEGER NOT NULL DEFAULT 0,
+    stop_on_failure BOOLEAN NOT NULL DEFAULT FALSE,
+    wait_for_healthy BOOLEAN NOT NULL DEFAULT FALSE,
+    healthy_timeout_seconds INTEGER NOT NULL DEFAULT 300,
+    maintenance_mode BOOLEAN NOT NULL DEFAULT FALSE,
+    parameters JSONB NOT NULL DEFAULT '{}',
+    created_by_user_id INTEGER NOT NULL, -- References user table
+    created_at TIMESTAMP DEFAULT NOW(),
+    updated_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_action_schedule_next_run_at ON action_schedule(next_run_at) WHERE enabled;
+
+ALTER TABLE action
+ADD COLUMN action_schedule_id INTEGER REFERENCES action_schedule(id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS idx_action_action_schedule_id ON action(action_schedule_id);
+
+COMMENT ON COLUMN action.action_schedule_id IS 'Schedule which launched the action, NULL if it was created manually';
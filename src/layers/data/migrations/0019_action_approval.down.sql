@@ -0,0 +1,9 @@
+-- Remove approval of actions
+ALTER TABLE action_template
+DROP COLUMN IF EXISTS requires_approval;
+
+ALTER TABLE action
+DROP COLUMN IF EXISTS requires_approval,
+DROP COLUMN IF EXISTS reviewed_by_user_id,
+DROP COLUMN IF EXISTS reviewed_at,
+DROP COLUMN IF EXISTS review_comment;
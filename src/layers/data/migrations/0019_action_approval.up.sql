@@ -0,0 +1,16 @@
+-- Add approval of actions by a second user (four-eyes principle)
+ALTER TABLE action_template
+ADD COLUMN requires_approval BOOLEAN NOT NULL DEFAULT FALSE;
+
+ALTER TABLE action
+ADD COLUMN requires_approval BOOLEAN NOT NULL DEFAULT FALSE,
+ADD COLUMN reviewed_by_user_id INTEGER, -- References user table
+ADD COLUMN reviewed_at TIMESTAMP,
+ADD COLUMN review_comment TEXT;
+
+-- Add comments for documentation
+COMMENT ON COLUMN action_template.requires_approval IS 'Actions created from the template stay in pending_approval until another user approves them';
+COMMENT ON COLUMN action.requires_approval IS 'The action was created in pending_approval and has to be approved by another user than its creator';
+COMMENT ON COLUMN action.reviewed_by_user_id IS 'User who approved or rejected the action';
+COMMENT ON COLUMN action.reviewed_at IS 'Time the action was approved or rejected';
+COMMENT ON COLUMN action.review_comment IS 'Comment of the user who approved or rejected the action';
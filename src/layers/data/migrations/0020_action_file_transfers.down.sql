@@ -0,0 +1,6 @@
+-- Remove file transfers of actions
+ALTER TABLE action_template
+DROP COLUMN IF EXISTS file_transfers;
+
+DROP TABLE IF EXISTS action_execution_artifact;
+DROP TABLE IF EXISTS action_file;
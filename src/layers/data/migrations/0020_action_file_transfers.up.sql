@@ -0,0 +1,30 @@
+-- Files stored in NAM, which action templates upload to the target servers
+CREATE TABLE action_file (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL UNIQUE,
+    description TEXT,
+    content BYTEA NOT NULL,
+    size BIGINT NOT NULL,
+    sha256 CHAR(64) NOT NULL,
+    created_by_user_id INTEGER NOT NULL, -- References user table
+    created_at TIMESTAMP DEFAULT NOW(),
+    updated_at TIMESTAMP DEFAULT NOW()
+);
+
+-- Files downloaded from the target servers by an execution, like heap dumps or logs
+CREATE TABLE action_execution_artifact (
+    id SERIAL PRIMARY KEY,
+    action_execution_id INTEGER NOT NULL REFERENCES action_execution(id) ON DELETE CASCADE,
+    name VARCHAR(255) NOT NULL,
+    remote_path TEXT NOT NULL,
+    content BYTEA NOT NULL,
+    size BIGINT NOT NULL,
+    created_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_action_execution_artifact_execution_id ON action_execution_artifact(action_execution_id);
+
+ALTER TABLE action_template
+ADD COLUMN file_transfers JSONB NOT NULL DEFAULT '[]';
+
+COMMENT ON COLUMN action_template.file_transfers IS 'Files uploaded to the server before the script runs and downloaded from it afterwards: direction, file_id, remote_path and mode';
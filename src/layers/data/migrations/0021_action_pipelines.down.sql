@@ -0,0 +1,5 @@
+-- Remove pipelines of actions
+DROP TABLE IF EXISTS action_execution_step;
+
+ALTER TABLE action_template
+DROP COLUMN IF EXISTS steps;
@@ -0,0 +1,23 @@
+-- Ordered steps of an action template. Templates without steps run their bash script as a single step
+ALTER TABLE action_template
+ADD COLUMN steps JSONB NOT NULL DEFAULT '[]';
+
+COMMENT ON COLUMN action_template.steps IS 'Pipeline of the template: name, type (script, file_copy, wait_for_healthy, http, maintenance, confirmation), timeout, retries, on_failure policy and the settings of the type';
+
+-- Status and output of every step of an execution
+CREATE TABLE action_execution_step (
+    id SERIAL PRIMARY KEY,
+    action_execution_id INTEGER NOT NULL REFERENCES action_execution(id) ON DELETE CASCADE,
+    position INTEGER NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    type VARCHAR(50) NOT NULL,
+    status VARCHAR(50) NOT NULL DEFAULT 'pending', -- pending, running, waiting, completed, failed, skipped, cancelled
+    attempts INTEGER NOT NULL DEFAULT 0,
+    output TEXT NOT NULL DEFAULT '',
+    error_output TEXT NOT NULL DEFAULT '',
+    exit_code INTEGER,
+    started_at TIMESTAMP,
+    completed_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_action_execution_step_execution_id ON action_execution_step(action_execution_id);
@@ -0,0 +1,4 @@
+-- Remove the executor and webhook of action templates
+ALTER TABLE action_template
+DROP COLUMN IF EXISTS webhook,
+DROP COLUMN IF EXISTS executor;
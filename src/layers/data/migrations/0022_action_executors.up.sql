@@ -0,0 +1,7 @@
+-- Backend which runs the scripts of an action template, and the webhook receiving them
+ALTER TABLE action_template
+ADD COLUMN executor VARCHAR(50) NOT NULL DEFAULT 'ssh',
+ADD COLUMN webhook JSONB NOT NULL DEFAULT '{}';
+
+COMMENT ON COLUMN action_template.executor IS 'Runs the scripts of the template: ssh on the server of the instance, local in a shell on the NAM host, webhook by posting them to the webhook';
+COMMENT ON COLUMN action_template.webhook IS 'URL and headers of the webhook, used by the webhook executor';
@@ -0,0 +1,6 @@
+-- Remove the pinned host keys of servers
+ALTER TABLE server
+DROP COLUMN IF EXISTS ssh_host_key_pinned_at,
+DROP COLUMN IF EXISTS ssh_host_key_source,
+DROP COLUMN IF EXISTS ssh_host_key_fingerprint,
+DROP COLUMN IF EXISTS ssh_host_key;
@@ -0,0 +1,10 @@
+-- Host key pinned for the SSH daemon of each server, connections presenting another key are refused
+ALTER TABLE server
+ADD COLUMN ssh_host_key TEXT,
+ADD COLUMN ssh_host_key_fingerprint VARCHAR(255),
+ADD COLUMN ssh_host_key_source VARCHAR(50),
+ADD COLUMN ssh_host_key_pinned_at TIMESTAMP;
+
+COMMENT ON COLUMN server.ssh_host_key IS 'Host key in authorized_keys format, recorded on first contact. NULL if only a fingerprint was pinned and the server was not contacted since';
+COMMENT ON COLUMN server.ssh_host_key_fingerprint IS 'SHA256 fingerprint of the host key, which connections are verified against. NULL until the first contact, which pins it (trust on first use)';
+COMMENT ON COLUMN server.ssh_host_key_source IS 'How the key was pinned: tofu on first contact, admin by an Admin';
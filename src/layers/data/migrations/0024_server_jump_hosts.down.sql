@@ -0,0 +1,3 @@
+-- Remove the jump hosts of servers
+ALTER TABLE server
+DROP COLUMN IF EXISTS ssh_jump_server_id;
@@ -0,0 +1,5 @@
+-- Jump host (bastion) which SSH connections to a server are tunnelled through, itself a server
+ALTER TABLE server
+ADD COLUMN ssh_jump_server_id INTEGER REFERENCES server(id) ON DELETE SET NULL;
+
+COMMENT ON COLUMN server.ssh_jump_server_id IS 'Server which SSH connections to this server are tunnelled through, with its own credentials. NULL to connect directly';
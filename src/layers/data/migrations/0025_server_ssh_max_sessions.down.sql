@@ -0,0 +1,3 @@
+-- Remove the SSH session limits of servers
+ALTER TABLE server
+DROP COLUMN IF EXISTS ssh_max_sessions;
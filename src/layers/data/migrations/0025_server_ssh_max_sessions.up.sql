@@ -0,0 +1,5 @@
+-- Limit of executions sharing the SSH connection to a server at once, overriding the configured default
+ALTER TABLE server
+ADD COLUMN ssh_max_sessions INTEGER;
+
+COMMENT ON COLUMN server.ssh_max_sessions IS 'Executions sharing the SSH connection to the server at once, further ones wait in line. NULL for the default of the configuration (ssh.maxsessionsperserver)';
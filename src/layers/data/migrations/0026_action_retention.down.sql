@@ -0,0 +1,4 @@
+-- Remove the indexes for the retention of the history of actions
+DROP INDEX IF EXISTS idx_action_execution_completed_at;
+DROP INDEX IF EXISTS idx_action_execution_action_id;
+DROP INDEX IF EXISTS idx_action_completed_at;
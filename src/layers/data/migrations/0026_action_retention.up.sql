@@ -0,0 +1,4 @@
+-- Indexes for the retention of the history of actions, which deletes old actions along with their executions
+CREATE INDEX IF NOT EXISTS idx_action_completed_at ON action(completed_at);
+CREATE INDEX IF NOT EXISTS idx_action_execution_action_id ON action_execution(action_id);
+CREATE INDEX IF NOT EXISTS idx_action_execution_completed_at ON action_execution(completed_at);
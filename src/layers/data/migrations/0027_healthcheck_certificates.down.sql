@@ -0,0 +1,14 @@
+-- Remove certificates of healthcheck results and their expiry thresholds
+DROP INDEX IF EXISTS idx_healthcheck_results_certificates;
+
+ALTER TABLE healthcheck_results
+DROP COLUMN IF EXISTS warning_message,
+DROP COLUMN IF EXISTS cert_subject,
+DROP COLUMN IF EXISTS cert_issuer,
+DROP COLUMN IF EXISTS cert_sans,
+DROP COLUMN IF EXISTS cert_not_after,
+DROP COLUMN IF EXISTS cert_chain;
+
+ALTER TABLE healthcheck
+DROP COLUMN IF EXISTS cert_warn_days,
+DROP COLUMN IF EXISTS cert_fail_days;
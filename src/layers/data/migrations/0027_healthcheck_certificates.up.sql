@@ -0,0 +1,23 @@
+-- Certificates presented to https healthchecks, and the thresholds for their expiry
+ALTER TABLE healthcheck
+ADD COLUMN cert_warn_days INTEGER NOT NULL DEFAULT 30,
+ADD COLUMN cert_fail_days INTEGER NOT NULL DEFAULT 0;
+
+COMMENT ON COLUMN healthcheck.cert_warn_days IS 'Results of https checks carry a warning once the certificate chain expires within this many days, 0 disables the warning';
+COMMENT ON COLUMN healthcheck.cert_fail_days IS 'Https checks fail once the certificate chain expires within this many days, 0 disables the failure';
+
+ALTER TABLE healthcheck_results
+ADD COLUMN warning_message TEXT,
+ADD COLUMN cert_subject TEXT,
+ADD COLUMN cert_issuer TEXT,
+ADD COLUMN cert_sans TEXT[],
+ADD COLUMN cert_not_after TIMESTAMPTZ,
+ADD COLUMN cert_chain JSONB;
+
+COMMENT ON COLUMN healthcheck_results.warning_message IS 'Why a successful result needs attention, e.g. a certificate about to expire';
+COMMENT ON COLUMN healthcheck_results.cert_subject IS 'Subject of the certificate presented by the instance, NULL if it did not present one';
+COMMENT ON COLUMN healthcheck_results.cert_not_after IS 'Expiry of the first certificate of the presented chain to expire';
+COMMENT ON COLUMN healthcheck_results.cert_chain IS 'Subject, issuer and expiry of every certificate of the presented chain, starting with the one of the instance';
+
+-- The certificate overview looks up the latest result with a certificate of every instance
+CREATE INDEX IF NOT EXISTS idx_healthcheck_results_certificates ON healthcheck_results (application_instance_id, time_start DESC) WHERE cert_not_after IS NOT NULL;
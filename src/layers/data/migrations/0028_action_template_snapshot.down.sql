@@ -0,0 +1,3 @@
+-- Remove the template copies of actions
+ALTER TABLE action
+DROP COLUMN IF EXISTS template_snapshot;
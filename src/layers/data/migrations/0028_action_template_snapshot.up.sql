@@ -0,0 +1,12 @@
+-- Keep a copy of the template with every action, so changes to the template don't alter approved or scheduled actions
+ALTER TABLE action
+ADD COLUMN template_snapshot JSONB;
+
+-- Actions which have not run yet get the current template, finished ones keep using it as before
+UPDATE action a
+SET template_snapshot = to_jsonb(at) || jsonb_build_object('created_at', at.created_at AT TIME ZONE 'UTC', 'updated_at', at.updated_at AT TIME ZONE 'UTC')
+FROM action_template at
+WHERE at.id = a.action_template_id AND a.status IN ('pending', 'pending_approval');
+
+-- Add comments for documentation
+COMMENT ON COLUMN action.template_snapshot IS 'The action template as it was when the action was created, this is what runs. NULL for actions created before copies were kept';
@@ -1,6 +1,7 @@
 package data
 
 import (
+	"encoding/json"
 	"errors"
 	"net/http"
 	"strconv"
@@ -16,6 +17,13 @@ type Server struct {
 	SshAuthType     *string `json:"ssh_auth_type" db:"ssh_auth_type"`
 	SshAuthSecretId *uint64 `json:"ssh_auth_secret_id" db:"ssh_auth_secret_id"`
 	SshUser         *string `json:"ssh_user" db:"ssh_user"`
+	SshJumpServerId *uint   `json:"ssh_jump_server_id" db:"ssh_jump_server_id"` // Server which SSH connections are tunnelled through, nil to connect directly
+	SshMaxSessions  *int    `json:"ssh_max_sessions" db:"ssh_max_sessions"`     // Executions sharing the SSH connection at once, nil for the configured default
+	// Pinned host key, connections presenting another key are refused. Only changed through the host key endpoints
+	SshHostKey            *string    `json:"ssh_host_key" db:"ssh_host_key"`                         // authorized_keys format, recorded on first contact
+	SshHostKeyFingerprint *string    `json:"ssh_host_key_fingerprint" db:"ssh_host_key_fingerprint"` // SHA256 fingerprint, nil until the first contact pins it
+	SshHostKeySource      *string    `json:"ssh_host_key_source" db:"ssh_host_key_source"`           // tofu, admin
+	SshHostKeyPinnedAt    *time.Time `json:"ssh_host_key_pinned_at" db:"ssh_host_key_pinned_at"`
 }
 
 type Healthcheck struct {
@@ -31,28 +39,32 @@ type Healthcheck struct {
 	RetryCount     int           `json:"retry_count" db:"retry_count"`       // Number of retries before marking as unhealthy
 	RetryInterval  time.Duration `json:"retry_interval" db:"retry_interval"` // Time between retries
 	ExpectedStatus int           `json:"expected_status" db:"expected_status"`
-	Protocol       string        `json:"protocol" db:"protocol"` // http, https
+	Protocol       string        `json:"protocol" db:"protocol"` // http, https, tcp, ssh (runs the command in ReqBody, ExpectedStatus is its exit code)
 
 	// Response validation
 	ExpectedResponseBody string `json:"expected_response_body" db:"expected_response_body"` // Expected response content
-	ResponseValidation   string `json:"response_validation" db:"response_validation"`       // none, contains, exact, regex
+	ResponseValidation   string `json:"response_validation" db:"response_validation"`       // none, contains, exact, regex, jsonpath
 
 	// SSL/TLS
-	VerifySSL bool `json:"verify_ssl" db:"verify_ssl"`
+	VerifySSL    bool `json:"verify_ssl" db:"verify_ssl"`
+	CertWarnDays int  `json:"cert_warn_days" db:"cert_warn_days"` // Warn once the certificate chain expires within this many days, 0 disables it
+	CertFailDays int  `json:"cert_fail_days" db:"cert_fail_days"` // Fail once the certificate chain expires within this many days, 0 disables it
 
 	// Authentication
 	AuthType        string `json:"auth_type" db:"auth_type"`               // none, basic, bearer, custom
 	AuthCredentials string `json:"auth_credentials" db:"auth_credentials"` // stored securely
+
+	jsonPathAssertions []jsonPathAssertion // Parsed from ExpectedResponseBody when the check is saved or loaded
 }
 
 type HealthcheckDTO struct {
 	Id            *uint  `json:"id"`
 	Name          string `json:"name" binding:"required"`
 	Description   string `json:"description"`
-	ReqUrl        string `json:"url" binding:"required"`
-	ReqMethod     string `json:"method" binding:"required"` // GET, POST, etc.
-	ReqHeader     string `json:"headers"`                   // Custom headers
-	ReqBody       string `json:"body"`                      // Request body for POST/PUT
+	ReqUrl        string `json:"url"`     // Required unless the protocol is tcp or ssh
+	ReqMethod     string `json:"method"`  // GET, POST, etc. Required unless the protocol is tcp or ssh
+	ReqHeader     string `json:"headers"` // Custom headers
+	ReqBody       string `json:"body"`    // Request body for POST/PUT
 	ReqTimeout    int    `json:"timeout" binding:"required"`
 	CheckInterval int    `json:"check_interval" binding:"required"`
 	RetryCount    int    `json:"retry_count"`    // Number of retries before marking as unhealthy
@@ -60,12 +72,14 @@ type HealthcheckDTO struct {
 	Protocol      string `json:"protocol"`
 
 	// Response validation
-	ExpectedStatus       int     `json:"expected_status" binding:"required"`
+	ExpectedStatus       int     `json:"expected_status"`        // Exit code of the command for SSH healthchecks
 	ExpectedResponseBody string  `json:"expected_response_body"` // Expected response content
-	ResponseValidation   *string `json:"response_validation"`    // contains, exact, regex
+	ResponseValidation   *string `json:"response_validation"`    // contains, exact, regex, jsonpath
 
 	// SSL/TLS
-	VerifySSL string `json:"verify_ssl"`
+	VerifySSL    string `json:"verify_ssl"`
+	CertWarnDays *int   `json:"cert_warn_days"` // Defaults to 30 days
+	CertFailDays *int   `json:"cert_fail_days"` // Defaults to 0, never failing
 
 	// Authentication
 	AuthType        string `json:"auth_type"`        // none, basic, bearer, custom
@@ -82,6 +96,29 @@ func (dto HealthcheckDTO) ToHealthcheck() (*Healthcheck, error) {
 	}
 	reqTimeout, _ := time.ParseDuration(strconv.Itoa(dto.ReqTimeout) + "s")
 	reqInterval, _ := time.ParseDuration(strconv.Itoa(dto.CheckInterval) + "s")
+	retryInterval, _ := time.ParseDuration(strconv.Itoa(dto.RetryInterval) + "s")
+	if err := ValidateHealthcheckAuth(dto.AuthType, dto.AuthCredentials); err != nil {
+		return nil, err
+	}
+	switch dto.Protocol {
+	case "", "http", "https":
+		if dto.ReqUrl == "" {
+			return nil, errors.New("HTTP healthchecks require a URL")
+		}
+		if dto.ReqMethod == "" {
+			return nil, errors.New("HTTP healthchecks require a method")
+		}
+		if dto.ExpectedStatus == 0 {
+			return nil, errors.New("HTTP healthchecks require an expected status")
+		}
+	case "tcp":
+	case "ssh":
+		if strings.TrimSpace(dto.ReqBody) == "" {
+			return nil, errors.New("SSH healthchecks require a command")
+		}
+	default:
+		return nil, errors.New("protocol must be http, https, tcp or ssh")
+	}
 	hc := Healthcheck{
 		Id:                   dto.Id,
 		Name:                 dto.Name,
@@ -93,6 +130,7 @@ func (dto HealthcheckDTO) ToHealthcheck() (*Healthcheck, error) {
 		ReqTimeout:           reqTimeout,
 		CheckInterval:        reqInterval,
 		RetryCount:           dto.RetryCount,
+		RetryInterval:        retryInterval,
 		ExpectedStatus:       dto.ExpectedStatus,
 		ExpectedResponseBody: dto.ExpectedResponseBody,
 		ResponseValidation:   *dto.ResponseValidation,
@@ -100,6 +138,19 @@ func (dto HealthcheckDTO) ToHealthcheck() (*Healthcheck, error) {
 		AuthCredentials:      dto.AuthCredentials,
 		Protocol:             dto.Protocol,
 	}
+	hc.CertWarnDays = 30
+	if dto.CertWarnDays != nil {
+		hc.CertWarnDays = *dto.CertWarnDays
+	}
+	if dto.CertFailDays != nil {
+		hc.CertFailDays = *dto.CertFailDays
+	}
+	if hc.CertWarnDays < 0 || hc.CertFailDays < 0 {
+		return nil, errors.New("certificate expiry thresholds can't be negative")
+	}
+	if err := hc.parseResponseValidation(); err != nil {
+		return nil, err
+	}
 	if dto.VerifySSL == "on" || dto.VerifySSL == "true" {
 		hc.VerifySSL = true
 	} else {
@@ -136,6 +187,21 @@ type HealthcheckResult struct {
 	ResBody               string    `json:"res_body" db:"res_body"`
 	ResTime               int       `json:"res_time" db:"res_time"` // in milliseconds
 	ErrorMessage          string    `json:"error_message" db:"error_message"`
+	// Why a successful result needs attention, e.g. a certificate about to expire
+	WarningMessage *string `json:"warning_message" db:"warning_message"`
+	// Certificate presented to https checks, nil for other protocols
+	CertSubject  *string                  `json:"cert_subject" db:"cert_subject"`
+	CertIssuer   *string                  `json:"cert_issuer" db:"cert_issuer"`
+	CertSans     []string                 `json:"cert_sans" db:"cert_sans"`
+	CertNotAfter *time.Time               `json:"cert_not_after" db:"cert_not_after"` // Expiry of the first certificate of the chain to expire
+	CertChain    []HealthcheckCertificate `json:"cert_chain" db:"cert_chain"`         // Starting with the certificate of the instance
+}
+
+// HealthcheckCertificate is a certificate of the chain presented to a healthcheck
+type HealthcheckCertificate struct {
+	Subject  string    `json:"subject"`
+	Issuer   string    `json:"issuer"`
+	NotAfter time.Time `json:"not_after"`
 }
 
 // ApplicationDefinition represents the definition of an application and its general properties
@@ -256,12 +322,194 @@ type Role struct {
 
 // ActionTemplate represents a reusable script template
 type ActionTemplate struct {
-	Id          uint      `json:"id" db:"id"`
-	Name        string    `json:"name" db:"name"`
-	Description string    `json:"description" db:"description"`
-	BashScript  string    `json:"bash_script" db:"bash_script"`
-	CreatedAt   time.Time `json:"created_at" db:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
+	Id               uint                        `json:"id" db:"id"`
+	Name             string                      `json:"name" db:"name"`
+	Description      string                      `json:"description" db:"description"`
+	BashScript       string                      `json:"bash_script" db:"bash_script"`
+	MaintenanceMode  bool                        `json:"maintenance_mode" db:"maintenance_mode"`   // Default for actions created from this template
+	RequiresApproval bool                        `json:"requires_approval" db:"requires_approval"` // Actions have to be approved by another user than their creator before they can be started
+	Parameters       ActionTemplateParameters    `json:"parameters" db:"parameters"`               // Prompted for when an action is created
+	FileTransfers    ActionTemplateFileTransfers `json:"file_transfers" db:"file_transfers"`       // Uploads run before the script, downloads after it
+	Steps            ActionTemplateSteps         `json:"steps" db:"steps"`                         // Pipeline run instead of the bash script, if any
+	Executor         string                      `json:"executor" db:"executor"`                   // Runs the scripts: ssh (default) on the server of the instance, local in a shell on the NAM host, webhook by posting them
+	Webhook          ActionTemplateWebhook       `json:"webhook" db:"webhook"`                     // Receives the scripts of the webhook executor
+	CreatedAt        time.Time                   `json:"created_at" db:"created_at"`
+	UpdatedAt        time.Time                   `json:"updated_at" db:"updated_at"`
+}
+
+// ActionTemplateParameter declares a parameter of an action template. Scripts refer to it like a variable, {{.NAME}}
+type ActionTemplateParameter struct {
+	Name        string   `json:"name"`
+	Type        string   `json:"type"` // string, int, bool, enum, secret (the value is the name of a secret)
+	Default     string   `json:"default"`
+	Description string   `json:"description"`
+	Regex       string   `json:"regex"`   // Values of string and int parameters must match it completely, if set
+	Options     []string `json:"options"` // Allowed values of enum parameters
+}
+
+// ActionTemplateParameters is the list of parameters of an action template.
+// Besides a JSON array, it is also decoded from a string containing the JSON encoded array, as sent by the template forms
+type ActionTemplateParameters []ActionTemplateParameter
+
+func (p *ActionTemplateParameters) UnmarshalJSON(b []byte) error {
+	var encoded string
+	if err := json.Unmarshal(b, &encoded); err == nil {
+		if encoded == "" {
+			*p = nil
+			return nil
+		}
+		b = []byte(encoded)
+	}
+	var parameters []ActionTemplateParameter
+	if err := json.Unmarshal(b, &parameters); err != nil {
+		return err
+	}
+	*p = parameters
+	return nil
+}
+
+// ActionTemplateFileTransfer copies a file between NAM and the server of an instance.
+// Uploads copy a file stored in NAM to the server before the script runs, downloads copy a file from the server into an artifact of the execution after it ran
+type ActionTemplateFileTransfer struct {
+	Direction  string `json:"direction"`   // upload, download
+	FileId     uint   `json:"file_id"`     // ActionFile to upload
+	RemotePath string `json:"remote_path"` // Path on the server, may refer to variables like the script, e.g. /opt/{{.APP_NAME}}/app.conf
+	Mode       string `json:"mode"`        // Octal permissions of uploaded files, defaults to 0644
+}
+
+// ActionTemplateFileTransfers is the list of file transfers of an action template.
+// Besides a JSON array, it is also decoded from a string containing the JSON encoded array, as sent by the template forms
+type ActionTemplateFileTransfers []ActionTemplateFileTransfer
+
+func (t *ActionTemplateFileTransfers) UnmarshalJSON(b []byte) error {
+	var encoded string
+	if err := json.Unmarshal(b, &encoded); err == nil {
+		if encoded == "" {
+			*t = nil
+			return nil
+		}
+		b = []byte(encoded)
+	}
+	var transfers []ActionTemplateFileTransfer
+	if err := json.Unmarshal(b, &transfers); err != nil {
+		return err
+	}
+	*t = transfers
+	return nil
+}
+
+// ActionTemplateWebhook receives the scripts of templates run by the webhook executor. NAM posts each script with its secrets to the URL,
+// and takes the exit code and output from the response
+type ActionTemplateWebhook struct {
+	Url     string            `json:"url"`     // May refer to variables and secrets like a script
+	Headers map[string]string `json:"headers"` // E.g. for authentication, may refer to variables and secrets like a script
+}
+
+// Besides a JSON object, the webhook is also decoded from a string containing the JSON encoded object, as sent by the template forms
+func (w *ActionTemplateWebhook) UnmarshalJSON(b []byte) error {
+	var encoded string
+	if err := json.Unmarshal(b, &encoded); err == nil {
+		if encoded == "" {
+			*w = ActionTemplateWebhook{}
+			return nil
+		}
+		b = []byte(encoded)
+	}
+	// The alias doesn't have this method, so it is decoded as a plain struct
+	type webhook ActionTemplateWebhook
+	var decoded webhook
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		return err
+	}
+	*w = ActionTemplateWebhook(decoded)
+	return nil
+}
+
+// ActionTemplateStep is a step of the pipeline of an action template. The steps run in order for every target instance
+type ActionTemplateStep struct {
+	Name              string `json:"name"`
+	Type              string `json:"type"`                // script, file_copy, wait_for_healthy, http, maintenance, confirmation
+	TimeoutSeconds    int    `json:"timeout_seconds"`     // Per attempt, 0 waits indefinitely. Defaults to 300 for wait_for_healthy steps
+	Retries           int    `json:"retries"`             // Further attempts after a failed one
+	RetryDelaySeconds int    `json:"retry_delay_seconds"` // Pause before each further attempt
+	OnFailure         string `json:"on_failure"`          // abort (default) skips the remaining steps, continue runs them but fails the execution, ignore doesn't fail it
+	AlwaysRun         bool   `json:"always_run"`          // Also runs after an earlier step aborted the pipeline, e.g. to disable maintenance mode again
+	// Settings of the type
+	Script       string                      `json:"script,omitempty"`        // script: rendered like the bash script of a template
+	FileTransfer *ActionTemplateFileTransfer `json:"file_transfer,omitempty"` // file_copy: uploads are copied to the server, downloads into an artifact
+	Http         *ActionTemplateHttpRequest  `json:"http,omitempty"`          // http: sent from NAM
+	Maintenance  string                      `json:"maintenance,omitempty"`   // maintenance: enable or disable maintenance mode of the instance
+	Message      string                      `json:"message,omitempty"`       // confirmation: shown to the user who has to confirm the step
+}
+
+// ActionTemplateHttpRequest is the request of an http step. Its URL, headers and body may refer to variables and secrets like a script
+type ActionTemplateHttpRequest struct {
+	Method         string            `json:"method"` // Defaults to GET
+	Url            string            `json:"url"`
+	Headers        map[string]string `json:"headers"`
+	Body           string            `json:"body"`
+	ExpectedStatus int               `json:"expected_status"` // 0 accepts any 2xx status
+}
+
+// ActionTemplateSteps is the pipeline of an action template.
+// Besides a JSON array, it is also decoded from a string containing the JSON encoded array, as sent by the template forms
+type ActionTemplateSteps []ActionTemplateStep
+
+func (s *ActionTemplateSteps) UnmarshalJSON(b []byte) error {
+	var encoded string
+	if err := json.Unmarshal(b, &encoded); err == nil {
+		if encoded == "" {
+			*s = nil
+			return nil
+		}
+		b = []byte(encoded)
+	}
+	var steps []ActionTemplateStep
+	if err := json.Unmarshal(b, &steps); err != nil {
+		return err
+	}
+	*s = steps
+	return nil
+}
+
+// ActionExecutionStep is the status and output of a step of the pipeline of an execution
+type ActionExecutionStep struct {
+	Id                uint       `json:"id" db:"id"`
+	ActionExecutionId uint       `json:"action_execution_id" db:"action_execution_id"`
+	Position          int        `json:"position" db:"position"` // Index of the step in the pipeline of the template
+	Name              string     `json:"name" db:"name"`
+	Type              string     `json:"type" db:"type"`
+	Status            string     `json:"status" db:"status"`     // pending, running, waiting (for confirmation), completed, failed, skipped, cancelled
+	Attempts          int        `json:"attempts" db:"attempts"` // Including retries
+	Output            string     `json:"output" db:"output"`
+	ErrorOutput       string     `json:"error_output" db:"error_output"`
+	ExitCode          *int       `json:"exit_code" db:"exit_code"` // Of script steps
+	StartedAt         *time.Time `json:"started_at" db:"started_at"`
+	CompletedAt       *time.Time `json:"completed_at" db:"completed_at"`
+}
+
+// ActionFile is a file stored in NAM, which action templates upload to servers
+type ActionFile struct {
+	Id              uint      `json:"id" db:"id"`
+	Name            string    `json:"name" db:"name"`
+	Description     string    `json:"description" db:"description"`
+	Content         []byte    `json:"-" db:"content"` // Only read when the file is transferred or downloaded
+	Size            int64     `json:"size" db:"size"`
+	Sha256          string    `json:"sha256" db:"sha256"`
+	CreatedByUserId uint64    `json:"created_by_user_id" db:"created_by_user_id"`
+	CreatedAt       time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// ActionExecutionArtifact is a file downloaded from the server of an execution
+type ActionExecutionArtifact struct {
+	Id                uint      `json:"id" db:"id"`
+	ActionExecutionId uint      `json:"action_execution_id" db:"action_execution_id"`
+	Name              string    `json:"name" db:"name"`
+	RemotePath        string    `json:"remote_path" db:"remote_path"` // Path on the server the file was downloaded from
+	Content           []byte    `json:"-" db:"content"`               // Only read when the artifact is downloaded
+	Size              int64     `json:"size" db:"size"`
+	CreatedAt         time.Time `json:"created_at" db:"created_at"`
 }
 
 // Action represents an execution of an action template
@@ -269,12 +517,32 @@ type Action struct {
 	Id               uint       `json:"id" db:"id"`
 	ActionTemplateId uint       `json:"action_template_id" db:"action_template_id"`
 	Name             string     `json:"name" db:"name"`
-	Status           string     `json:"status" db:"status"` // pending, running, completed, failed
+	Status           string     `json:"status" db:"status"` // pending_approval, pending, running, completed, failed, cancelled, rejected
 	CreatedAt        time.Time  `json:"created_at" db:"created_at"`
 	UpdatedAt        time.Time  `json:"updated_at" db:"updated_at"`
 	StartedAt        *time.Time `json:"started_at" db:"started_at"`
 	CompletedAt      *time.Time `json:"completed_at" db:"completed_at"`
 	CreatedByUserId  uint64     `json:"created_by_user_id" db:"created_by_user_id"`
+	// Execution strategy
+	ExecutionStrategy string `json:"execution_strategy" db:"execution_strategy"` // parallel, sequential, batch
+	BatchSize         int    `json:"batch_size" db:"batch_size"`                 // Executions per batch, or percentage of all executions if BatchSizePercent
+	BatchSizePercent  bool   `json:"batch_size_percent" db:"batch_size_percent"`
+	BatchPauseSeconds int    `json:"batch_pause_seconds" db:"batch_pause_seconds"` // Pause between batches
+	StopOnFailure     bool   `json:"stop_on_failure" db:"stop_on_failure"`         // Skip the remaining batches once an execution failed
+	// Healthcheck gating
+	WaitForHealthy        bool `json:"wait_for_healthy" db:"wait_for_healthy"`               // Wait for the instances of a batch to report healthy before starting the next one
+	HealthyTimeoutSeconds int  `json:"healthy_timeout_seconds" db:"healthy_timeout_seconds"` // The action fails if an instance does not report healthy in time
+	// Maintenance
+	MaintenanceMode bool `json:"maintenance_mode" db:"maintenance_mode"` // Put each target instance into maintenance mode while its script runs
+	// Values of the template parameters, keyed by parameter name
+	Parameters map[string]string `json:"parameters" db:"parameters"`
+	// Schedule which launched the action, nil if it was created manually
+	ActionScheduleId *uint `json:"action_schedule_id" db:"action_schedule_id"`
+	// Approval, the action stays in pending_approval until another user than its creator approves or rejects it
+	RequiresApproval bool       `json:"requires_approval" db:"requires_approval"`
+	ReviewedByUserId *uint64    `json:"reviewed_by_user_id" db:"reviewed_by_user_id"`
+	ReviewedAt       *time.Time `json:"reviewed_at" db:"reviewed_at"`
+	ReviewComment    string     `json:"review_comment" db:"review_comment"`
 }
 
 // ActionExecution represents the execution of an action on a specific instance
@@ -282,18 +550,53 @@ type ActionExecution struct {
 	Id                    uint       `json:"id" db:"id"`
 	ActionId              uint       `json:"action_id" db:"action_id"`
 	ApplicationInstanceId uint       `json:"application_instance_id" db:"application_instance_id"`
-	Status                string     `json:"status" db:"status"` // pending, running, completed, failed
+	Status                string     `json:"status" db:"status"`     // pending, running, completed, failed, cancelled, skipped
+	Position              int        `json:"position" db:"position"` // Executions are run in order of their position
 	Output                string     `json:"output" db:"output"`
 	ErrorOutput           string     `json:"error_output" db:"error_output"`
 	ExitCode              *int       `json:"exit_code" db:"exit_code"`
 	StartedAt             *time.Time `json:"started_at" db:"started_at"`
 	CompletedAt           *time.Time `json:"completed_at" db:"completed_at"`
+	// Filled in when listing the executions of an action
+	InstanceName    string `json:"instance_name,omitempty" db:"instance_name"`
+	ApplicationName string `json:"application_name,omitempty" db:"application_name"`
+	ServerHostname  string `json:"server_hostname,omitempty" db:"server_hostname"`
+}
+
+// ActionSchedule launches actions from a template once at a given time, or recurring on a cron expression
+type ActionSchedule struct {
+	Id               uint       `json:"id" db:"id"`
+	Name             string     `json:"name" db:"name"`
+	ActionTemplateId uint       `json:"action_template_id" db:"action_template_id"`
+	InstanceIds      []uint     `json:"instance_ids" db:"instance_ids"`       // In the order in which they are executed
+	CronExpression   string     `json:"cron_expression" db:"cron_expression"` // Empty for a one-off schedule
+	RunAt            *time.Time `json:"run_at" db:"run_at"`                   // Time of a one-off schedule
+	NextRunAt        *time.Time `json:"next_run_at" db:"next_run_at"`         // Nil while disabled and once a one-off schedule ran
+	LastRunAt        *time.Time `json:"last_run_at" db:"last_run_at"`
+	Enabled          bool       `json:"enabled" db:"enabled"`
+	CreatedByUserId  uint64     `json:"created_by_user_id" db:"created_by_user_id"` // Launched actions are created on behalf of this user
+	CreatedAt        time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt        time.Time  `json:"updated_at" db:"updated_at"`
+	// Settings of the launched actions, see Action
+	ExecutionStrategy     string            `json:"execution_strategy" db:"execution_strategy"`
+	BatchSize             int               `json:"batch_size" db:"batch_size"`
+	BatchSizePercent      bool              `json:"batch_size_percent" db:"batch_size_percent"`
+	BatchPauseSeconds     int               `json:"batch_pause_seconds" db:"batch_pause_seconds"`
+	StopOnFailure         bool              `json:"stop_on_failure" db:"stop_on_failure"`
+	WaitForHealthy        bool              `json:"wait_for_healthy" db:"wait_for_healthy"`
+	HealthyTimeoutSeconds int               `json:"healthy_timeout_seconds" db:"healthy_timeout_seconds"`
+	MaintenanceMode       bool              `json:"maintenance_mode" db:"maintenance_mode"`
+	Parameters            map[string]string `json:"parameters" db:"parameters"`
+	// Filled in when reading schedules
+	TemplateName string `json:"template_name,omitempty" db:"template_name"`
 }
 
 // ActionFull represents an action with its template and executions
 type ActionFull struct {
 	Action
-	Template   ActionTemplate    `json:"template"`
-	Executions []ActionExecution `json:"executions"`
-	CreatedBy  User              `json:"created_by"`
+	Template        ActionTemplate    `json:"template"`         // As it was when the action was created
+	TemplateChanged bool              `json:"template_changed"` // The template was changed since, which doesn't affect the action
+	Executions      []ActionExecution `json:"executions"`
+	CreatedBy       User              `json:"created_by"`
+	ReviewedBy      User              `json:"reviewed_by"` // Only the username is filled in, empty if the action was not reviewed
 }
@@ -18,8 +18,8 @@ func (s Server) DbInsert(pool *pgxpool.Pool) (*uint, error) {
 	}
 	defer tx.Rollback(context.Background())
 	err = tx.QueryRow(context.Background(), 
-		"INSERT INTO server (alias, hostname, ssh_port, ssh_auth_type, ssh_auth_secret_id, ssh_user) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id", 
-		s.Alias, s.Hostname, s.SshPort, s.SshAuthType, s.SshAuthSecretId, s.SshUser).Scan(&id)
+		"INSERT INTO server (alias, hostname, ssh_port, ssh_auth_type, ssh_auth_secret_id, ssh_user, ssh_jump_server_id, ssh_max_sessions) VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id", 
+		s.Alias, s.Hostname, s.SshPort, s.SshAuthType, s.SshAuthSecretId, s.SshUser, s.SshJumpServerId, s.SshMaxSessions).Scan(&id)
 	if err != nil {
 		return nil, err
 	}
@@ -77,7 +77,8 @@ func GetServerAll(pool *pgxpool.Pool) (*[]Server, error) {
 	}
 	rows, err := tx.Query(context.Background(), `
 		SELECT s.id as server_id, s.alias as server_alias, s.hostname as server_hostname, 
-		       s.ssh_port, s.ssh_auth_type, s.ssh_auth_secret_id, s.ssh_user 
+		       s.ssh_port, s.ssh_auth_type, s.ssh_auth_secret_id, s.ssh_user, s.ssh_jump_server_id, s.ssh_max_sessions,
+		       s.ssh_host_key, s.ssh_host_key_fingerprint, s.ssh_host_key_source, s.ssh_host_key_pinned_at
 		FROM Server s ORDER BY id ASC;`)
 	if err != nil {
 		return nil, err
@@ -97,7 +98,8 @@ func GetServerById(pool *pgxpool.Pool, id uint) (*Server, error) {
 	}
 	rows, err := tx.Query(context.Background(), `
 		SELECT s.id as server_id, s.alias as server_alias, s.hostname as server_hostname, 
-		       s.ssh_port, s.ssh_auth_type, s.ssh_auth_secret_id, s.ssh_user 
+		       s.ssh_port, s.ssh_auth_type, s.ssh_auth_secret_id, s.ssh_user, s.ssh_jump_server_id, s.ssh_max_sessions,
+		       s.ssh_host_key, s.ssh_host_key_fingerprint, s.ssh_host_key_source, s.ssh_host_key_pinned_at
 		FROM Server s WHERE s.id = $1`, id)
 	if err != nil {
 		return nil, err
@@ -125,11 +127,49 @@ func (server *Server) Update(pool *pgxpool.Pool) error {
 			ssh_port = $4, 
 			ssh_auth_type = $5, 
 			ssh_auth_secret_id = $6, 
-			ssh_user = $7 
+			ssh_user = $7, 
+			ssh_jump_server_id = $8, 
+			ssh_max_sessions = $9 
 		where id = $1`, 
-		server.Id, server.Alias, server.Hostname, server.SshPort, server.SshAuthType, server.SshAuthSecretId, server.SshUser)
+		server.Id, server.Alias, server.Hostname, server.SshPort, server.SshAuthType, server.SshAuthSecretId, server.SshUser, server.SshJumpServerId, server.SshMaxSessions)
 	if err != nil {
 		return err
 	}
 	return tx.Commit(context.Background())
 }
+
+// RecordServerHostKey stores the host key a server presented on first contact. If a fingerprint was pinned, it is only stored if it matches.
+// Returns false if another key was recorded meanwhile, or the fingerprint doesn't match
+func RecordServerHostKey(pool *pgxpool.Pool, id uint, key string, fingerprint string) (bool, error) {
+	tag, err := pool.Exec(context.Background(), `
+		UPDATE server
+		SET ssh_host_key = $2, ssh_host_key_fingerprint = $3,
+		    ssh_host_key_source = COALESCE(ssh_host_key_source, 'tofu'), ssh_host_key_pinned_at = COALESCE(ssh_host_key_pinned_at, NOW())
+		WHERE id = $1 AND ssh_host_key IS NULL AND (ssh_host_key_fingerprint IS NULL OR ssh_host_key_fingerprint = $3)`,
+		id, key, fingerprint)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// PinServerHostKey pins the host key of a server, as supplied by an Admin. The key may be nil if only its fingerprint is known,
+// it is recorded on the next contact then
+func PinServerHostKey(pool *pgxpool.Pool, id uint, key *string, fingerprint string) error {
+	_, err := pool.Exec(context.Background(), `
+		UPDATE server
+		SET ssh_host_key = $2, ssh_host_key_fingerprint = $3, ssh_host_key_source = 'admin', ssh_host_key_pinned_at = NOW()
+		WHERE id = $1`,
+		id, key, fingerprint)
+	return err
+}
+
+// ForgetServerHostKey removes the pinned host key of a server, so the key it presents on the next contact is trusted and pinned
+func ForgetServerHostKey(pool *pgxpool.Pool, id uint) error {
+	_, err := pool.Exec(context.Background(), `
+		UPDATE server
+		SET ssh_host_key = NULL, ssh_host_key_fingerprint = NULL, ssh_host_key_source = NULL, ssh_host_key_pinned_at = NULL
+		WHERE id = $1`,
+		id)
+	return err
+}
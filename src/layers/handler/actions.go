@@ -1,27 +1,29 @@
 package handlers
 
 import (
-	"bytes"
 	"encoding/json"
 	"fmt"
-	"html/template"
 	"kukus/nam/v2/layers/data"
+	services "kukus/nam/v2/layers/service"
 	"strconv"
 	"strings"
 
 	"github.com/gin-gonic/gin"
-	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 // ActionView handles action-related pages
 type ActionView struct {
 	Database *data.Database
+	Renderer *services.ScriptRenderer
+	Executor *services.ActionExecutor
 }
 
 // NewActionView creates a new ActionView handler
-func NewActionView(db *data.Database) *ActionView {
+func NewActionView(db *data.Database, renderer *services.ScriptRenderer, executor *services.ActionExecutor) *ActionView {
 	return &ActionView{
 		Database: db,
+		Renderer: renderer,
+		Executor: executor,
 	}
 }
 
@@ -114,11 +116,19 @@ func (av *ActionView) GetPageActionCreate(ctx *gin.Context) {
 		return
 	}
 
+	// Get secrets, their names are suggested for secret parameters
+	secrets, err := data.GetAllSecrets(av.Database.Pool)
+	if err != nil {
+		ctx.AbortWithStatusJSON(500, gin.H{"error": "Unable to get secrets", "trace": err.Error()})
+		return
+	}
+
 	ctx.HTML(200, "pages/actions/new", gin.H{
 		"ActionTemplates": templates,
 		"Instances":       instances,
 		"Applications":    applications,
 		"Servers":         servers,
+		"Secrets":         secrets,
 	})
 }
 
@@ -149,9 +159,104 @@ func (av *ActionView) GetPageActionDetails(ctx *gin.Context) {
 		return
 	}
 
-	ctx.HTML(200, "pages/action_details", gin.H{
-		"Action":     action,
-		"Executions": executions,
+	// Files downloaded by the executions
+	artifacts, err := data.GetActionExecutionArtifactsByActionId(av.Database.Pool, uint(id))
+	if err != nil {
+		ctx.AbortWithStatusJSON(500, gin.H{"error": "Unable to get artifacts", "trace": err.Error()})
+		return
+	}
+
+	// Steps of the executions, of pipelines
+	steps, err := data.GetActionExecutionStepsByActionId(av.Database.Pool, uint(id))
+	if err != nil {
+		ctx.AbortWithStatusJSON(500, gin.H{"error": "Unable to get steps", "trace": err.Error()})
+		return
+	}
+
+	// Users cannot approve their own actions
+	userId, _ := ctx.Get("user_id")
+
+	ctx.HTML(200, "pages/actions/details", gin.H{
+		"Action":           action,
+		"ActionTemplate":   action.Template,
+		"ActionExecutions": *executions,
+		"Artifacts":        *artifacts,
+		"Steps":            *steps,
+		"CurrentUserId":    userId,
+	})
+}
+
+// GetPageActionFiles renders the library of files which can be uploaded by action templates
+func (av *ActionView) GetPageActionFiles(ctx *gin.Context) {
+	files, err := data.GetActionFileAll(av.Database.Pool)
+	if err != nil {
+		ctx.AbortWithStatusJSON(500, gin.H{"error": "Unable to get action files", "trace": err.Error()})
+		return
+	}
+
+	ctx.HTML(200, "pages/actions/files", gin.H{
+		"Files": files,
+	})
+}
+
+// GetPageActionSchedules renders the action schedules list page
+func (av *ActionView) GetPageActionSchedules(ctx *gin.Context) {
+	schedules, err := data.GetActionScheduleAll(av.Database.Pool)
+	if err != nil {
+		ctx.AbortWithStatusJSON(500, gin.H{"error": "Unable to get action schedules", "trace": err.Error()})
+		return
+	}
+
+	ctx.HTML(200, "pages/actions/schedules", gin.H{
+		"Schedules": schedules,
+	})
+}
+
+// GetPageActionScheduleDetails renders the action schedule details page with the actions it launched
+func (av *ActionView) GetPageActionScheduleDetails(ctx *gin.Context) {
+	idParam := ctx.Param("id")
+	id, err := strconv.ParseUint(idParam, 10, 32)
+	if err != nil {
+		ctx.AbortWithStatusJSON(400, gin.H{"error": "Invalid schedule ID"})
+		return
+	}
+
+	schedule, err := data.GetActionScheduleById(av.Database.Pool, uint(id))
+	if err != nil {
+		ctx.AbortWithStatusJSON(500, gin.H{"error": "Unable to get action schedule", "trace": err.Error()})
+		return
+	}
+
+	if schedule == nil {
+		ctx.AbortWithStatusJSON(404, gin.H{"error": "Action schedule not found"})
+		return
+	}
+
+	runs, err := data.GetActionsByScheduleId(av.Database.Pool, schedule.Id, 50, 0)
+	if err != nil {
+		ctx.AbortWithStatusJSON(500, gin.H{"error": "Unable to get actions of schedule", "trace": err.Error()})
+		return
+	}
+
+	// Names of the target instances, in the order in which they are executed
+	instances, err := data.GetAllApplicationInstancesFull(av.Database.Pool)
+	if err != nil {
+		ctx.AbortWithStatusJSON(500, gin.H{"error": "Unable to get application instances", "trace": err.Error()})
+		return
+	}
+	var targets []data.ApplicationInstanceFull
+	for _, instanceId := range schedule.InstanceIds {
+		for _, instance := range *instances {
+			if instance.Id == instanceId {
+				targets = append(targets, instance)
+			}
+		}
+	}
+
+	ctx.HTML(200, "pages/actions/schedules/details", gin.H{
+		"Schedule":  schedule,
+		"Instances": targets,
+		"Runs":      *runs,
 	})
 }
 
@@ -171,7 +276,25 @@ func (av *ActionView) GetPageActionTemplates(ctx *gin.Context) {
 
 // GetPageActionTemplateCreate renders the action template creation page
 func (av *ActionView) GetPageActionTemplateCreate(ctx *gin.Context) {
-	ctx.HTML(200, "pages/actions/templates/new", gin.H{})
+	// Get files, they can be uploaded by the template
+	files, err := data.GetActionFileAll(av.Database.Pool)
+	if err != nil {
+		ctx.AbortWithStatusJSON(500, gin.H{"error": "Unable to get action files", "trace": err.Error()})
+		return
+	}
+
+	// Only Admins can select the local and webhook executors
+	user, err := data.GetUserByUsername(av.Database.Pool, ctx.GetString("username"))
+	if err != nil {
+		ctx.AbortWithStatusJSON(500, gin.H{"error": "Unable to get user", "trace": err.Error()})
+		return
+	}
+
+	ctx.HTML(200, "pages/actions/templates/new", gin.H{
+		"Files":         files,
+		"IsAdmin":       user != nil && user.HasRole("Admin"),
+		"LocalExecutor": av.Executor.LocalExecutor,
+	})
 }
 
 // GetPageActionTemplateDetails renders the action template details page
@@ -195,11 +318,19 @@ func (av *ActionView) GetPageActionTemplateDetails(ctx *gin.Context) {
 	}
 
 	// Extract variables from template
-	variables := data.ExtractTemplateVariables(template.BashScript)
+	variables := data.ExtractTemplateVariables(template.Script())
+
+	// Get files, for the names of the uploaded ones
+	files, err := data.GetActionFileAll(av.Database.Pool)
+	if err != nil {
+		ctx.AbortWithStatusJSON(500, gin.H{"error": "Unable to get action files", "trace": err.Error()})
+		return
+	}
 
 	ctx.HTML(200, "pages/actions/templates/details", gin.H{
 		"Template":  template,
 		"Variables": variables,
+		"Files":     files,
 	})
 }
 
@@ -224,11 +355,28 @@ func (av *ActionView) GetPageActionTemplateEdit(ctx *gin.Context) {
 	}
 
 	// Extract variables from template for preview
-	variables := data.ExtractTemplateVariables(template.BashScript)
+	variables := data.ExtractTemplateVariables(template.Script())
+
+	// Get files, they can be uploaded by the template
+	files, err := data.GetActionFileAll(av.Database.Pool)
+	if err != nil {
+		ctx.AbortWithStatusJSON(500, gin.H{"error": "Unable to get action files", "trace": err.Error()})
+		return
+	}
+
+	// Only Admins can change whether actions require approval, and select the local and webhook executors
+	user, err := data.GetUserByUsername(av.Database.Pool, ctx.GetString("username"))
+	if err != nil {
+		ctx.AbortWithStatusJSON(500, gin.H{"error": "Unable to get user", "trace": err.Error()})
+		return
+	}
 
 	ctx.HTML(200, "pages/actions/templates/edit", gin.H{
-		"Template":  template,
-		"Variables": variables,
+		"Template":      template,
+		"Variables":     variables,
+		"Files":         files,
+		"IsAdmin":       user != nil && user.HasRole("Admin"),
+		"LocalExecutor": av.Executor.LocalExecutor,
 	})
 }
 
@@ -257,91 +405,79 @@ func (av *ActionView) PostPageActionTemplateEdit(ctx *gin.Context) {
 	name := strings.TrimSpace(ctx.PostForm("name"))
 	description := strings.TrimSpace(ctx.PostForm("description"))
 	bashScript := ctx.PostForm("bash_script")
-
-	// Update the template
-	updatedTemplate := data.ActionTemplate{
-		Id:          existingTemplate.Id,
-		Name:        name,
-		Description: description,
-		BashScript:  bashScript,
-	}
-
-	err = data.UpdateActionTemplate(av.Database.Pool, &updatedTemplate)
-	if err != nil {
-		ctx.AbortWithStatusJSON(500, gin.H{"error": "Failed to update template", "trace": err.Error()})
-		return
-	}
-
-	// Redirect to template details page
-	ctx.Redirect(302, fmt.Sprintf("/actions/templates/%d/details", id))
-}
-
-// Utility functions for data processing
-
-// GetInstanceVariables gets all variables for an instance including app definition variables
-func GetInstanceVariables(pool *pgxpool.Pool, instanceId uint) (map[string]string, error) {
-	variables := make(map[string]string)
-
-	// Get instance
-	instance, err := data.GetApplicationInstanceById(pool, uint64(instanceId))
-	if err != nil {
-		return nil, err
+	maintenanceMode := ctx.PostForm("maintenance_mode") == "true"
+	requiresApproval := ctx.PostForm("requires_approval") == "true"
+	executor := ctx.PostForm("executor")
+	var webhook data.ActionTemplateWebhook
+	if webhookJSON := ctx.PostForm("webhook"); webhookJSON != "" {
+		if err := json.Unmarshal([]byte(webhookJSON), &webhook); err != nil {
+			ctx.AbortWithStatusJSON(400, gin.H{"error": "Invalid webhook format", "trace": err.Error()})
+			return
+		}
 	}
-
-	if instance == nil {
-		return nil, fmt.Errorf("instance not found")
+	var parameters data.ActionTemplateParameters
+	if parametersJSON := ctx.PostForm("parameters"); parametersJSON != "" {
+		if err := json.Unmarshal([]byte(parametersJSON), &parameters); err != nil {
+			ctx.AbortWithStatusJSON(400, gin.H{"error": "Invalid parameters format", "trace": err.Error()})
+			return
+		}
 	}
-
-	// Get application definition variables
-	appVars, err := data.GetApplicationDefinitionVariablesByApplicationDefinitionId(pool, uint64(instance.ApplicationDefinitionID))
-	if err == nil && appVars != nil {
-		for _, v := range *appVars {
-			variables[v.Name] = v.Value
+	var steps data.ActionTemplateSteps
+	if stepsJSON := ctx.PostForm("steps"); stepsJSON != "" {
+		if err := json.Unmarshal([]byte(stepsJSON), &steps); err != nil {
+			ctx.AbortWithStatusJSON(400, gin.H{"error": "Invalid steps format", "trace": err.Error()})
+			return
 		}
 	}
-
-	// Get instance-specific variables (these override app definition variables)
-	instanceVars, err := data.GetApplicationInstanceVariablesByApplicationInstanceId(pool, uint64(instanceId))
-	if err == nil && instanceVars != nil {
-		for _, v := range *instanceVars {
-			variables[v.Name] = v.Value
+	var fileTransfers data.ActionTemplateFileTransfers
+	if fileTransfersJSON := ctx.PostForm("file_transfers"); fileTransfersJSON != "" {
+		if err := json.Unmarshal([]byte(fileTransfersJSON), &fileTransfers); err != nil {
+			ctx.AbortWithStatusJSON(400, gin.H{"error": "Invalid file transfers format", "trace": err.Error()})
+			return
 		}
 	}
 
-	// Add built-in variables
-	variables["INSTANCE_NAME"] = instance.Name
-	// Note: Port information would need to be retrieved from application definition or instance data
-
-	// Get server hostname
-	server, err := data.GetServerById(pool, instance.ServerID)
-	if err == nil && server != nil {
-		variables["SERVER_HOSTNAME"] = server.Hostname
+	// Update the template
+	updatedTemplate := data.ActionTemplate{
+		Id:               existingTemplate.Id,
+		Name:             name,
+		Description:      description,
+		BashScript:       bashScript,
+		MaintenanceMode:  maintenanceMode,
+		RequiresApproval: requiresApproval,
+		Parameters:       parameters,
+		FileTransfers:    fileTransfers,
+		Steps:            steps,
+		Executor:         executor,
+		Webhook:          webhook,
+	}
+
+	if err := data.ValidateActionTemplate(&updatedTemplate); err != nil {
+		ctx.AbortWithStatusJSON(400, gin.H{"error": err.Error()})
+		return
 	}
-
-	// Get application name
-	app, err := data.GetApplicationDefinitionById(pool, uint64(instance.ApplicationDefinitionID))
-	if err == nil && app != nil {
-		variables["APP_NAME"] = app.Name
+	if err := av.Executor.CheckExecutor(&updatedTemplate); err != nil {
+		ctx.AbortWithStatusJSON(400, gin.H{"error": err.Error()})
+		return
 	}
-
-	return variables, nil
-}
-
-// RenderScriptPreview renders a script with variable substitution
-func RenderScriptPreview(script string, variables map[string]string) (string, error) {
-	// Try rendering the script with the provided variables using template package
-	// Configure template to error on missing keys instead of silently replacing with empty strings
-	tmpl, err := template.New("script").Option("missingkey=error").Parse(script)
+	user, err := data.GetUserByUsername(av.Database.Pool, ctx.GetString("username"))
 	if err != nil {
-		return "", fmt.Errorf("Error parsing script: %v", err)
+		ctx.AbortWithStatusJSON(500, gin.H{"error": "Unable to get user", "trace": err.Error()})
+		return
+	}
+	if err := data.CheckActionTemplatePrivileges(user, existingTemplate, &updatedTemplate); err != nil {
+		ctx.AbortWithStatusJSON(403, gin.H{"error": err.Error()})
+		return
 	}
 
-	var buf bytes.Buffer
-	if err := tmpl.Execute(&buf, variables); err != nil {
-		return "", fmt.Errorf("Error rendering script: %v", err)
+	err = data.UpdateActionTemplate(av.Database.Pool, &updatedTemplate)
+	if err != nil {
+		ctx.AbortWithStatusJSON(500, gin.H{"error": "Failed to update template", "trace": err.Error()})
+		return
 	}
 
-	return buf.String(), nil
+	// Redirect to template details page
+	ctx.Redirect(302, fmt.Sprintf("/actions/templates/%d/details", id))
 }
 
 // PreflightRequest represents the request structure for pre-flight checks
@@ -376,6 +512,9 @@ type PreflightInstance struct {
 	Status          string `json:"status"` // "success" or "error"
 	ErrorMessage    string `json:"error_message,omitempty"`
 	RenderedScript  string `json:"rendered_script,omitempty"`
+	// Variables referenced by the script but not defined for the instance, and defined ones the script doesn't use
+	MissingVariables []string `json:"missing_variables,omitempty"`
+	UnusedVariables  []string `json:"unused_variables,omitempty"`
 }
 
 type PreflightSummary struct {
@@ -396,6 +535,7 @@ func (av *ActionView) PostActionsPreflight(ctx *gin.Context) {
 	// Parse form data for HTMX request
 	targetsJSON := ctx.PostForm("targets")
 	templateIDStr := ctx.PostForm("template_id")
+	parametersJSON := ctx.PostForm("parameters")
 
 	var targets PreflightTargets
 	if err := json.Unmarshal([]byte(targetsJSON), &targets); err != nil {
@@ -403,6 +543,15 @@ func (av *ActionView) PostActionsPreflight(ctx *gin.Context) {
 		return
 	}
 
+	// Parameters without a value are previewed with their default
+	var parameters map[string]string
+	if parametersJSON != "" {
+		if err := json.Unmarshal([]byte(parametersJSON), &parameters); err != nil {
+			ctx.AbortWithStatusJSON(400, gin.H{"error": "Invalid parameters format", "trace": err.Error()})
+			return
+		}
+	}
+
 	// Validate template exists
 	templateID, err := strconv.ParseUint(templateIDStr, 10, 32)
 	if err != nil {
@@ -440,22 +589,19 @@ func (av *ActionView) PostActionsPreflight(ctx *gin.Context) {
 			ServerAlias:     instance.Server.Alias,
 		}
 
-		// Get variables for this instance
-		variables, err := GetInstanceVariables(av.Database.Pool, uint(instance.Id))
+		// Render the script the same way the executor does, without resolving secrets
+		rendered, err := av.Renderer.Render(template, uint(instance.Id), parameters, true)
+		if rendered != nil {
+			preflightInstance.MissingVariables = rendered.Missing
+			preflightInstance.UnusedVariables = rendered.Unused
+		}
 		if err != nil {
 			preflightInstance.Status = "error"
-			preflightInstance.ErrorMessage = fmt.Sprintf("Failed to get instance variables: %v", err)
+			preflightInstance.ErrorMessage = fmt.Sprintf("Failed to render script: %v", err)
 		} else {
-			// Try to render the template
-			renderedScript, err := RenderScriptPreview(template.BashScript, variables)
-			if err != nil {
-				preflightInstance.Status = "error"
-				preflightInstance.ErrorMessage = fmt.Sprintf("Failed to render script: %v", err)
-			} else {
-				preflightInstance.Status = "success"
-				preflightInstance.RenderedScript = renderedScript
-				successCount++
-			}
+			preflightInstance.Status = "success"
+			preflightInstance.RenderedScript = rendered.Script
+			successCount++
 		}
 
 		preflightInstances = append(preflightInstances, preflightInstance)
@@ -0,0 +1,204 @@
+package v1
+
+import (
+	"fmt"
+	"io"
+	data "kukus/nam/v2/layers/data"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Action export, for attaching the history of an action to incident tickets
+
+// ActionExport is an action with all of its executions, their steps and the files they downloaded
+type ActionExport struct {
+	ExportedAt time.Time                      `json:"exported_at"`
+	ExportedBy string                         `json:"exported_by"`
+	Action     *data.ActionFull               `json:"action"`
+	Steps      []data.ActionExecutionStep     `json:"steps"`     // Of all executions, ordered by execution and position
+	Artifacts  []data.ActionExecutionArtifact `json:"artifacts"` // Without their content, which is downloaded separately
+}
+
+// ExportAction returns the action with all of its executions as a JSON file, or as a text report with ?format=text
+func (ac *ActionController) ExportAction(ctx *gin.Context) {
+	id, err := strconv.ParseUint(ctx.Param("actionId"), 10, 32)
+	if err != nil {
+		ctx.JSON(400, gin.H{"error": "Invalid action ID"})
+		return
+	}
+	format := ctx.DefaultQuery("format", "json")
+	if format != "json" && format != "text" {
+		ctx.JSON(400, gin.H{"error": "Format must be json or text"})
+		return
+	}
+
+	action, err := data.GetActionById(ac.Database.Pool, uint(id))
+	if err != nil {
+		ctx.JSON(500, gin.H{"error": "Unable to get action", "trace": err.Error()})
+		return
+	}
+	if action == nil {
+		ctx.JSON(404, gin.H{"error": "Action not found"})
+		return
+	}
+	steps, err := data.GetActionExecutionStepsByActionId(ac.Database.Pool, action.Id)
+	if err != nil {
+		ctx.JSON(500, gin.H{"error": "Unable to get steps", "trace": err.Error()})
+		return
+	}
+	artifacts, err := data.GetActionExecutionArtifactsByActionId(ac.Database.Pool, action.Id)
+	if err != nil {
+		ctx.JSON(500, gin.H{"error": "Unable to get artifacts", "trace": err.Error()})
+		return
+	}
+	export := &ActionExport{
+		ExportedAt: time.Now(),
+		ExportedBy: ctx.GetString("username"),
+		Action:     action,
+		Steps:      *steps,
+		Artifacts:  *artifacts,
+	}
+
+	name := fmt.Sprintf("action-%d-%s", action.Id, export.ExportedAt.Format("20060102-150405"))
+	if format == "text" {
+		ctx.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+".txt"))
+		ctx.Status(200)
+		ctx.Header("Content-Type", "text/plain; charset=utf-8")
+		writeActionReport(ctx.Writer, export)
+		return
+	}
+	ctx.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+".json"))
+	ctx.IndentedJSON(200, export)
+}
+
+// Writes the export as a plain text report: a summary of the action followed by every execution with its steps and output
+func writeActionReport(w io.Writer, export *ActionExport) {
+	action := export.Action
+	reportTime := func(t *time.Time) string {
+		if t == nil {
+			return "-"
+		}
+		return t.Format("2006-01-02 15:04:05 MST")
+	}
+
+	fmt.Fprintf(w, "NAM action report\n=================\n\n")
+	fmt.Fprintf(w, "Action:     #%d %s\n", action.Id, action.Name)
+	fmt.Fprintf(w, "Status:     %s\n", action.Status)
+	fmt.Fprintf(w, "Template:   #%d %s (%s executor)\n", action.Template.Id, action.Template.Name, action.Template.Executor)
+	fmt.Fprintf(w, "Created:    %s by %s\n", reportTime(&action.CreatedAt), action.CreatedBy.Username)
+	if action.ReviewedAt != nil {
+		fmt.Fprintf(w, "Reviewed:   %s by %s", reportTime(action.ReviewedAt), action.ReviewedBy.Username)
+		if action.ReviewComment != "" {
+			fmt.Fprintf(w, ": %s", action.ReviewComment)
+		}
+		fmt.Fprintln(w)
+	}
+	fmt.Fprintf(w, "Started:    %s\n", reportTime(action.StartedAt))
+	fmt.Fprintf(w, "Completed:  %s\n", reportTime(action.CompletedAt))
+	fmt.Fprintf(w, "Strategy:   %s", action.ExecutionStrategy)
+	if action.ExecutionStrategy == "batch" {
+		unit := ""
+		if action.BatchSizePercent {
+			unit = "%"
+		}
+		fmt.Fprintf(w, ", batches of %d%s", action.BatchSize, unit)
+	}
+	if action.StopOnFailure {
+		fmt.Fprintf(w, ", stop on failure")
+	}
+	if action.WaitForHealthy {
+		fmt.Fprintf(w, ", wait for healthy (%ds)", action.HealthyTimeoutSeconds)
+	}
+	if action.MaintenanceMode {
+		fmt.Fprintf(w, ", maintenance mode")
+	}
+	fmt.Fprintln(w)
+	if len(action.Parameters) > 0 {
+		fmt.Fprintf(w, "Parameters:\n")
+		names := make([]string, 0, len(action.Parameters))
+		for name := range action.Parameters {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		for _, name := range names {
+			fmt.Fprintf(w, "  %s = %s\n", name, action.Parameters[name])
+		}
+	}
+	fmt.Fprintf(w, "Exported:   %s by %s\n", reportTime(&export.ExportedAt), export.ExportedBy)
+
+	statuses := map[string]int{}
+	var order []string
+	for _, execution := range action.Executions {
+		if statuses[execution.Status] == 0 {
+			order = append(order, execution.Status)
+		}
+		statuses[execution.Status]++
+	}
+	summary := make([]string, len(order))
+	for i, status := range order {
+		summary[i] = fmt.Sprintf("%d %s", statuses[status], status)
+	}
+	fmt.Fprintf(w, "\nExecutions: %d", len(action.Executions))
+	if len(summary) > 0 {
+		fmt.Fprintf(w, " (%s)", strings.Join(summary, ", "))
+	}
+	fmt.Fprintln(w)
+
+	for _, execution := range action.Executions {
+		fmt.Fprintf(w, "\n%s\n", strings.Repeat("-", 80))
+		fmt.Fprintf(w, "Execution #%d: %s (%s) on %s\n", execution.Id, execution.InstanceName, execution.ApplicationName, execution.ServerHostname)
+		fmt.Fprintf(w, "Status:     %s", execution.Status)
+		if execution.ExitCode != nil {
+			fmt.Fprintf(w, ", exit code %d", *execution.ExitCode)
+		}
+		fmt.Fprintln(w)
+		fmt.Fprintf(w, "Started:    %s\n", reportTime(execution.StartedAt))
+		fmt.Fprintf(w, "Completed:  %s", reportTime(execution.CompletedAt))
+		if execution.StartedAt != nil && execution.CompletedAt != nil {
+			fmt.Fprintf(w, " (took %s)", execution.CompletedAt.Sub(*execution.StartedAt).Round(time.Millisecond))
+		}
+		fmt.Fprintln(w)
+
+		var steps []data.ActionExecutionStep
+		for _, step := range export.Steps {
+			if step.ActionExecutionId == execution.Id {
+				steps = append(steps, step)
+			}
+		}
+		if len(steps) > 1 {
+			fmt.Fprintf(w, "Steps:\n")
+			for _, step := range steps {
+				fmt.Fprintf(w, "  %d. %s [%s] %s", step.Position+1, step.Name, step.Type, step.Status)
+				if step.ExitCode != nil {
+					fmt.Fprintf(w, ", exit code %d", *step.ExitCode)
+				}
+				if step.Attempts > 1 {
+					fmt.Fprintf(w, ", %d attempts", step.Attempts)
+				}
+				fmt.Fprintln(w)
+			}
+		}
+		for _, artifact := range export.Artifacts {
+			if artifact.ActionExecutionId == execution.Id {
+				fmt.Fprintf(w, "Artifact:   %s from %s (%d bytes)\n", artifact.Name, artifact.RemotePath, artifact.Size)
+			}
+		}
+		writeReportOutput(w, "stdout", execution.Output)
+		writeReportOutput(w, "stderr", execution.ErrorOutput)
+	}
+}
+
+// Writes a section of output, if there is any
+func writeReportOutput(w io.Writer, name, output string) {
+	if output == "" {
+		return
+	}
+	fmt.Fprintf(w, "\n--- %s ---\n%s", name, output)
+	if !strings.HasSuffix(output, "\n") {
+		fmt.Fprintln(w)
+	}
+}
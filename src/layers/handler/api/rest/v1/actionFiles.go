@@ -0,0 +1,148 @@
+package v1
+
+import (
+	"fmt"
+	"io"
+	data "kukus/nam/v2/layers/data"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Files are stored in the database, so their size is limited
+const maxActionFileSize = 64 << 20
+
+// Action File endpoints
+
+// GetAllActionFiles returns all files which can be uploaded by action templates, without their content
+func (ac *ActionController) GetAllActionFiles(ctx *gin.Context) {
+	files, err := data.GetActionFileAll(ac.Database.Pool)
+	if err != nil {
+		ctx.JSON(500, gin.H{"error": "Unable to get action files", "trace": err.Error()})
+		return
+	}
+	ctx.JSON(200, files)
+}
+
+// CreateActionFile stores a file uploaded as multipart form, with an optional name and description
+func (ac *ActionController) CreateActionFile(ctx *gin.Context) {
+	header, err := ctx.FormFile("file")
+	if err != nil {
+		ctx.JSON(400, gin.H{"error": "File is required", "trace": err.Error()})
+		return
+	}
+	if header.Size > maxActionFileSize {
+		ctx.JSON(413, gin.H{"error": fmt.Sprintf("File is larger than the maximum of %d MB", maxActionFileSize>>20)})
+		return
+	}
+
+	userIdInterface, exists := ctx.Get("user_id")
+	if !exists {
+		ctx.JSON(401, gin.H{"error": "User not authenticated"})
+		return
+	}
+	userId, ok := userIdInterface.(uint64)
+	if !ok {
+		ctx.JSON(500, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	reader, err := header.Open()
+	if err != nil {
+		ctx.JSON(400, gin.H{"error": "Unable to read file", "trace": err.Error()})
+		return
+	}
+	defer reader.Close()
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		ctx.JSON(400, gin.H{"error": "Unable to read file", "trace": err.Error()})
+		return
+	}
+
+	name := ctx.PostForm("name")
+	if name == "" {
+		name = header.Filename
+	}
+
+	created, err := data.CreateActionFile(ac.Database.Pool, &data.ActionFile{
+		Name:            name,
+		Description:     ctx.PostForm("description"),
+		Content:         content,
+		CreatedByUserId: userId,
+	})
+	if err != nil {
+		ctx.JSON(500, gin.H{"error": "Unable to store action file", "trace": err.Error()})
+		return
+	}
+
+	ctx.JSON(201, created)
+}
+
+// DownloadActionFile returns the content of a stored file
+func (ac *ActionController) DownloadActionFile(ctx *gin.Context) {
+	file, ok := ac.getActionFile(ctx)
+	if !ok {
+		return
+	}
+
+	ctx.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", file.Name))
+	ctx.Data(200, "application/octet-stream", file.Content)
+}
+
+// DeleteActionFile deletes a stored file
+func (ac *ActionController) DeleteActionFile(ctx *gin.Context) {
+	file, ok := ac.getActionFile(ctx)
+	if !ok {
+		return
+	}
+
+	if err := data.DeleteActionFile(ac.Database.Pool, file.Id); err != nil {
+		ctx.JSON(500, gin.H{"error": "Unable to delete action file", "trace": err.Error()})
+		return
+	}
+
+	ctx.Status(204)
+}
+
+// DownloadExecutionArtifact returns the content of a file downloaded by an execution
+func (ac *ActionController) DownloadExecutionArtifact(ctx *gin.Context) {
+	id, err := strconv.ParseUint(ctx.Param("artifactId"), 10, 32)
+	if err != nil {
+		ctx.JSON(400, gin.H{"error": "Invalid artifact ID"})
+		return
+	}
+
+	artifact, err := data.GetActionExecutionArtifactById(ac.Database.Pool, uint(id))
+	if err != nil {
+		ctx.JSON(500, gin.H{"error": "Unable to get artifact", "trace": err.Error()})
+		return
+	}
+	if artifact == nil {
+		ctx.JSON(404, gin.H{"error": "Artifact not found"})
+		return
+	}
+
+	ctx.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", artifact.Name))
+	ctx.Data(200, "application/octet-stream", artifact.Content)
+}
+
+// Gets the file of the request, the response is already written if it can't be found
+func (ac *ActionController) getActionFile(ctx *gin.Context) (*data.ActionFile, bool) {
+	id, err := strconv.ParseUint(ctx.Param("fileId"), 10, 32)
+	if err != nil {
+		ctx.JSON(400, gin.H{"error": "Invalid file ID"})
+		return nil, false
+	}
+
+	file, err := data.GetActionFileById(ac.Database.Pool, uint(id))
+	if err != nil {
+		ctx.JSON(500, gin.H{"error": "Unable to get action file", "trace": err.Error()})
+		return nil, false
+	}
+	if file == nil {
+		ctx.JSON(404, gin.H{"error": "Action file not found"})
+		return nil, false
+	}
+
+	return file, true
+}
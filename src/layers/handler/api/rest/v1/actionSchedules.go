@@ -0,0 +1,222 @@
+package v1
+
+import (
+	data "kukus/nam/v2/layers/data"
+	services "kukus/nam/v2/layers/service"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Action Schedule endpoints
+
+// GetAllActionSchedules returns all action schedules
+func (ac *ActionController) GetAllActionSchedules(ctx *gin.Context) {
+	schedules, err := data.GetActionScheduleAll(ac.Database.Pool)
+	if err != nil {
+		ctx.JSON(500, gin.H{"error": "Unable to get action schedules", "trace": err.Error()})
+		return
+	}
+	ctx.JSON(200, schedules)
+}
+
+// GetActionScheduleById returns a specific action schedule with the actions it launched, latest first
+func (ac *ActionController) GetActionScheduleById(ctx *gin.Context) {
+	schedule, ok := ac.getActionSchedule(ctx)
+	if !ok {
+		return
+	}
+
+	runs, err := data.GetActionsByScheduleId(ac.Database.Pool, schedule.Id, 50, 0)
+	if err != nil {
+		ctx.JSON(500, gin.H{"error": "Unable to get actions of schedule", "trace": err.Error()})
+		return
+	}
+
+	ctx.JSON(200, gin.H{
+		"schedule": schedule,
+		"runs":     runs,
+	})
+}
+
+// CreateActionSchedule creates a schedule launching an action once at a given time, or recurring on a cron expression
+func (ac *ActionController) CreateActionSchedule(ctx *gin.Context) {
+	var request struct {
+		Name             string `json:"name" binding:"required"`
+		ActionTemplateId uint   `json:"action_template_id" binding:"required"`
+		InstanceIds      []uint `json:"instance_ids" binding:"required"` // In the order in which they are executed
+		// Either a cron expression or a time to run at once
+		CronExpression string     `json:"cron_expression"`
+		RunAt          *time.Time `json:"run_at"`
+		// Settings of the launched actions, like when creating an action
+		ExecutionStrategy     string            `json:"execution_strategy"`
+		BatchSize             int               `json:"batch_size"`
+		BatchSizePercent      bool              `json:"batch_size_percent"`
+		BatchPauseSeconds     int               `json:"batch_pause_seconds"`
+		StopOnFailure         bool              `json:"stop_on_failure"`
+		WaitForHealthy        bool              `json:"wait_for_healthy"`
+		HealthyTimeoutSeconds int               `json:"healthy_timeout_seconds"`
+		MaintenanceMode       *bool             `json:"maintenance_mode"`
+		Parameters            map[string]string `json:"parameters"`
+	}
+
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		ctx.JSON(400, gin.H{"error": "Invalid input", "trace": err.Error()})
+		return
+	}
+
+	userIdInterface, exists := ctx.Get("user_id")
+	if !exists {
+		ctx.JSON(401, gin.H{"error": "User not authenticated"})
+		return
+	}
+	userId, ok := userIdInterface.(uint64)
+	if !ok {
+		ctx.JSON(500, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	template, err := data.GetActionTemplateById(ac.Database.Pool, request.ActionTemplateId)
+	if err != nil {
+		ctx.JSON(500, gin.H{"error": "Unable to get template", "trace": err.Error()})
+		return
+	}
+	if template == nil {
+		ctx.JSON(404, gin.H{"error": "Template not found"})
+		return
+	}
+
+	maintenanceMode := template.MaintenanceMode
+	if request.MaintenanceMode != nil {
+		maintenanceMode = *request.MaintenanceMode
+	}
+
+	parameters, err := ac.resolveActionParameters(template, request.Parameters)
+	if err != nil {
+		ctx.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	schedule := &data.ActionSchedule{
+		Name:                  request.Name,
+		ActionTemplateId:      request.ActionTemplateId,
+		InstanceIds:           request.InstanceIds,
+		CronExpression:        request.CronExpression,
+		RunAt:                 request.RunAt,
+		Enabled:               true,
+		CreatedByUserId:       userId,
+		ExecutionStrategy:     request.ExecutionStrategy,
+		BatchSize:             request.BatchSize,
+		BatchSizePercent:      request.BatchSizePercent,
+		BatchPauseSeconds:     request.BatchPauseSeconds,
+		StopOnFailure:         request.StopOnFailure,
+		WaitForHealthy:        request.WaitForHealthy,
+		HealthyTimeoutSeconds: request.HealthyTimeoutSeconds,
+		MaintenanceMode:       maintenanceMode,
+		Parameters:            parameters,
+	}
+
+	if err := data.ValidateActionSchedule(schedule); err != nil {
+		ctx.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if schedule.RunAt != nil && !schedule.RunAt.After(time.Now()) {
+		ctx.JSON(400, gin.H{"error": "Time to run at must be in the future"})
+		return
+	}
+
+	schedule.NextRunAt, err = services.NextScheduleRun(schedule, time.Now())
+	if err != nil {
+		ctx.JSON(400, gin.H{"error": "Invalid cron expression", "trace": err.Error()})
+		return
+	}
+	if schedule.NextRunAt == nil {
+		ctx.JSON(400, gin.H{"error": "Cron expression never matches"})
+		return
+	}
+
+	created, err := data.CreateActionSchedule(ac.Database.Pool, schedule)
+	if err != nil {
+		ctx.JSON(500, gin.H{"error": "Unable to create action schedule", "trace": err.Error()})
+		return
+	}
+
+	ctx.JSON(201, created)
+}
+
+// EnableActionSchedule enables a schedule again, its next run is calculated from now
+func (ac *ActionController) EnableActionSchedule(ctx *gin.Context) {
+	schedule, ok := ac.getActionSchedule(ctx)
+	if !ok {
+		return
+	}
+
+	next, err := services.NextScheduleRun(schedule, time.Now())
+	if err != nil {
+		ctx.JSON(400, gin.H{"error": "Unable to calculate the next run", "trace": err.Error()})
+		return
+	}
+	if next == nil {
+		ctx.JSON(409, gin.H{"error": "Schedule does not run anymore"})
+		return
+	}
+
+	if err := data.UpdateActionScheduleEnabled(ac.Database.Pool, schedule.Id, true, next); err != nil {
+		ctx.JSON(500, gin.H{"error": "Unable to enable action schedule", "trace": err.Error()})
+		return
+	}
+
+	ctx.Status(204)
+}
+
+// DisableActionSchedule disables a schedule, actions it already launched keep running
+func (ac *ActionController) DisableActionSchedule(ctx *gin.Context) {
+	schedule, ok := ac.getActionSchedule(ctx)
+	if !ok {
+		return
+	}
+
+	if err := data.UpdateActionScheduleEnabled(ac.Database.Pool, schedule.Id, false, nil); err != nil {
+		ctx.JSON(500, gin.H{"error": "Unable to disable action schedule", "trace": err.Error()})
+		return
+	}
+
+	ctx.Status(204)
+}
+
+// DeleteActionSchedule deletes a schedule, the actions it launched are kept
+func (ac *ActionController) DeleteActionSchedule(ctx *gin.Context) {
+	schedule, ok := ac.getActionSchedule(ctx)
+	if !ok {
+		return
+	}
+
+	if err := data.DeleteActionSchedule(ac.Database.Pool, schedule.Id); err != nil {
+		ctx.JSON(500, gin.H{"error": "Unable to delete action schedule", "trace": err.Error()})
+		return
+	}
+
+	ctx.Status(204)
+}
+
+// Gets the schedule of the request, the response is already written if it can't be found
+func (ac *ActionController) getActionSchedule(ctx *gin.Context) (*data.ActionSchedule, bool) {
+	id, err := strconv.ParseUint(ctx.Param("scheduleId"), 10, 32)
+	if err != nil {
+		ctx.JSON(400, gin.H{"error": "Invalid schedule ID"})
+		return nil, false
+	}
+
+	schedule, err := data.GetActionScheduleById(ac.Database.Pool, uint(id))
+	if err != nil {
+		ctx.JSON(500, gin.H{"error": "Unable to get action schedule", "trace": err.Error()})
+		return nil, false
+	}
+	if schedule == nil {
+		ctx.JSON(404, gin.H{"error": "Action schedule not found"})
+		return nil, false
+	}
+
+	return schedule, true
+}
@@ -1,21 +1,30 @@
 package v1
 
 import (
+	"errors"
 	"fmt"
 	data "kukus/nam/v2/layers/data"
+	services "kukus/nam/v2/layers/service"
+	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/gin-contrib/sse"
 	"github.com/gin-gonic/gin"
 )
 
 type ActionController struct {
 	Database *data.Database
+	Executor *services.ActionExecutor
+	Renderer *services.ScriptRenderer
 }
 
-func NewActionController(db *data.Database) *ActionController {
+func NewActionController(db *data.Database, executor *services.ActionExecutor, renderer *services.ScriptRenderer) *ActionController {
 	return &ActionController{
 		Database: db,
+		Executor: executor,
+		Renderer: renderer,
 	}
 }
 
@@ -67,6 +76,19 @@ func (ac *ActionController) CreateActionTemplate(ctx *gin.Context) {
 		ctx.JSON(400, gin.H{"error": err.Error()})
 		return
 	}
+	if err := ac.Executor.CheckExecutor(&template); err != nil {
+		ctx.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	user, err := data.GetUserByUsername(ac.Database.Pool, ctx.GetString("username"))
+	if err != nil {
+		ctx.JSON(500, gin.H{"error": "Unable to get user", "trace": err.Error()})
+		return
+	}
+	if err := data.CheckActionTemplatePrivileges(user, nil, &template); err != nil {
+		ctx.JSON(403, gin.H{"error": err.Error()})
+		return
+	}
 
 	created, err := data.CreateActionTemplate(ac.Database.Pool, &template)
 	if err != nil {
@@ -99,6 +121,28 @@ func (ac *ActionController) UpdateActionTemplate(ctx *gin.Context) {
 		ctx.JSON(400, gin.H{"error": err.Error()})
 		return
 	}
+	if err := ac.Executor.CheckExecutor(&template); err != nil {
+		ctx.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	existing, err := data.GetActionTemplateById(ac.Database.Pool, template.Id)
+	if err != nil {
+		ctx.JSON(500, gin.H{"error": "Unable to get action template", "trace": err.Error()})
+		return
+	}
+	if existing == nil {
+		ctx.JSON(404, gin.H{"error": "Action template not found"})
+		return
+	}
+	user, err := data.GetUserByUsername(ac.Database.Pool, ctx.GetString("username"))
+	if err != nil {
+		ctx.JSON(500, gin.H{"error": "Unable to get user", "trace": err.Error()})
+		return
+	}
+	if err := data.CheckActionTemplatePrivileges(user, existing, &template); err != nil {
+		ctx.JSON(403, gin.H{"error": err.Error()})
+		return
+	}
 
 	err = data.UpdateActionTemplate(ac.Database.Pool, &template)
 	if err != nil {
@@ -177,9 +221,10 @@ func (ac *ActionController) GetActionById(ctx *gin.Context) {
 // PreflightCheck validates an action before creation
 func (ac *ActionController) PreflightCheck(ctx *gin.Context) {
 	var request struct {
-		ActionName        string `json:"action_name" binding:"required"`
-		ActionTemplateId  uint   `json:"action_template_id" binding:"required"`
-		SelectedInstances []uint `json:"selected_instances" binding:"required"`
+		ActionName        string            `json:"action_name" binding:"required"`
+		ActionTemplateId  uint              `json:"action_template_id" binding:"required"`
+		SelectedInstances []uint            `json:"selected_instances" binding:"required"`
+		Parameters        map[string]string `json:"parameters"` // Parameters without a value are previewed with their default
 	}
 
 	if err := ctx.ShouldBindJSON(&request); err != nil {
@@ -199,73 +244,36 @@ func (ac *ActionController) PreflightCheck(ctx *gin.Context) {
 		return
 	}
 
-	// Extract template variables
-	variables := data.ExtractTemplateVariables(template.BashScript)
+	type preflightInstance struct {
+		InstanceId uint   `json:"instance_id"`
+		Valid      bool   `json:"valid"`
+		Error      string `json:"error,omitempty"`
+		*services.RenderedScript
+	}
 
-	// Validate instances and variables
+	// Render the script for every instance the same way the executor does, without resolving secrets
 	var errors []string
-	var variablePreview map[string]string
-	var scriptPreview string
-
-	// Check if instances exist and collect variables
-	allVariables := make(map[string]string)
-
+	instances := make([]preflightInstance, 0, len(request.SelectedInstances))
 	for _, instanceId := range request.SelectedInstances {
-		instance, err := data.GetApplicationInstanceById(ac.Database.Pool, uint64(instanceId))
+		result := preflightInstance{InstanceId: instanceId}
+		rendered, err := ac.Renderer.Render(template, instanceId, request.Parameters, true)
+		result.RenderedScript = rendered
 		if err != nil {
-			ctx.JSON(500, gin.H{"error": "Database error", "trace": err.Error()})
-			return
-		}
-
-		if instance == nil {
-			errors = append(errors, fmt.Sprintf("Instance with ID %d not found", instanceId))
-			continue
-		}
-
-		// Get instance variables (this is a simplified version)
-		// In reality, you'd merge app definition and instance variables
-		instanceVars, err := data.GetApplicationInstanceVariablesByApplicationInstanceId(ac.Database.Pool, uint64(instanceId))
-		if err == nil && instanceVars != nil {
-			for _, v := range *instanceVars {
-				allVariables[v.Name] = v.Value
-			}
-		}
-
-		// Add built-in variables
-		allVariables["INSTANCE_NAME"] = instance.Name
-		allVariables["SERVER_HOSTNAME"] = "example.com" // This would come from server data
-		allVariables["APP_NAME"] = "ExampleApp"         // This would come from app definition
-		allVariables["PORT"] = "8080"                   // This would come from app definition
-	}
-
-	// Check for missing variables
-	for _, variable := range variables {
-		if _, exists := allVariables[variable]; !exists {
-			errors = append(errors, fmt.Sprintf("Variable '%s' is not defined in any selected instance", variable))
-		}
-	}
-
-	valid := len(errors) == 0
-
-	if valid {
-		// Create variable preview and script preview
-		variablePreview = allVariables
-
-		// This is a simplified script preview - in reality you'd use Go templates
-		scriptPreview = template.BashScript
-		for key, value := range allVariables {
-			scriptPreview = strings.ReplaceAll(scriptPreview, fmt.Sprintf("{{.%s}}", key), value)
+			result.Error = err.Error()
+			errors = append(errors, fmt.Sprintf("Instance %d: %s", instanceId, err.Error()))
+		} else {
+			result.Valid = true
 		}
+		instances = append(instances, result)
 	}
 
 	response := gin.H{
-		"valid":            valid,
-		"action_name":      request.ActionName,
-		"template_name":    template.Name,
-		"instance_count":   len(request.SelectedInstances),
-		"errors":           errors,
-		"variable_preview": variablePreview,
-		"script_preview":   scriptPreview,
+		"valid":          len(errors) == 0,
+		"action_name":    request.ActionName,
+		"template_name":  template.Name,
+		"instance_count": len(request.SelectedInstances),
+		"errors":         errors,
+		"instances":      instances,
 	}
 
 	ctx.JSON(200, response)
@@ -276,7 +284,20 @@ func (ac *ActionController) CreateAction(ctx *gin.Context) {
 	var request struct {
 		Name             string `json:"name" binding:"required"`
 		ActionTemplateId uint   `json:"action_template_id" binding:"required"`
-		InstanceIds      []uint `json:"instance_ids" binding:"required"`
+		InstanceIds      []uint `json:"instance_ids" binding:"required"` // In the order in which they are executed
+		// Execution strategy, defaults to running all executions in parallel
+		ExecutionStrategy string `json:"execution_strategy"`
+		BatchSize         int    `json:"batch_size"`
+		BatchSizePercent  bool   `json:"batch_size_percent"`
+		BatchPauseSeconds int    `json:"batch_pause_seconds"`
+		StopOnFailure     bool   `json:"stop_on_failure"`
+		// Wait for the instances of each batch to report healthy before starting the next one
+		WaitForHealthy        bool `json:"wait_for_healthy"`
+		HealthyTimeoutSeconds int  `json:"healthy_timeout_seconds"`
+		// Put instances into maintenance mode while the script runs, defaults to the template setting
+		MaintenanceMode *bool `json:"maintenance_mode"`
+		// Values of the template parameters, parameters without a value get their default
+		Parameters map[string]string `json:"parameters"`
 	}
 
 	if err := ctx.ShouldBindJSON(&request); err != nil {
@@ -284,6 +305,11 @@ func (ac *ActionController) CreateAction(ctx *gin.Context) {
 		return
 	}
 
+	if len(request.InstanceIds) == 0 {
+		ctx.JSON(400, gin.H{"error": "At least one instance is required"})
+		return
+	}
+
 	// Get user ID from context (you'd need to implement JWT middleware)
 	userIdInterface, exists := ctx.Get("user_id")
 	if !exists {
@@ -296,34 +322,62 @@ func (ac *ActionController) CreateAction(ctx *gin.Context) {
 		return
 	}
 
-	// Create the action
-	action := &data.Action{
-		ActionTemplateId: request.ActionTemplateId,
-		Name:             request.Name,
-		Status:           "pending",
-		CreatedByUserId:  userId,
+	template, err := data.GetActionTemplateById(ac.Database.Pool, request.ActionTemplateId)
+	if err != nil {
+		ctx.JSON(500, gin.H{"error": "Unable to get template", "trace": err.Error()})
+		return
 	}
 
-	createdAction, err := data.CreateAction(ac.Database.Pool, action)
+	if template == nil {
+		ctx.JSON(404, gin.H{"error": "Template not found"})
+		return
+	}
+
+	maintenanceMode := template.MaintenanceMode
+	if request.MaintenanceMode != nil {
+		maintenanceMode = *request.MaintenanceMode
+	}
+
+	parameters, err := ac.resolveActionParameters(template, request.Parameters)
 	if err != nil {
-		ctx.JSON(500, gin.H{"error": "Unable to create action", "trace": err.Error()})
+		ctx.JSON(400, gin.H{"error": err.Error()})
 		return
 	}
 
-	// Create action executions for each instance
-	for _, instanceId := range request.InstanceIds {
-		execution := &data.ActionExecution{
-			ActionId:              createdAction.Id,
-			ApplicationInstanceId: instanceId,
-			Status:                "pending",
-		}
+	// Actions of templates requiring approval can only be started once another user approved them
+	status := "pending"
+	if template.RequiresApproval {
+		status = "pending_approval"
+	}
 
-		_, err := data.CreateActionExecution(ac.Database.Pool, execution)
-		if err != nil {
-			// Log error but don't fail the entire operation
-			// In production, you might want to use transactions
-			fmt.Printf("Warning: Failed to create execution for instance %d: %v\n", instanceId, err)
-		}
+	// Create the action
+	action := &data.Action{
+		ActionTemplateId:      request.ActionTemplateId,
+		Name:                  request.Name,
+		Status:                status,
+		CreatedByUserId:       userId,
+		ExecutionStrategy:     request.ExecutionStrategy,
+		BatchSize:             request.BatchSize,
+		BatchSizePercent:      request.BatchSizePercent,
+		BatchPauseSeconds:     request.BatchPauseSeconds,
+		StopOnFailure:         request.StopOnFailure,
+		WaitForHealthy:        request.WaitForHealthy,
+		HealthyTimeoutSeconds: request.HealthyTimeoutSeconds,
+		MaintenanceMode:       maintenanceMode,
+		Parameters:            parameters,
+		RequiresApproval:      template.RequiresApproval,
+	}
+
+	if err := data.ValidateActionStrategy(action); err != nil {
+		ctx.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	// The action is created with an execution for each instance
+	createdAction, err := data.CreateAction(ac.Database.Pool, action, request.InstanceIds)
+	if err != nil {
+		ctx.JSON(500, gin.H{"error": "Unable to create action", "trace": err.Error()})
+		return
 	}
 
 	ctx.JSON(201, gin.H{
@@ -333,6 +387,23 @@ func (ac *ActionController) CreateAction(ctx *gin.Context) {
 	})
 }
 
+// Resolves the parameter values of an action from the template, and checks that the secrets of secret parameters exist
+func (ac *ActionController) resolveActionParameters(template *data.ActionTemplate, values map[string]string) (map[string]string, error) {
+	parameters, err := data.ResolveActionParameters(template.Parameters, values)
+	if err != nil {
+		return nil, err
+	}
+	for _, parameter := range template.Parameters {
+		if parameter.Type != "secret" {
+			continue
+		}
+		if _, err := data.GetSecretByName(ac.Database.Pool, parameters[parameter.Name]); err != nil {
+			return nil, fmt.Errorf("secret %q of parameter %s not found", parameters[parameter.Name], parameter.Name)
+		}
+	}
+	return parameters, nil
+}
+
 // StartAction starts an action execution
 func (ac *ActionController) StartAction(ctx *gin.Context) {
 	idParam := ctx.Param("actionId")
@@ -342,19 +413,36 @@ func (ac *ActionController) StartAction(ctx *gin.Context) {
 		return
 	}
 
-	// Update action status to running
-	err = data.UpdateActionStatus(ac.Database.Pool, uint(id), "running")
+	action, err := data.GetActionById(ac.Database.Pool, uint(id))
 	if err != nil {
-		ctx.JSON(500, gin.H{"error": "Unable to start action", "trace": err.Error()})
+		ctx.JSON(500, gin.H{"error": "Unable to get action", "trace": err.Error()})
 		return
 	}
 
-	// TODO: Implement actual script execution
-	// This would involve:
-	// 1. Getting all executions for this action
-	// 2. For each execution, render the script with variables
-	// 3. Execute the script on the target server (via SSH or agent)
-	// 4. Update execution status and capture output
+	if action == nil {
+		ctx.JSON(404, gin.H{"error": "Action not found"})
+		return
+	}
+
+	if action.Status == "pending_approval" {
+		ctx.JSON(409, gin.H{"error": "Action has to be approved by another user before it can be started"})
+		return
+	}
+	if action.Status != "pending" {
+		ctx.JSON(400, gin.H{"error": "Only pending actions can be started", "trace": "action status is " + action.Status})
+		return
+	}
+
+	// Run the executions on the target servers in the background
+	err = ac.Executor.StartAction(action)
+	if errors.Is(err, services.ErrActionNotPending) {
+		ctx.JSON(409, gin.H{"error": "Action was started already"})
+		return
+	}
+	if err != nil {
+		ctx.JSON(500, gin.H{"error": "Unable to start action", "trace": err.Error()})
+		return
+	}
 
 	ctx.JSON(200, gin.H{"message": "Action started successfully"})
 }
@@ -368,18 +456,247 @@ func (ac *ActionController) CancelAction(ctx *gin.Context) {
 		return
 	}
 
-	// Update action status to failed (cancelled)
-	err = data.UpdateActionStatus(ac.Database.Pool, uint(id), "failed")
+	action, err := data.GetActionById(ac.Database.Pool, uint(id))
 	if err != nil {
-		ctx.JSON(500, gin.H{"error": "Unable to cancel action", "trace": err.Error()})
+		ctx.JSON(500, gin.H{"error": "Unable to get action", "trace": err.Error()})
 		return
 	}
 
-	// TODO: Actually stop running executions
+	if action == nil {
+		ctx.JSON(404, gin.H{"error": "Action not found"})
+		return
+	}
+
+	if action.Status != "pending_approval" && action.Status != "pending" && action.Status != "running" {
+		ctx.JSON(400, gin.H{"error": "Only pending or running actions can be cancelled", "trace": "action status is " + action.Status})
+		return
+	}
+
+	// Running scripts are terminated in the background, their partial output is kept
+	err = ac.Executor.CancelAction(action)
+	if err != nil {
+		ctx.JSON(500, gin.H{"error": "Unable to cancel action", "trace": err.Error()})
+		return
+	}
 
 	ctx.JSON(200, gin.H{"message": "Action cancelled successfully"})
 }
 
+// ApproveAction approves an action awaiting approval, so it can be started. Users cannot approve their own actions (four-eyes principle)
+func (ac *ActionController) ApproveAction(ctx *gin.Context) {
+	ac.reviewAction(ctx, true)
+}
+
+// RejectAction rejects an action awaiting approval, it can't be started anymore
+func (ac *ActionController) RejectAction(ctx *gin.Context) {
+	ac.reviewAction(ctx, false)
+}
+
+func (ac *ActionController) reviewAction(ctx *gin.Context, approved bool) {
+	idParam := ctx.Param("actionId")
+	id, err := strconv.ParseUint(idParam, 10, 32)
+	if err != nil {
+		ctx.JSON(400, gin.H{"error": "Invalid action ID"})
+		return
+	}
+
+	var request struct {
+		Comment string `json:"comment"`
+	}
+	// The comment is optional, so is the body
+	if ctx.Request.ContentLength > 0 {
+		if err := ctx.ShouldBindJSON(&request); err != nil {
+			ctx.JSON(400, gin.H{"error": "Invalid input", "trace": err.Error()})
+			return
+		}
+	}
+
+	userIdInterface, exists := ctx.Get("user_id")
+	if !exists {
+		ctx.JSON(401, gin.H{"error": "User not authenticated"})
+		return
+	}
+	userId, ok := userIdInterface.(uint64)
+	if !ok {
+		ctx.JSON(500, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	action, err := data.GetActionById(ac.Database.Pool, uint(id))
+	if err != nil {
+		ctx.JSON(500, gin.H{"error": "Unable to get action", "trace": err.Error()})
+		return
+	}
+
+	if action == nil {
+		ctx.JSON(404, gin.H{"error": "Action not found"})
+		return
+	}
+
+	if action.Status != "pending_approval" {
+		ctx.JSON(400, gin.H{"error": "Only actions awaiting approval can be approved or rejected", "trace": "action status is " + action.Status})
+		return
+	}
+	if approved && action.CreatedByUserId == userId {
+		ctx.JSON(403, gin.H{"error": "Actions have to be approved by another user than their creator"})
+		return
+	}
+
+	reviewed, err := data.ReviewAction(ac.Database.Pool, action.Id, approved, userId, strings.TrimSpace(request.Comment))
+	if err != nil {
+		ctx.JSON(500, gin.H{"error": "Unable to review action", "trace": err.Error()})
+		return
+	}
+	if !reviewed {
+		ctx.JSON(409, gin.H{"error": "Action was reviewed in the meantime"})
+		return
+	}
+
+	if !approved {
+		if err := data.CancelActionExecutions(ac.Database.Pool, action.Id); err != nil {
+			ctx.JSON(500, gin.H{"error": "Unable to cancel executions of rejected action", "trace": err.Error()})
+			return
+		}
+		ctx.JSON(200, gin.H{"message": "Action rejected"})
+		return
+	}
+
+	ctx.JSON(200, gin.H{"message": "Action approved"})
+}
+
+// RerunAction creates a new action like a finished one, with the same template, parameters, strategy and instances
+func (ac *ActionController) RerunAction(ctx *gin.Context) {
+	ac.rerunAction(ctx, false)
+}
+
+// RetryFailedAction creates a new action like a finished one, targeting only the instances whose executions failed
+func (ac *ActionController) RetryFailedAction(ctx *gin.Context) {
+	ac.rerunAction(ctx, true)
+}
+
+func (ac *ActionController) rerunAction(ctx *gin.Context, failedOnly bool) {
+	idParam := ctx.Param("actionId")
+	id, err := strconv.ParseUint(idParam, 10, 32)
+	if err != nil {
+		ctx.JSON(400, gin.H{"error": "Invalid action ID"})
+		return
+	}
+
+	userIdInterface, exists := ctx.Get("user_id")
+	if !exists {
+		ctx.JSON(401, gin.H{"error": "User not authenticated"})
+		return
+	}
+	userId, ok := userIdInterface.(uint64)
+	if !ok {
+		ctx.JSON(500, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	source, err := data.GetActionById(ac.Database.Pool, uint(id))
+	if err != nil {
+		ctx.JSON(500, gin.H{"error": "Unable to get action", "trace": err.Error()})
+		return
+	}
+	if source == nil {
+		ctx.JSON(404, gin.H{"error": "Action not found"})
+		return
+	}
+	switch source.Status {
+	case "completed", "failed", "cancelled", "rejected":
+	default:
+		ctx.JSON(400, gin.H{"error": "Only finished actions can be run again", "trace": "action status is " + source.Status})
+		return
+	}
+
+	// Executions are ordered by position, so the new action runs them in the same order
+	var instanceIds []uint
+	for _, execution := range source.Executions {
+		if !failedOnly || execution.Status == "failed" {
+			instanceIds = append(instanceIds, execution.ApplicationInstanceId)
+		}
+	}
+	if len(instanceIds) == 0 {
+		if failedOnly {
+			ctx.JSON(400, gin.H{"error": "No execution of the action failed"})
+		} else {
+			ctx.JSON(400, gin.H{"error": "The action has no executions"})
+		}
+		return
+	}
+
+	// The template may have changed since, its current parameters and approval requirement apply
+	template, err := data.GetActionTemplateById(ac.Database.Pool, source.ActionTemplateId)
+	if err != nil {
+		ctx.JSON(500, gin.H{"error": "Unable to get template", "trace": err.Error()})
+		return
+	}
+	if template == nil {
+		ctx.JSON(404, gin.H{"error": "Template of the action not found"})
+		return
+	}
+	parameters, err := ac.resolveActionParameters(template, source.Parameters)
+	if err != nil {
+		ctx.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	status := "pending"
+	if template.RequiresApproval {
+		status = "pending_approval"
+	}
+
+	action := &data.Action{
+		ActionTemplateId:      template.Id,
+		Name:                  rerunActionName(source, failedOnly),
+		Status:                status,
+		CreatedByUserId:       userId,
+		ExecutionStrategy:     source.ExecutionStrategy,
+		BatchSize:             source.BatchSize,
+		BatchSizePercent:      source.BatchSizePercent,
+		BatchPauseSeconds:     source.BatchPauseSeconds,
+		StopOnFailure:         source.StopOnFailure,
+		WaitForHealthy:        source.WaitForHealthy,
+		HealthyTimeoutSeconds: source.HealthyTimeoutSeconds,
+		MaintenanceMode:       source.MaintenanceMode,
+		Parameters:            parameters,
+		RequiresApproval:      template.RequiresApproval,
+	}
+	if err := data.ValidateActionStrategy(action); err != nil {
+		ctx.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	createdAction, err := data.CreateAction(ac.Database.Pool, action, instanceIds)
+	if err != nil {
+		ctx.JSON(500, gin.H{"error": "Unable to create action", "trace": err.Error()})
+		return
+	}
+
+	ctx.JSON(201, gin.H{
+		"id":     createdAction.Id,
+		"name":   createdAction.Name,
+		"status": createdAction.Status,
+	})
+}
+
+// Reruns of reruns refer to the last action only, instead of piling up suffixes
+var rerunNameSuffix = regexp.MustCompile(` \((run again|retry) of #\d+\)$`)
+
+// Returns the name of an action run again, which refers to the action it was run from
+func rerunActionName(source *data.ActionFull, failedOnly bool) string {
+	kind := "run again"
+	if failedOnly {
+		kind = "retry"
+	}
+	suffix := fmt.Sprintf(" (%s of #%d)", kind, source.Id)
+	name := rerunNameSuffix.ReplaceAllString(source.Name, "")
+	// Names are limited to 255 characters
+	if runes := []rune(name); len(runes)+len(suffix) > 255 {
+		name = string(runes[:255-len(suffix)])
+	}
+	return name + suffix
+}
+
 // GetActionStatus returns the current status of an action
 func (ac *ActionController) GetActionStatus(ctx *gin.Context) {
 	idParam := ctx.Param("actionId")
@@ -400,12 +717,60 @@ func (ac *ActionController) GetActionStatus(ctx *gin.Context) {
 		return
 	}
 
+	steps, err := data.GetActionExecutionStepsByActionId(ac.Database.Pool, action.Id)
+	if err != nil {
+		ctx.JSON(500, gin.H{"error": "Unable to get steps", "trace": err.Error()})
+		return
+	}
+
 	ctx.JSON(200, gin.H{
 		"action_status": action.Status,
 		"executions":    action.Executions,
+		"steps":         steps,
 	})
 }
 
+// ConfirmExecutionStep confirms a confirmation step which is waiting, so the pipeline of its execution continues
+func (ac *ActionController) ConfirmExecutionStep(ctx *gin.Context) {
+	ac.decideExecutionStep(ctx, true)
+}
+
+// RejectExecutionStep rejects a confirmation step which is waiting, which fails the step
+func (ac *ActionController) RejectExecutionStep(ctx *gin.Context) {
+	ac.decideExecutionStep(ctx, false)
+}
+
+func (ac *ActionController) decideExecutionStep(ctx *gin.Context, confirmed bool) {
+	executionId, err := strconv.ParseUint(ctx.Param("executionId"), 10, 32)
+	if err != nil {
+		ctx.JSON(400, gin.H{"error": "Invalid execution ID"})
+		return
+	}
+	stepId, err := strconv.ParseUint(ctx.Param("stepId"), 10, 32)
+	if err != nil {
+		ctx.JSON(400, gin.H{"error": "Invalid step ID"})
+		return
+	}
+
+	step, err := data.GetActionExecutionStepById(ac.Database.Pool, uint(stepId))
+	if err != nil {
+		ctx.JSON(500, gin.H{"error": "Unable to get step", "trace": err.Error()})
+		return
+	}
+	if step == nil || step.ActionExecutionId != uint(executionId) {
+		ctx.JSON(404, gin.H{"error": "Step not found"})
+		return
+	}
+
+	username := ctx.GetString("username")
+	if !ac.Executor.ConfirmStep(step.Id, confirmed, username) {
+		ctx.JSON(409, gin.H{"error": "Step is not waiting for confirmation"})
+		return
+	}
+
+	ctx.Status(204)
+}
+
 // GetExecutionLogs returns the logs for a specific execution
 func (ac *ActionController) GetExecutionLogs(ctx *gin.Context) {
 	idParam := ctx.Param("executionId")
@@ -433,3 +798,147 @@ func (ac *ActionController) GetExecutionLogs(ctx *gin.Context) {
 		"status":       execution.Status,
 	})
 }
+
+// StreamExecutionOutput streams the output of an execution as Server-Sent Events, from the beginning until the execution is finished.
+// Complete lines are sent as "stdout" and "stderr" events, one or more lines per event. Once the execution is finished,
+// a "status" event with its final status and exit code is sent, followed by a "done" event.
+// The event IDs hold the position in the output, so a reconnecting client resumes where it left off
+func (ac *ActionController) StreamExecutionOutput(ctx *gin.Context) {
+	idParam := ctx.Param("executionId")
+	id, err := strconv.ParseUint(idParam, 10, 32)
+	if err != nil {
+		ctx.JSON(400, gin.H{"error": "Invalid execution ID"})
+		return
+	}
+
+	execution, err := data.GetActionExecutionById(ac.Database.Pool, uint(id))
+	if err != nil {
+		ctx.JSON(500, gin.H{"error": "Unable to get execution", "trace": err.Error()})
+		return
+	}
+
+	if execution == nil {
+		ctx.JSON(404, gin.H{"error": "Execution not found"})
+		return
+	}
+
+	ctx.Header("Content-Type", "text/event-stream")
+	ctx.Header("Cache-Control", "no-cache")
+	ctx.Header("X-Accel-Buffering", "no") // Disable response buffering in nginx
+	ctx.Status(200)
+
+	stream := &executionOutputStream{ctx: ctx}
+	fmt.Sscanf(ctx.GetHeader("Last-Event-ID"), "%d:%d", &stream.stdout.offset, &stream.stderr.offset)
+	for {
+		// Running executions are followed in memory, everything else is read from the database
+		followed := ac.Executor.FollowExecution(ctx.Request.Context(), execution.Id, stream.stdout.offset, stream.stderr.offset, stream.Send)
+		if ctx.Request.Context().Err() != nil {
+			return
+		}
+		execution, err = data.GetActionExecutionById(ac.Database.Pool, execution.Id)
+		if err != nil || execution == nil {
+			// The client reconnects and resumes
+			return
+		}
+		stream.Send(execution.Output[min(stream.stdout.offset, len(execution.Output)):], execution.ErrorOutput[min(stream.stderr.offset, len(execution.ErrorOutput)):])
+		if followed || (execution.Status != "pending" && execution.Status != "running") {
+			break
+		}
+		// Not started yet, wait until the executor picks it up
+		select {
+		case <-ctx.Request.Context().Done():
+			return
+		case <-time.After(executionStreamPollInterval):
+		}
+	}
+
+	stream.Close()
+	stream.event("status", gin.H{
+		"status":    execution.Status,
+		"exit_code": execution.ExitCode,
+	})
+	stream.event("done", execution.Status) // Events without data are not dispatched by browsers
+	ctx.Writer.Flush()
+}
+
+// How often StreamExecutionOutput checks whether an execution, which is not running yet, has started
+const executionStreamPollInterval = 1 * time.Second
+
+// executionOutputStream sends chunks of execution output as events containing complete lines
+type executionOutputStream struct {
+	ctx    *gin.Context
+	stdout outputLines
+	stderr outputLines
+}
+
+// outputLines splits one output stream into lines
+type outputLines struct {
+	offset  int    // Number of bytes of output received so far
+	partial string // Last line, until its newline is received
+}
+
+// Send sends all lines completed by the chunks
+func (s *executionOutputStream) Send(stdout, stderr string) {
+	if lines, found := s.stdout.add(stdout); found {
+		s.event("stdout", lines)
+	}
+	if lines, found := s.stderr.add(stderr); found {
+		s.event("stderr", lines)
+	}
+	s.ctx.Writer.Flush()
+}
+
+// Close sends the last lines, if they did not end with a newline
+func (s *executionOutputStream) Close() {
+	if s.stdout.partial != "" {
+		lines := s.stdout.partial
+		s.stdout.partial = ""
+		s.event("stdout", lines)
+	}
+	if s.stderr.partial != "" {
+		lines := s.stderr.partial
+		s.stderr.partial = ""
+		s.event("stderr", lines)
+	}
+}
+
+func (s *executionOutputStream) event(name string, message any) {
+	if lines, ok := message.(string); ok {
+		message = cleanOutputLines(lines)
+	}
+	s.ctx.Render(-1, sse.Event{
+		// Position after the lines sent so far, partial lines are sent again after a reconnect
+		Id:    fmt.Sprintf("%d:%d", s.stdout.offset-len(s.stdout.partial), s.stderr.offset-len(s.stderr.partial)),
+		Event: name,
+		Data:  message,
+	})
+}
+
+// Adds a chunk of output, returns the lines it completed
+func (l *outputLines) add(chunk string) (string, bool) {
+	if chunk == "" {
+		return "", false
+	}
+	l.offset += len(chunk)
+	l.partial += chunk
+	end := strings.LastIndexByte(l.partial, '\n')
+	if end < 0 {
+		return "", false
+	}
+	lines := l.partial[:end]
+	l.partial = l.partial[end+1:]
+	return lines, true
+}
+
+// Carriage returns are used to redraw a line (progress bars), only the final state of each line is kept
+func cleanOutputLines(lines string) string {
+	split := strings.Split(lines, "\n")
+	for i, line := range split {
+		line = strings.TrimSuffix(line, "\r")
+		if cr := strings.LastIndexByte(line, '\r'); cr >= 0 {
+			line = line[cr+1:]
+		}
+		split[i] = line
+	}
+	return strings.Join(split, "\n")
+}
@@ -57,6 +57,7 @@ func (ac *HealthcheckController) NewHealthcheck(ctx *gin.Context) {
 	hc, err := dto.ToHealthcheck()
 	if err != nil {
 		ctx.JSON(400, gin.H{"error": "Error converting DTO to DAO", "trace": err.Error()})
+		return
 	}
 	id, err := hc.DbInsert(ac.Service.Database.Pool)
 	if err != nil {
@@ -1,19 +1,26 @@
 package v1
 
 import (
+	"errors"
 	data "kukus/nam/v2/layers/data"
+	services "kukus/nam/v2/layers/service"
 	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"golang.org/x/crypto/ssh"
 )
 
 type ServerController struct {
 	Database *data.Database
+	Secrets  *services.SecretsService // Decrypts the SSH credentials for connection tests
 }
 
-func NewServerController(db *data.Database) *ServerController {
+func NewServerController(db *data.Database, secrets *services.SecretsService) *ServerController {
 	return &ServerController{
 		Database: db,
+		Secrets:  secrets,
 	}
 }
 
@@ -51,6 +58,9 @@ func (sc *ServerController) NewServer(ctx *gin.Context) {
 		ctx.JSON(400, gin.H{"error": "Invalid JSON", "trace": err.Error()})
 		return
 	}
+	if !sc.validateSshSettings(ctx, &server) {
+		return
+	}
 	id, err := server.DbInsert(sc.Database.Pool)
 	if err != nil {
 		ctx.JSON(500, gin.H{"error": "Unable to create Server", "trace": err.Error()})
@@ -95,6 +105,10 @@ func (sc *ServerController) UpdateById(ctx *gin.Context) {
 		ctx.JSON(400, gin.H{"error": "ID in URL does not match ID in body"})
 		return
 	}
+	server.Id = uint(serverId)
+	if !sc.validateSshSettings(ctx, &server) {
+		return
+	}
 
 	err = server.Update(sc.Database.Pool)
 	if err != nil {
@@ -104,3 +118,133 @@ func (sc *ServerController) UpdateById(ctx *gin.Context) {
 	ctx.Header("HX-Redirect", "/servers/"+strconv.Itoa(int(server.Id))+"/view")
 	ctx.JSON(200, server)
 }
+
+// Checks the SSH session limit of the server, and that its jump host exists and its chain of jump hosts neither loops back to the server nor is too long
+func (sc *ServerController) validateSshSettings(ctx *gin.Context, server *data.Server) bool {
+	if server.SshMaxSessions != nil && *server.SshMaxSessions < 1 {
+		ctx.JSON(400, gin.H{"error": "SSH session limit must be at least 1"})
+		return false
+	}
+	if server.SshJumpServerId == nil {
+		return true
+	}
+	if *server.SshJumpServerId == server.Id {
+		ctx.JSON(400, gin.H{"error": "A server can't be its own jump host"})
+		return false
+	}
+	if _, err := services.SshJumpHosts(sc.Database.Pool, server); err != nil {
+		ctx.JSON(400, gin.H{"error": "Invalid jump host", "trace": err.Error()})
+		return false
+	}
+	return true
+}
+
+// TestConnection connects to the server with its SSH settings and runs a trivial command, reporting the latency, the remote OS and where it failed.
+// A failed test is a successful request, its result tells what went wrong
+func (sc *ServerController) TestConnection(ctx *gin.Context) {
+	server, ok := sc.getServer(ctx)
+	if !ok {
+		return
+	}
+	ctx.JSON(200, services.TestSshConnection(sc.Database.Pool, server, sc.Secrets))
+}
+
+// HostKeyPinRequest pins a host key by its public key, as in known_hosts or authorized_keys, or only by its fingerprint
+type HostKeyPinRequest struct {
+	Key         string `json:"key"`
+	Fingerprint string `json:"fingerprint"` // SHA256 fingerprint, as printed by ssh-keygen -l. Only used without a key
+}
+
+// ScanHostKey connects to the server to receive the host key it presents, so it can be compared to the pinned one
+func (sc *ServerController) ScanHostKey(ctx *gin.Context) {
+	server, ok := sc.getServer(ctx)
+	if !ok {
+		return
+	}
+
+	key, err := services.ScanSshHostKey(sc.Database.Pool, server, sc.Secrets)
+	if err != nil {
+		ctx.JSON(502, gin.H{"error": "Unable to receive host key", "trace": err.Error()})
+		return
+	}
+	fingerprint := ssh.FingerprintSHA256(key)
+	ctx.JSON(200, gin.H{
+		"key":         services.MarshalHostKey(key),
+		"fingerprint": fingerprint,
+		"pinned":      server.SshHostKeyFingerprint,
+		"matches":     server.SshHostKeyFingerprint != nil && *server.SshHostKeyFingerprint == fingerprint,
+	})
+}
+
+// PinHostKey pins the host key of the server, replacing the pinned one
+func (sc *ServerController) PinHostKey(ctx *gin.Context) {
+	server, ok := sc.getServer(ctx)
+	if !ok {
+		return
+	}
+	var request HostKeyPinRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		ctx.JSON(400, gin.H{"error": "Invalid JSON", "trace": err.Error()})
+		return
+	}
+
+	var key *string
+	var fingerprint string
+	if strings.TrimSpace(request.Key) != "" {
+		// known_hosts lines start with the host names, which are skipped
+		parsed, _, _, _, err := ssh.ParseAuthorizedKey([]byte(request.Key))
+		if err != nil {
+			_, _, parsed, _, _, err = ssh.ParseKnownHosts([]byte(request.Key))
+		}
+		if err != nil {
+			ctx.JSON(400, gin.H{"error": "Invalid host key", "trace": err.Error()})
+			return
+		}
+		marshalled := services.MarshalHostKey(parsed)
+		key = &marshalled
+		fingerprint = ssh.FingerprintSHA256(parsed)
+	} else {
+		var err error
+		if fingerprint, err = services.ParseHostKeyFingerprint(request.Fingerprint); err != nil {
+			ctx.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	if err := data.PinServerHostKey(sc.Database.Pool, server.Id, key, fingerprint); err != nil {
+		ctx.JSON(500, gin.H{"error": "Unable to pin host key", "trace": err.Error()})
+		return
+	}
+	ctx.JSON(200, gin.H{"fingerprint": fingerprint})
+}
+
+// ForgetHostKey removes the pinned host key of the server, so the key it presents on the next contact is pinned
+func (sc *ServerController) ForgetHostKey(ctx *gin.Context) {
+	server, ok := sc.getServer(ctx)
+	if !ok {
+		return
+	}
+	if err := data.ForgetServerHostKey(sc.Database.Pool, server.Id); err != nil {
+		ctx.JSON(500, gin.H{"error": "Unable to remove host key", "trace": err.Error()})
+		return
+	}
+	ctx.Status(204)
+}
+
+// Gets the server of the request, the response is already written if it can't be found
+func (sc *ServerController) getServer(ctx *gin.Context) (*data.Server, bool) {
+	serverId, err := strconv.ParseUint(ctx.Param("serverId"), 10, 32)
+	if err != nil {
+		ctx.JSON(400, gin.H{"error": "Must include valid ID of server"})
+		return nil, false
+	}
+	server, err := data.GetServerById(sc.Database.Pool, uint(serverId))
+	if errors.Is(err, pgx.ErrNoRows) {
+		ctx.JSON(404, gin.H{"error": "Server not found"})
+		return nil, false
+	} else if err != nil {
+		ctx.JSON(500, gin.H{"error": "Unable to get server", "trace": err.Error()})
+		return nil, false
+	}
+	return server, true
+}
@@ -74,3 +74,15 @@ func (av HealthcheckView) GetPageHealthcheckEdit(ctx *gin.Context) {
 		"Healthcheck": hc,
 	})
 }
+
+// GetPageHealthcheckCertificates renders the overview of the certificates presented by instances, sorted by expiry.
+func (av HealthcheckView) GetPageHealthcheckCertificates(ctx *gin.Context) {
+	certificates, err := data.GetInstanceCertificates(av.Database.Pool)
+	if err != nil {
+		ctx.AbortWithStatusJSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.HTML(200, "pages/healthchecks/certificates", gin.H{
+		"Certificates": *certificates,
+	})
+}
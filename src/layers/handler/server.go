@@ -36,8 +36,15 @@ func (h PageServerHandler) GetPageServerCreate(c *gin.Context) {
 		c.AbortWithStatusJSON(500, gin.H{"error": "Unable to get secrets", "trace": err.Error()})
 		return
 	}
+	// Other servers can be jump hosts
+	servers, err := data.GetServerAll(h.Database.Pool)
+	if err != nil {
+		c.AbortWithStatusJSON(500, gin.H{"error": "Unable to get servers", "trace": err.Error()})
+		return
+	}
 	c.HTML(http.StatusOK, "pages/servers/create", gin.H{
 		"SshSecrets": secrets,
+		"Servers":    servers,
 	})
 }
 
@@ -59,9 +66,15 @@ func (h PageServerHandler) GetPageServerEdit(c *gin.Context) {
 		c.AbortWithStatusJSON(500, gin.H{"error": "Unable to get secrets", "trace": err.Error()})
 		return
 	}
+	servers, err := data.GetServerAll(h.Database.Pool)
+	if err != nil {
+		c.AbortWithStatusJSON(500, gin.H{"error": "Unable to get servers", "trace": err.Error()})
+		return
+	}
 	c.HTML(http.StatusOK, "pages/servers/edit", gin.H{
 		"Server":     server,
 		"SshSecrets": secrets,
+		"Servers":    servers,
 	})
 }
 
@@ -87,8 +100,22 @@ func (h PageServerHandler) GetPageServerView(c *gin.Context) {
 		}
 	}
 
+	var jumpServer *data.Server
+	if server.SshJumpServerId != nil {
+		jumpServer, _ = data.GetServerById(h.Database.Pool, *server.SshJumpServerId)
+	}
+
+	// Only Admins can pin host keys
+	user, err := data.GetUserByUsername(h.Database.Pool, c.GetString("username"))
+	if err != nil {
+		c.AbortWithStatusJSON(500, gin.H{"error": "Unable to get user", "trace": err.Error()})
+		return
+	}
+
 	c.HTML(http.StatusOK, "pages/servers/view", gin.H{
 		"Server":        server,
 		"SshSecretName": sshSecretName,
+		"JumpServer":    jumpServer,
+		"IsAdmin":       user != nil && user.HasRole("Admin"),
 	})
 }
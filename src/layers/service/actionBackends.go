@@ -0,0 +1,255 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// This file provides the backends which run the scripts of executions, selected by the executor of their template:
+// over SSH on the server of the instance, in a local shell on the NAM host, or by a webhook.
+
+// Only the start of the response of a webhook is kept in the output
+const maxWebhookResponseSize = 4 << 20
+
+// Timeout of webhooks running a script step without a timeout of its own. Generous, as the webhook answers only once the script finished
+const defaultWebhookTimeout = 30 * time.Minute
+
+// scriptBackend runs the rendered scripts of an execution
+type scriptBackend interface {
+	// Run runs the script with the environment variables, which hold the values of its secrets and parameters. Returns its exit code,
+	// an error if the script could not be run at all, or errExecutionCancelled if the context was done first
+	Run(ctx context.Context, script string, environment map[string]string, output *executionOutput) (*int, error)
+}
+
+// Returns the backend of the executor of the execution's template
+func (ae *ActionExecutor) backend(run *pipelineRun) scriptBackend {
+	switch run.action.Template.Executor {
+	case "local":
+		return &localBackend{log: run.log}
+	case "webhook":
+		return &webhookBackend{executor: ae, run: run}
+	default:
+		return &sshBackend{executor: ae, run: run}
+	}
+}
+
+// sshBackend runs scripts on the server of the instance
+type sshBackend struct {
+	executor *ActionExecutor
+	run      *pipelineRun
+}
+
+func (b *sshBackend) Run(ctx context.Context, script string, environment map[string]string, output *executionOutput) (*int, error) {
+	client, err := b.run.ssh(ctx, b.executor, output)
+	if err != nil {
+		return nil, err
+	}
+	session, err := client.NewSession()
+	if err != nil {
+		return nil, fmt.Errorf("unable to open SSH session: %w", err)
+	}
+	defer session.Close()
+
+	if ctx.Err() != nil {
+		return nil, errExecutionCancelled
+	}
+
+	// The script is fed through stdin, so it doesn't have to be quoted or copied to the server
+	if !setSessionEnvironment(session, environment) {
+		// Most SSH servers only accept a few variables (AcceptEnv), so the variables are exported ahead of the script instead.
+		// Either way, they are neither part of the command line nor of the stored script
+		b.run.log.Debug("SSH server refused environment variables, exporting them through stdin")
+		script = exportEnvironment(environment) + script
+	}
+	group := &remoteProcessGroup{}
+	session.Stdin = strings.NewReader(script)
+	session.Stdout = group.Writer(output.Stdout())
+	session.Stderr = output.Stderr()
+	if err := session.Start(remoteScriptCommand); err != nil {
+		return nil, fmt.Errorf("unable to start script: %w", err)
+	}
+	finished := make(chan error, 1)
+	go func() {
+		finished <- session.Wait()
+	}()
+	select {
+	case err = <-finished:
+	case <-ctx.Done():
+		b.executor.terminate(client, session, group, finished, b.run.log)
+		return nil, errExecutionCancelled
+	}
+	var exitErr *ssh.ExitError
+	if errors.As(err, &exitErr) {
+		exitCode := exitErr.ExitStatus()
+		return &exitCode, nil
+	} else if err != nil {
+		return nil, err
+	}
+	exitCode := 0
+	return &exitCode, nil
+}
+
+// localBackend runs scripts with bash on the NAM host, as the user NAM runs as. Meant for scripts calling the APIs of targets which can't be reached over SSH
+type localBackend struct {
+	log *slog.Logger
+}
+
+func (b *localBackend) Run(ctx context.Context, script string, environment map[string]string, output *executionOutput) (*int, error) {
+	cmd := exec.Command("bash", "-s")
+	cmd.Stdin = strings.NewReader(script)
+	cmd.Stdout = output.Stdout()
+	cmd.Stderr = output.Stderr()
+	cmd.Env = os.Environ()
+	for name, value := range environment {
+		cmd.Env = append(cmd.Env, name+"="+value)
+	}
+	// The script gets its own process group, so its whole process tree can be signalled when it is cancelled
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	// Processes the script left running in the background must not keep the execution open
+	cmd.WaitDelay = executionCancelGracePeriod
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("unable to start script: %w", err)
+	}
+	finished := make(chan error, 1)
+	go func() {
+		finished <- cmd.Wait()
+	}()
+
+	var err error
+	select {
+	case err = <-finished:
+	case <-ctx.Done():
+		b.terminate(cmd.Process.Pid, finished)
+		return nil, errExecutionCancelled
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		exitCode := exitErr.ExitCode()
+		return &exitCode, nil
+	} else if err != nil && !errors.Is(err, exec.ErrWaitDelay) {
+		return nil, err
+	}
+	exitCode := 0
+	return &exitCode, nil
+}
+
+// Stops a running script. Sends SIGTERM to its process group, and SIGKILL if it is still running after the grace period
+func (b *localBackend) terminate(pgid int, finished <-chan error) {
+	for _, signal := range []syscall.Signal{syscall.SIGTERM, syscall.SIGKILL} {
+		b.log.Info("Signalling script", "signal", signal)
+		if err := syscall.Kill(-pgid, signal); err != nil {
+			b.log.Debug("Failed to signal process group", "signal", signal, "pgid", pgid, "error", err)
+		}
+		select {
+		case <-finished:
+			return
+		case <-time.After(executionCancelGracePeriod):
+		}
+	}
+	b.log.Warn("Script did not exit after SIGKILL, abandoning it")
+}
+
+// webhookBackend posts scripts to the webhook of the template, which runs them where NAM can't
+type webhookBackend struct {
+	executor *ActionExecutor
+	run      *pipelineRun
+}
+
+// webhookRequest is posted to the webhook for every script
+type webhookRequest struct {
+	ActionId       uint              `json:"action_id"`
+	ActionName     string            `json:"action_name"`
+	ExecutionId    uint              `json:"execution_id"`
+	InstanceId     uint              `json:"instance_id"`
+	ServerHostname string            `json:"server_hostname"`
+	Script         string            `json:"script"`
+	Environment    map[string]string `json:"environment"` // Values of the secrets and parameters the script refers to, keyed by their environment variable
+}
+
+// webhookResponse is the result of a script, returned by the webhook as JSON. Responses of other types are taken as the output of a successful script
+type webhookResponse struct {
+	ExitCode *int   `json:"exit_code"` // Defaults to 0
+	Stdout   string `json:"stdout"`
+	Stderr   string `json:"stderr"`
+}
+
+func (b *webhookBackend) Run(ctx context.Context, script string, environment map[string]string, output *executionOutput) (*int, error) {
+	webhook := b.run.action.Template.Webhook
+	url, err := b.run.render(b.executor, "webhook URL", webhook.Url, output)
+	if err != nil {
+		return nil, err
+	}
+	body, err := json.Marshal(webhookRequest{
+		ActionId:       b.run.action.Id,
+		ActionName:     b.run.action.Name,
+		ExecutionId:    b.run.execution.Id,
+		InstanceId:     b.run.execution.ApplicationInstanceId,
+		ServerHostname: b.run.server.Hostname,
+		Script:         script,
+		Environment:    environment,
+	})
+	if err != nil {
+		return nil, err
+	}
+	requestCtx, cancel := requestContext(ctx, defaultWebhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(requestCtx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range webhook.Headers {
+		if value, err = b.run.render(b.executor, "webhook header "+name, value, output); err != nil {
+			return nil, err
+		}
+		req.Header.Set(name, value)
+	}
+
+	b.run.log.Debug("Posting script to webhook", "url", url)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, errExecutionCancelled
+		}
+		return nil, fmt.Errorf("unable to call webhook: %w", requestError(ctx, requestCtx, defaultWebhookTimeout, err))
+	}
+	defer resp.Body.Close()
+	content, err := io.ReadAll(io.LimitReader(resp.Body, maxWebhookResponseSize))
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, errExecutionCancelled
+		}
+		return nil, fmt.Errorf("unable to read webhook response: %w", requestError(ctx, requestCtx, defaultWebhookTimeout, err))
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		output.Stderr().Write(content)
+		return nil, fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	var result webhookResponse
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "application/json" || json.Unmarshal(content, &result) != nil {
+		result = webhookResponse{Stdout: string(content)}
+	}
+	io.WriteString(output.Stdout(), result.Stdout)
+	io.WriteString(output.Stderr(), result.Stderr)
+	if result.ExitCode == nil {
+		exitCode := 0
+		result.ExitCode = &exitCode
+	}
+	return result.ExitCode, nil
+}
@@ -0,0 +1,840 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"kukus/nam/v2/layers/data"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// This file provides the executor, which runs actions on their target application instances, over SSH unless their template selects another backend.
+
+const (
+	// How often the output of running executions is written to the database
+	executionOutputFlushInterval = 1 * time.Second
+	// Replaces the values of secrets in the output of executions
+	secretMask = "********"
+	// How long a cancelled script gets to exit after SIGTERM, before it is killed with SIGKILL
+	executionCancelGracePeriod = 10 * time.Second
+	// Runs the script fed through stdin in a new session, so its whole process tree can be signalled through the process group.
+	// The first line of stdout is the process group ID. -w makes setsid wait for the script and return its exit code
+	remoteScriptCommand = "setsid -w bash -c 'echo $$; exec bash -s'"
+)
+
+var errExecutionCancelled = errors.New("execution cancelled")
+
+// ErrActionNotPending is returned when starting an action which is not pending, e.g. because it was started concurrently
+var ErrActionNotPending = errors.New("only pending actions can be started")
+
+// ActionExecutor runs the executions of an action on the servers of their application instances
+type ActionExecutor struct {
+	Database      *data.Database
+	Logger        *slog.Logger
+	Secrets       *SecretsService
+	Ssh           *SshPool                       // Shares the SSH connections to servers between executions
+	Renderer      *ScriptRenderer                // Renders the scripts the same way as the preflight check
+	Healthchecks  *HealthcheckService            // Used to wait for instances to report healthy, nil if the HealthcheckService is disabled
+	LocalExecutor bool                           // Whether the local executor may run scripts on the NAM host, off unless enabled in the configuration
+	running       map[uint]context.CancelFunc    // Cancel functions of the actions currently being executed, keyed by action ID
+	outputs       map[uint]*executionOutput      // Output of the executions currently being executed, keyed by execution ID
+	confirmations map[uint]chan stepConfirmation // Confirmation steps waiting for a user, keyed by step ID
+	lock          sync.Mutex
+}
+
+func NewActionExecutor(database *data.Database, logger *slog.Logger, secrets *SecretsService, sshPool *SshPool, renderer *ScriptRenderer, healthchecks *HealthcheckService, localExecutor bool) *ActionExecutor {
+	return &ActionExecutor{
+		Database:      database,
+		Logger:        logger.With("service", "ActionExecutor"),
+		Secrets:       secrets,
+		Ssh:           sshPool,
+		Renderer:      renderer,
+		Healthchecks:  healthchecks,
+		LocalExecutor: localExecutor,
+		running:       make(map[uint]context.CancelFunc),
+		outputs:       make(map[uint]*executionOutput),
+		confirmations: make(map[uint]chan stepConfirmation),
+	}
+}
+
+// StartAction marks the action as running and runs all of its executions in the background
+func (ae *ActionExecutor) StartAction(action *data.ActionFull) error {
+	if err := ae.CheckExecutor(&action.Template); err != nil {
+		return err
+	}
+	waitsForHealthy := slices.ContainsFunc(action.Template.Steps, func(step data.ActionTemplateStep) bool { return step.Type == "wait_for_healthy" })
+	if (action.WaitForHealthy || waitsForHealthy) && ae.Healthchecks == nil {
+		return errors.New("waiting for instances to report healthy requires the HealthcheckService, which is disabled")
+	}
+	// Only the first of concurrent starts gets to run the executions
+	started, err := data.StartAction(ae.Database.Pool, action.Id)
+	if err != nil {
+		return err
+	}
+	if !started {
+		return ErrActionNotPending
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	ae.lock.Lock()
+	ae.running[action.Id] = cancel
+	ae.lock.Unlock()
+	ae.Logger.Info("Action started", "action_id", action.Id, "action_name", action.Name, "executions", len(action.Executions))
+	go ae.runAction(ctx, action)
+	return nil
+}
+
+// CheckExecutor checks that the executor of the template is enabled on this instance
+func (ae *ActionExecutor) CheckExecutor(template *data.ActionTemplate) error {
+	if template.Executor == "local" && !ae.LocalExecutor {
+		return errors.New("the local executor is disabled, it has to be enabled with actions.localexecutor in the configuration")
+	}
+	return nil
+}
+
+// CancelAction stops the action. Running scripts are terminated, executions which did not finish yet are marked as cancelled
+func (ae *ActionExecutor) CancelAction(action *data.ActionFull) error {
+	ae.lock.Lock()
+	cancel, found := ae.running[action.Id]
+	ae.lock.Unlock()
+	if found {
+		// The executions notice the cancellation, and the final status is stored once all of them stopped
+		ae.Logger.Info("Cancelling action", "action_id", action.Id)
+		cancel()
+		return nil
+	}
+	// The action is not being executed (not started yet, or interrupted by a restart), only the database needs updating
+	if err := data.CancelActionExecutions(ae.Database.Pool, action.Id); err != nil {
+		return err
+	}
+	return data.UpdateActionStatus(ae.Database.Pool, action.Id, "cancelled")
+}
+
+// FollowExecution streams the output of an execution while it is being executed, starting at the given byte offsets of its stdout and stderr.
+// send is called with every new piece of output, until the execution finishes or the context is done.
+// Returns false if the execution is not being executed, in which case its output is only available from the database
+func (ae *ActionExecutor) FollowExecution(ctx context.Context, executionId uint, stdoutOffset, stderrOffset int, send func(stdout, stderr string)) bool {
+	ae.lock.Lock()
+	output, found := ae.outputs[executionId]
+	ae.lock.Unlock()
+	if !found {
+		return false
+	}
+	for {
+		stdout, stderr, updated, finished := output.Since(stdoutOffset, stderrOffset)
+		if stdout != "" || stderr != "" {
+			send(stdout, stderr)
+			stdoutOffset += len(stdout)
+			stderrOffset += len(stderr)
+		}
+		if finished {
+			return true
+		}
+		select {
+		case <-ctx.Done():
+			return true
+		case <-updated:
+		}
+	}
+}
+
+// Runs the executions of the action batch by batch according to its execution strategy, and sets the final status of the action once all of them are finished
+func (ae *ActionExecutor) runAction(ctx context.Context, action *data.ActionFull) {
+	defer func() {
+		ae.lock.Lock()
+		ae.running[action.Id]()
+		delete(ae.running, action.Id)
+		ae.lock.Unlock()
+	}()
+	log := ae.Logger.With("action_id", action.Id)
+	batches := executionBatches(&action.Action, action.Executions)
+	log.Debug("Running action", "strategy", action.ExecutionStrategy, "batches", len(batches))
+
+	failed := false
+	halted := false // An instance did not report healthy, the remaining batches are always skipped
+	for i, batch := range batches {
+		if halted || (failed && action.StopOnFailure) {
+			log.Info("Skipping batch after a failed execution", "batch", i+1)
+			for _, execution := range batch {
+				ae.skipExecution(execution, log)
+			}
+			continue
+		}
+		if i > 0 && action.BatchPauseSeconds > 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Duration(action.BatchPauseSeconds) * time.Second):
+			}
+		}
+		if !ae.runBatch(ctx, action, batch) {
+			failed = true
+		} else if action.WaitForHealthy && ctx.Err() == nil && !ae.waitForHealthy(ctx, &action.Action, batch, log) {
+			failed = true
+			halted = true
+		}
+	}
+
+	status := "completed"
+	if failed {
+		status = "failed"
+	}
+	if ctx.Err() != nil {
+		status = "cancelled"
+	}
+	if err := data.UpdateActionStatus(ae.Database.Pool, action.Id, status); err != nil {
+		log.Error("Failed to update action status", "status", status, "error", err)
+	}
+	log.Info("Action finished", "status", status)
+}
+
+// Runs the executions of a batch in parallel. Returns true if all of them succeeded
+func (ae *ActionExecutor) runBatch(ctx context.Context, action *data.ActionFull, batch []data.ActionExecution) bool {
+	var wg sync.WaitGroup
+	succeeded := make([]bool, len(batch))
+	for i, execution := range batch {
+		wg.Add(1)
+		go func(i int, execution data.ActionExecution) {
+			defer wg.Done()
+			succeeded[i] = ae.runExecution(ctx, action, execution)
+		}(i, execution)
+	}
+	wg.Wait()
+	for _, ok := range succeeded {
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Waits until the instances of the batch report healthy again through their healthcheck observers.
+// Returns false if any of them did not within the timeout of the action, that execution is marked as failed
+func (ae *ActionExecutor) waitForHealthy(ctx context.Context, action *data.Action, batch []data.ActionExecution, log *slog.Logger) bool {
+	timeout := time.Duration(action.HealthyTimeoutSeconds) * time.Second
+	// Only checks which started after the scripts finished count
+	since := time.Now()
+	waitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	healthy := make([]bool, len(batch))
+	for i, execution := range batch {
+		wg.Add(1)
+		go func(i int, execution data.ActionExecution) {
+			defer wg.Done()
+			instance, err := data.GetApplicationInstanceFullById(ae.Database.Pool, uint64(execution.ApplicationInstanceId))
+			if err != nil || instance == nil {
+				ae.appendExecutionMessage(execution.Id, fmt.Sprintf("unable to get application instance to wait for it to report healthy: %v", err), true, log)
+				return
+			}
+			if instance.ApplicationDefinition.HealthcheckId == nil {
+				ae.appendExecutionMessage(execution.Id, "no healthcheck configured for the application, not waiting for the instance to report healthy", false, log)
+				healthy[i] = true
+				return
+			}
+			log.Debug("Waiting for instance to report healthy", "execution_id", execution.Id, "application_instance_id", execution.ApplicationInstanceId, "timeout", timeout)
+			err = ae.Healthchecks.WaitForHealthy(waitCtx, execution.ApplicationInstanceId, since)
+			if err == nil {
+				healthy[i] = true
+			} else if ctx.Err() == nil {
+				log.Warn("Instance did not report healthy", "execution_id", execution.Id, "application_instance_id", execution.ApplicationInstanceId, "timeout", timeout)
+				ae.appendExecutionMessage(execution.Id, fmt.Sprintf("instance did not report healthy within %s, halting the action", timeout), true, log)
+			}
+		}(i, execution)
+	}
+	wg.Wait()
+	for _, ok := range healthy {
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Appends a message to the stderr of a finished execution, optionally marking it as failed
+func (ae *ActionExecutor) appendExecutionMessage(executionId uint, message string, failed bool, log *slog.Logger) {
+	execution, err := data.GetActionExecutionById(ae.Database.Pool, executionId)
+	if err != nil || execution == nil {
+		log.Error("Failed to get execution", "execution_id", executionId, "error", err)
+		return
+	}
+	execution.ErrorOutput += "nam: " + message + "\n"
+	if failed {
+		execution.Status = "failed"
+	}
+	if err := data.UpdateActionExecution(ae.Database.Pool, execution); err != nil {
+		log.Error("Failed to update execution", "execution_id", executionId, "error", err)
+	}
+}
+
+// Marks an execution as skipped, because an execution of an earlier batch failed
+func (ae *ActionExecutor) skipExecution(execution data.ActionExecution, log *slog.Logger) {
+	execution.Status = "skipped"
+	if err := data.UpdateActionExecution(ae.Database.Pool, &execution); err != nil {
+		log.Error("Failed to mark execution as skipped", "execution_id", execution.Id, "error", err)
+	}
+}
+
+// Splits the executions, which are ordered by their position, into the batches in which they are run
+func executionBatches(action *data.Action, executions []data.ActionExecution) [][]data.ActionExecution {
+	size := len(executions)
+	switch action.ExecutionStrategy {
+	case "sequential":
+		size = 1
+	case "batch":
+		size = action.BatchSize
+		if action.BatchSizePercent {
+			// Rounded up, so every batch contains at least one execution
+			size = (len(executions)*action.BatchSize + 99) / 100
+		}
+	}
+	size = max(size, 1)
+
+	var batches [][]data.ActionExecution
+	for start := 0; start < len(executions); start += size {
+		batches = append(batches, executions[start:min(start+size, len(executions))])
+	}
+	return batches
+}
+
+// Runs a single execution, storing its status, output and exit code. Returns true if the script exited with 0
+func (ae *ActionExecutor) runExecution(ctx context.Context, action *data.ActionFull, execution data.ActionExecution) bool {
+	log := ae.Logger.With("action_id", execution.ActionId, "execution_id", execution.Id, "application_instance_id", execution.ApplicationInstanceId)
+	if ctx.Err() != nil {
+		// Cancelled before it got the chance to run
+		execution.Status = "cancelled"
+		completedAt := time.Now()
+		execution.CompletedAt = &completedAt
+		if err := data.UpdateActionExecution(ae.Database.Pool, &execution); err != nil {
+			log.Error("Failed to mark execution as cancelled", "error", err)
+		}
+		return false
+	}
+	startedAt := time.Now()
+	execution.Status = "running"
+	execution.StartedAt = &startedAt
+	if err := data.UpdateActionExecution(ae.Database.Pool, &execution); err != nil {
+		log.Error("Failed to mark execution as running", "error", err)
+	}
+
+	output := newExecutionOutput()
+	ae.lock.Lock()
+	ae.outputs[execution.Id] = output
+	ae.lock.Unlock()
+	defer func() {
+		// Followers are released only after the result is stored, so they can read the final status from the database
+		ae.lock.Lock()
+		delete(ae.outputs, execution.Id)
+		ae.lock.Unlock()
+		output.Finish()
+	}()
+	stopFlushing := ae.startOutputFlusher(execution.Id, output, log)
+	var exitCode *int
+	var err error
+	var maintenance *instanceMaintenance
+	if action.MaintenanceMode {
+		maintenance, err = ae.enterMaintenance(execution.ApplicationInstanceId, output, log)
+	}
+	if err == nil {
+		exitCode, err = ae.execute(ctx, action, &execution, maintenance, output, log)
+		// Also after a failed or cancelled script, the instance must not be left in maintenance mode
+		maintenance.leave(output)
+	}
+	stopFlushing()
+
+	if errors.Is(err, errExecutionCancelled) {
+		fmt.Fprintf(output.Stderr(), "nam: execution cancelled\n")
+	} else if err != nil {
+		log.Warn("Execution failed", "error", err)
+		fmt.Fprintf(output.Stderr(), "nam: %s\n", err.Error())
+	}
+	completedAt := time.Now()
+	execution.CompletedAt = &completedAt
+	execution.ExitCode = exitCode
+	output.Complete()
+	execution.Output, execution.ErrorOutput, _ = output.Snapshot()
+	if errors.Is(err, errExecutionCancelled) {
+		execution.Status = "cancelled"
+	} else if err == nil && (exitCode == nil || *exitCode == 0) {
+		// Pipelines without script steps have no exit code
+		execution.Status = "completed"
+	} else {
+		execution.Status = "failed"
+	}
+	if err := data.UpdateActionExecution(ae.Database.Pool, &execution); err != nil {
+		log.Error("Failed to store execution result", "error", err)
+	}
+	log.Debug("Execution finished", "status", execution.Status, "exit_code", exitCode)
+	return execution.Status == "completed"
+}
+
+// Puts the instance into maintenance mode for the duration of its execution. Returns nil if the instance already was in maintenance mode,
+// so the action doesn't end a maintenance it didn't start
+func (ae *ActionExecutor) enterMaintenance(instanceId uint, output *executionOutput, log *slog.Logger) (*instanceMaintenance, error) {
+	instance, err := data.GetApplicationInstanceById(ae.Database.Pool, uint64(instanceId))
+	if err != nil {
+		return nil, fmt.Errorf("unable to get application instance: %w", err)
+	} else if instance == nil {
+		return nil, fmt.Errorf("application instance %d not found", instanceId)
+	}
+	if instance.MaintenanceMode {
+		fmt.Fprintf(output.Stderr(), "nam: instance is already in maintenance mode, leaving it as it is\n")
+		return nil, nil
+	}
+	maintenance := &instanceMaintenance{
+		toggle: func(enabled bool) error {
+			return data.ToggleApplicationInstanceMaintenance(ae.Database.Pool, uint64(instanceId), enabled)
+		},
+		log: log,
+	}
+	if err := maintenance.enter(output); err != nil {
+		return nil, err
+	}
+	return maintenance, nil
+}
+
+// instanceMaintenance is the maintenance mode an execution put its instance into. Its methods may be called on nil, if the execution didn't
+type instanceMaintenance struct {
+	toggle func(enabled bool) error // Enables or disables maintenance mode of the instance
+	log    *slog.Logger
+	active bool // Whether the instance is in maintenance mode
+}
+
+// Puts the instance into maintenance mode
+func (m *instanceMaintenance) enter(output *executionOutput) error {
+	if err := m.toggle(true); err != nil {
+		return fmt.Errorf("unable to enable maintenance mode: %w", err)
+	}
+	m.active = true
+	fmt.Fprintf(output.Stderr(), "nam: maintenance mode enabled\n")
+	return nil
+}
+
+// Takes the instance out of maintenance mode, if it is in it
+func (m *instanceMaintenance) leave(output *executionOutput) {
+	if m == nil || !m.active {
+		return
+	}
+	if err := m.toggle(false); err != nil {
+		m.log.Error("Failed to disable maintenance mode", "error", err)
+		fmt.Fprintf(output.Stderr(), "nam: unable to disable maintenance mode: %s\n", err.Error())
+		return
+	}
+	m.active = false
+	fmt.Fprintf(output.Stderr(), "nam: maintenance mode disabled\n")
+}
+
+// Runs wait with the instance out of maintenance mode, and puts it back afterwards. The observer of an instance is removed while it is
+// in maintenance mode, so it could never report healthy otherwise
+func (m *instanceMaintenance) suspendedFor(output *executionOutput, wait func() error) error {
+	if m == nil || !m.active {
+		return wait()
+	}
+	fmt.Fprintf(output.Stderr(), "nam: leaving maintenance mode until the instance reports healthy\n")
+	m.leave(output)
+	err := wait()
+	if enterErr := m.enter(output); enterErr != nil && err == nil {
+		err = enterErr
+	}
+	return err
+}
+
+// Runs the pipeline of the template for the execution's instance: the uploads of the template, its steps and then its downloads.
+// Returns the exit code of the last script step, or an error if a step failed for another reason than its exit code, or errExecutionCancelled if the context was cancelled
+func (ae *ActionExecutor) execute(ctx context.Context, action *data.ActionFull, execution *data.ActionExecution, maintenance *instanceMaintenance,
+	output *executionOutput, log *slog.Logger) (*int, error) {
+	instance, err := data.GetApplicationInstanceById(ae.Database.Pool, uint64(execution.ApplicationInstanceId))
+	if err != nil {
+		return nil, fmt.Errorf("unable to get application instance: %w", err)
+	} else if instance == nil {
+		return nil, fmt.Errorf("application instance %d not found", execution.ApplicationInstanceId)
+	}
+	server, err := data.GetServerById(ae.Database.Pool, instance.ServerID)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get server: %w", err)
+	}
+	// Renders all steps at once, so a pipeline doesn't start if any of them lacks a variable
+	rendered, err := ae.Renderer.Render(&action.Template, execution.ApplicationInstanceId, action.Parameters, false)
+	if err != nil {
+		return nil, err
+	}
+	run := &pipelineRun{
+		action:      action,
+		execution:   execution,
+		server:      server,
+		variables:   rendered.Variables,
+		secrets:     rendered.Environment,
+		maintenance: maintenance,
+		log:         log,
+	}
+	run.backend = ae.backend(run)
+	defer run.close()
+
+	if slices.ContainsFunc(action.Template.FileTransfers, func(t data.ActionTemplateFileTransfer) bool { return t.Direction == "upload" }) {
+		client, err := run.ssh(ctx, ae, output)
+		if err != nil {
+			return nil, err
+		}
+		if err := ae.uploadFiles(ctx, client, action.Template.FileTransfers, run.variables, output); err != nil {
+			return nil, err
+		}
+	}
+
+	exitCode, err := ae.runSteps(ctx, run, output)
+	if errors.Is(err, errExecutionCancelled) {
+		return nil, err
+	}
+
+	// Also after a failed step, the files may tell why. A failed download only fails an otherwise successful execution
+	if slices.ContainsFunc(action.Template.FileTransfers, func(t data.ActionTemplateFileTransfer) bool { return t.Direction == "download" }) {
+		client, dialErr := run.ssh(ctx, ae, output)
+		if dialErr == nil {
+			dialErr = ae.downloadFiles(ctx, client, execution.Id, action.Template.FileTransfers, run.variables, output)
+		} else {
+			fmt.Fprintf(output.Stderr(), "nam: unable to download files: %s\n", dialErr.Error())
+		}
+		if dialErr != nil && err == nil && (exitCode == nil || *exitCode == 0) {
+			err = dialErr
+		}
+	}
+	return exitCode, err
+}
+
+// Uploads files to the server of the execution, the ones of the template before its steps run
+func (ae *ActionExecutor) uploadFiles(ctx context.Context, client *ssh.Client, transfers []data.ActionTemplateFileTransfer, variables map[string]string, output *executionOutput) error {
+	for _, transfer := range transfers {
+		if transfer.Direction != "upload" {
+			continue
+		}
+		if ctx.Err() != nil {
+			return errExecutionCancelled
+		}
+		remotePath, err := RenderText(transfer.RemotePath, variables)
+		if err != nil {
+			return fmt.Errorf("unable to render remote path %s: %w", transfer.RemotePath, err)
+		}
+		file, err := data.GetActionFileById(ae.Database.Pool, transfer.FileId)
+		if err != nil {
+			return fmt.Errorf("unable to get file to upload to %s: %w", remotePath, err)
+		} else if file == nil {
+			return fmt.Errorf("file %d to upload to %s not found", transfer.FileId, remotePath)
+		}
+		if err := ScpUpload(client, remotePath, transfer.Mode, file.Content); err != nil {
+			return fmt.Errorf("unable to upload %s to %s: %w", file.Name, remotePath, err)
+		}
+		fmt.Fprintf(output.Stderr(), "nam: uploaded %s to %s (%d bytes)\n", file.Name, remotePath, file.Size)
+	}
+	return nil
+}
+
+// Downloads files from the server of the execution into its artifacts, the ones of the template after its steps ran.
+// All downloads are attempted, the first error is returned
+func (ae *ActionExecutor) downloadFiles(ctx context.Context, client *ssh.Client, executionId uint, transfers []data.ActionTemplateFileTransfer, variables map[string]string, output *executionOutput) error {
+	var failed error
+	for _, transfer := range transfers {
+		if transfer.Direction != "download" {
+			continue
+		}
+		if ctx.Err() != nil {
+			return errExecutionCancelled
+		}
+		err := func() error {
+			remotePath, err := RenderText(transfer.RemotePath, variables)
+			if err != nil {
+				return fmt.Errorf("unable to render remote path %s: %w", transfer.RemotePath, err)
+			}
+			content, name, err := ScpDownload(client, remotePath)
+			if err != nil {
+				return fmt.Errorf("unable to download %s: %w", remotePath, err)
+			}
+			artifact, err := data.CreateActionExecutionArtifact(ae.Database.Pool, &data.ActionExecutionArtifact{
+				ActionExecutionId: executionId,
+				Name:              name,
+				RemotePath:        remotePath,
+				Content:           content,
+			})
+			if err != nil {
+				return fmt.Errorf("unable to store %s: %w", remotePath, err)
+			}
+			fmt.Fprintf(output.Stderr(), "nam: downloaded %s (%d bytes)\n", remotePath, artifact.Size)
+			return nil
+		}()
+		if err != nil {
+			fmt.Fprintf(output.Stderr(), "nam: %s\n", err.Error())
+			if failed == nil {
+				failed = err
+			}
+		}
+	}
+	return failed
+}
+
+// Sets the environment variables of the session before the script is started. Returns false if the server refused any of them
+func setSessionEnvironment(session *ssh.Session, environment map[string]string) bool {
+	accepted := true
+	for name, value := range environment {
+		if err := session.Setenv(name, value); err != nil {
+			accepted = false
+		}
+	}
+	return accepted
+}
+
+// Returns bash statements exporting the environment variables, with their values single quoted
+func exportEnvironment(environment map[string]string) string {
+	var exports strings.Builder
+	for name, value := range environment {
+		fmt.Fprintf(&exports, "export %s=%s\n", name, shellQuote(value))
+	}
+	return exports.String()
+}
+
+// Stops a running script. Sends SIGTERM to its process group, and SIGKILL if it is still running after the grace period
+func (ae *ActionExecutor) terminate(client *ssh.Client, session *ssh.Session, group *remoteProcessGroup, finished <-chan error, log *slog.Logger) {
+	for _, signal := range []ssh.Signal{ssh.SIGTERM, ssh.SIGKILL} {
+		log.Info("Signalling script", "signal", signal)
+		// Not every SSH server supports signals, so the process group is killed from a separate session as well
+		if err := session.Signal(signal); err != nil {
+			log.Debug("Failed to signal SSH session", "signal", signal, "error", err)
+		}
+		if pgid, found := group.Id(); found {
+			killSession, err := client.NewSession()
+			if err != nil {
+				log.Warn("Unable to open SSH session to signal the script", "signal", signal, "error", err)
+			} else {
+				if err := killSession.Run(fmt.Sprintf("kill -%s -- -%d", signal, pgid)); err != nil {
+					log.Debug("Failed to signal remote process group", "signal", signal, "pgid", pgid, "error", err)
+				}
+				killSession.Close()
+			}
+		}
+		select {
+		case <-finished:
+			return
+		case <-time.After(executionCancelGracePeriod):
+		}
+	}
+	log.Warn("Script did not exit after SIGKILL, abandoning the session")
+}
+
+// Periodically writes the output of a running execution into the database, so it can be followed while running.
+// Returns a function that stops the flusher and waits for it to finish
+func (ae *ActionExecutor) startOutputFlusher(executionId uint, output *executionOutput, log *slog.Logger) func() {
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		ticker := time.NewTicker(executionOutputFlushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				stdout, stderr, changed := output.Snapshot()
+				if !changed {
+					continue
+				}
+				if err := data.UpdateActionExecutionOutput(ae.Database.Pool, executionId, stdout, stderr); err != nil {
+					log.Error("Failed to store execution output", "error", err)
+				}
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-finished
+	}
+}
+
+// executionOutput collects the stdout and stderr of an execution. Safe for concurrent use.
+// Secret values are masked in everything read from it, the captured output itself is never exposed
+type executionOutput struct {
+	lock     sync.Mutex
+	stdout   bytes.Buffer
+	stderr   bytes.Buffer
+	changed  bool
+	updated  chan struct{} // Closed and replaced on every write, to wake up followers
+	finished bool
+	complete bool             // No more output is written, so nothing is held back by masking
+	secrets  []string         // Values to mask
+	parent   *executionOutput // Receives everything written as well, set for the output of a step
+}
+
+func newExecutionOutput() *executionOutput {
+	return &executionOutput{updated: make(chan struct{})}
+}
+
+// Step returns the output of a step of the execution, everything written to it is written to the output of the execution as well
+func (o *executionOutput) Step() *executionOutput {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	step := newExecutionOutput()
+	step.secrets = slices.Clone(o.secrets)
+	step.parent = o
+	return step
+}
+
+// Stdout returns a writer appending to the captured stdout
+func (o *executionOutput) Stdout() io.Writer {
+	if o.parent != nil {
+		return io.MultiWriter(outputWriter{output: o, buffer: &o.stdout}, o.parent.Stdout())
+	}
+	return outputWriter{output: o, buffer: &o.stdout}
+}
+
+// Stderr returns a writer appending to the captured stderr
+func (o *executionOutput) Stderr() io.Writer {
+	if o.parent != nil {
+		return io.MultiWriter(outputWriter{output: o, buffer: &o.stderr}, o.parent.Stderr())
+	}
+	return outputWriter{output: o, buffer: &o.stderr}
+}
+
+// Mask sets the secret values which are replaced by secretMask in the output, and in the output of the execution for a step. Empty values are ignored
+func (o *executionOutput) Mask(secrets []string) {
+	o.lock.Lock()
+	for _, secret := range secrets {
+		if secret != "" {
+			o.secrets = append(o.secrets, secret)
+		}
+	}
+	o.lock.Unlock()
+	if o.parent != nil {
+		o.parent.Mask(secrets)
+	}
+}
+
+// Since returns the masked output after the given byte offsets, a channel which is closed on the next write, and whether the execution finished
+func (o *executionOutput) Since(stdoutOffset, stderrOffset int) (string, string, <-chan struct{}, bool) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	stdout, stderr := o.masked(&o.stdout), o.masked(&o.stderr)
+	return stdout[min(stdoutOffset, len(stdout)):], stderr[min(stderrOffset, len(stderr)):], o.updated, o.finished
+}
+
+// Complete marks that no more output is written
+func (o *executionOutput) Complete() {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.complete = true
+}
+
+// Finish marks the output as complete and wakes up all followers
+func (o *executionOutput) Finish() {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.complete = true
+	o.finished = true
+	close(o.updated)
+	o.updated = make(chan struct{})
+}
+
+// Snapshot returns the masked output captured so far, and whether it changed since the last snapshot
+func (o *executionOutput) Snapshot() (string, string, bool) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	changed := o.changed
+	o.changed = false
+	return o.masked(&o.stdout), o.masked(&o.stderr), changed
+}
+
+// Returns the buffer with all secret values masked. Until the output is complete, a trailing part which could be the start of a secret is held back,
+// so a secret split across writes is never exposed. Must be called with the lock held
+func (o *executionOutput) masked(buffer *bytes.Buffer) string {
+	captured := buffer.Bytes()
+	if !o.complete {
+		held := 0
+		for _, secret := range o.secrets {
+			for n := min(len(secret)-1, len(captured)); n > held; n-- {
+				if bytes.HasSuffix(captured, []byte(secret[:n])) {
+					held = n
+					break
+				}
+			}
+		}
+		captured = captured[:len(captured)-held]
+	}
+	output := string(captured)
+	for _, secret := range o.secrets {
+		output = strings.ReplaceAll(output, secret, secretMask)
+	}
+	return output
+}
+
+type outputWriter struct {
+	output *executionOutput
+	buffer *bytes.Buffer
+}
+
+func (w outputWriter) Write(p []byte) (int, error) {
+	w.output.lock.Lock()
+	defer w.output.lock.Unlock()
+	w.output.changed = true
+	close(w.output.updated)
+	w.output.updated = make(chan struct{})
+	return w.buffer.Write(p)
+}
+
+// remoteProcessGroup captures the process group ID of the remote script, which is printed as the first line of stdout.
+// The rest of stdout is passed through
+type remoteProcessGroup struct {
+	lock   sync.Mutex
+	id     int
+	parsed bool   // Whether the first line was already received
+	header []byte // First line, until it is complete
+}
+
+// Writer returns a writer which strips the process group ID from the stream and passes the rest to out
+func (g *remoteProcessGroup) Writer(out io.Writer) io.Writer {
+	return processGroupWriter{group: g, out: out}
+}
+
+// Id returns the process group ID, if it was already received
+func (g *remoteProcessGroup) Id() (int, bool) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	return g.id, g.id > 0
+}
+
+type processGroupWriter struct {
+	group *remoteProcessGroup
+	out   io.Writer
+}
+
+func (w processGroupWriter) Write(p []byte) (int, error) {
+	g := w.group
+	g.lock.Lock()
+	if g.parsed {
+		g.lock.Unlock()
+		return w.out.Write(p)
+	}
+	g.header = append(g.header, p...)
+	newline := bytes.IndexByte(g.header, '\n')
+	if newline < 0 {
+		g.lock.Unlock()
+		return len(p), nil
+	}
+	g.parsed = true
+	rest := g.header[newline+1:]
+	if id, err := strconv.Atoi(strings.TrimSpace(string(g.header[:newline]))); err == nil {
+		g.id = id
+	} else {
+		// Something else than the process group ID was printed first, pass everything through
+		rest = g.header
+	}
+	g.header = nil
+	g.lock.Unlock()
+	if _, err := w.out.Write(rest); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,126 @@
+package services
+
+import (
+	"context"
+	"kukus/nam/v2/layers/data"
+	"log/slog"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestExecutionOutputMask(t *testing.T) {
+	tests := []struct {
+		name     string
+		secrets  []string
+		writes   []string
+		complete bool
+		want     string
+	}{
+		{"no secrets", nil, []string{"hello"}, false, "hello"},
+		{"empty secret is ignored", []string{""}, []string{"hello"}, false, "hello"},
+		{"in one write", []string{"s3cr3t"}, []string{"token=s3cr3t\n"}, false, "token=********\n"},
+		{"every occurrence", []string{"s3cr3t"}, []string{"s3cr3t s3cr3t"}, true, "******** ********"},
+		{"several secrets", []string{"alpha", "beta"}, []string{"alpha beta gamma"}, true, "******** ******** gamma"},
+		{"split across writes", []string{"s3cr3t"}, []string{"token=s3", "cr3t\n"}, false, "token=********\n"},
+		{"split into single bytes", []string{"abc"}, []string{"x", "a", "b", "c", "y"}, false, "x********y"},
+		{"possible start is held back", []string{"s3cr3t"}, []string{"token=s3cr"}, false, "token="},
+		{"longest possible start is held back", []string{"aab"}, []string{"xaa"}, false, "x"},
+		{"held back part is released once complete", []string{"s3cr3t"}, []string{"token=s3cr"}, true, "token=s3cr"},
+		{"not a start", []string{"s3cr3t"}, []string{"token=s4"}, false, "token=s4"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output := newExecutionOutput()
+			output.Mask(tt.secrets)
+			for _, write := range tt.writes {
+				output.Stdout().Write([]byte(write))
+			}
+			if tt.complete {
+				output.Complete()
+			}
+			stdout, stderr, changed := output.Snapshot()
+			if stdout != tt.want || stderr != "" {
+				t.Errorf("stdout = %q, stderr = %q, want %q", stdout, stderr, tt.want)
+			}
+			if !changed && len(tt.writes) > 0 {
+				t.Error("snapshot is not marked as changed")
+			}
+		})
+	}
+}
+
+// Followers read the masked output from the offset they already received
+func TestExecutionOutputSince(t *testing.T) {
+	output := newExecutionOutput()
+	output.Mask([]string{"s3cr3t"})
+	output.Stderr().Write([]byte("s3cr3t s3"))
+	stdout, stderr, _, finished := output.Since(0, 0)
+	if stdout != "" || stderr != "******** " || finished {
+		t.Fatalf("stdout = %q, stderr = %q, finished = %v", stdout, stderr, finished)
+	}
+	output.Stderr().Write([]byte("cr3t\n"))
+	output.Finish()
+	if _, stderr, _, finished := output.Since(0, len(stderr)); stderr != "********\n" || !finished {
+		t.Errorf("stderr = %q, finished = %v", stderr, finished)
+	}
+}
+
+// Secrets given to a step are masked in the output of the execution as well, and the masked output is what followers read
+func TestExecutionOutputStepMask(t *testing.T) {
+	output := newExecutionOutput()
+	output.Mask([]string{"first"})
+	step := output.Step()
+	step.Mask([]string{"second"})
+	step.Stderr().Write([]byte("first second\n"))
+	output.Stderr().Write([]byte("done"))
+
+	if _, stderr, _ := step.Snapshot(); stderr != "******** ********\n" {
+		t.Errorf("step stderr = %q", stderr)
+	}
+	if _, stderr, _ := output.Snapshot(); stderr != "******** ********\ndone" {
+		t.Errorf("execution stderr = %q", stderr)
+	}
+	if _, stderr, _, _ := output.Since(0, len("******** ")); stderr != "********\ndone" {
+		t.Errorf("stderr since offset = %q", stderr)
+	}
+}
+
+// Observers are removed while their instance is in maintenance mode, so the instance is taken out of it while waiting for it to report healthy
+func TestWaitForHealthyInMaintenance(t *testing.T) {
+	healthchecks := &HealthcheckService{Observers: make(map[uint]*HealthcheckObserver)}
+	var toggled []bool
+	// Like the listener of the HealthcheckService, which starts an observer once the instance leaves maintenance mode
+	toggle := func(enabled bool) error {
+		toggled = append(toggled, enabled)
+		healthchecks.observersLock.Lock()
+		defer healthchecks.observersLock.Unlock()
+		if enabled {
+			delete(healthchecks.Observers, 1)
+		} else {
+			healthchecks.Observers[1] = &HealthcheckObserver{lastResult: &data.HealthcheckResult{IsSuccessful: true, TimeStart: time.Now().Add(time.Second)}}
+		}
+		return nil
+	}
+	output := newExecutionOutput()
+	maintenance := &instanceMaintenance{toggle: toggle, log: slog.Default()}
+	if err := maintenance.enter(output); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := maintenance.suspendedFor(output, func() error { return healthchecks.WaitForHealthy(ctx, 1, time.Now()) })
+	if err != nil {
+		t.Fatalf("instance did not report healthy: %v", err)
+	}
+	if !slices.Equal(toggled, []bool{true, false, true}) || !maintenance.active {
+		t.Errorf("maintenance mode was toggled %v, want it back in maintenance mode", toggled)
+	}
+
+	maintenance.leave(output)
+	var none *instanceMaintenance
+	if err := none.suspendedFor(output, func() error { return nil }); err != nil || !slices.Equal(toggled, []bool{true, false, true, false}) {
+		t.Errorf("err = %v, toggled %v", err, toggled)
+	}
+}
@@ -0,0 +1,448 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"kukus/nam/v2/layers/data"
+	"log/slog"
+	"maps"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// This file provides the steps of the pipelines, which the executor runs for every execution.
+
+// Only the start of the response of an http step is kept in its output
+const maxHttpStepResponseSize = 64 << 10
+
+// Timeout of http steps without one of their own, so an endpoint which never answers doesn't hold the execution and its slot
+const defaultHttpStepTimeout = 30 * time.Second
+
+// Returns the context for a request NAM sends itself. Unless the step has a timeout of its own, the request times out after the default one
+func requestContext(ctx context.Context, defaultTimeout time.Duration) (context.Context, context.CancelFunc) {
+	if _, found := ctx.Deadline(); found {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, defaultTimeout)
+}
+
+// Returns the error of a request which failed, telling if it was because of the default timeout of requestContext
+func requestError(ctx, requestCtx context.Context, defaultTimeout time.Duration, err error) error {
+	if ctx.Err() == nil && errors.Is(requestCtx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("no response within %s", defaultTimeout)
+	}
+	return err
+}
+
+// pipelineRun holds what the steps of an execution share
+type pipelineRun struct {
+	action    *data.ActionFull
+	execution *data.ActionExecution
+	server    *data.Server
+	variables map[string]string // Of the instance, including the parameters. Used to render the settings of the steps
+	secrets   map[string]string // Values of the secrets referenced by the pipeline, keyed by the environment variable secret parameters refer to
+	backend   scriptBackend     // Runs the script steps, selected by the executor of the template
+	lease     *SshLease         // On the shared connection to the server, taken when the first step needs it
+	// The maintenance mode the execution put the instance into, nil if it didn't. Suspended while waiting for the instance to report healthy
+	maintenance *instanceMaintenance
+	log         *slog.Logger
+}
+
+// Returns the SSH client connected to the server of the execution. On first use, waits for a free slot on the server's shared connection
+func (run *pipelineRun) ssh(ctx context.Context, ae *ActionExecutor, output *executionOutput) (*ssh.Client, error) {
+	if run.lease == nil {
+		lease, err := ae.Ssh.Acquire(ctx, run.server, func(reason string) {
+			run.log.Info("Waiting for a free SSH slot", "reason", reason)
+			fmt.Fprintf(output.Stderr(), "nam: waiting for a free SSH slot, %s\n", reason)
+		})
+		if ctx.Err() != nil {
+			return nil, errExecutionCancelled
+		} else if err != nil {
+			return nil, err
+		}
+		run.lease = lease
+	}
+	return run.lease.Client, nil
+}
+
+// Releases the SSH slot of the execution, the next step needing the server waits for one again
+func (run *pipelineRun) close() {
+	if run.lease != nil {
+		run.lease.Release()
+		run.lease = nil
+	}
+}
+
+// Renders a setting of a step which NAM uses itself, like the URL of a request, for the instance. Secrets are resolved, with their values masked in the output
+func (run *pipelineRun) render(ae *ActionExecutor, name, text string, output *executionOutput) (string, error) {
+	value, secrets, err := ae.Renderer.RenderValue(text, run.variables)
+	output.Mask(secrets)
+	if err != nil {
+		return "", fmt.Errorf("unable to render %s: %w", name, err)
+	}
+	// Secret parameters render as a reference to an environment variable, which only exists for scripts
+	for variable, secret := range run.secrets {
+		reference := `"${` + variable + `}"`
+		if strings.Contains(value, reference) {
+			output.Mask([]string{secret})
+			value = strings.ReplaceAll(value, reference, secret)
+		}
+	}
+	return value, nil
+}
+
+// Whether the template has steps, rather than its bash script run as a single step. Only then the steps are announced in the output
+func (run *pipelineRun) pipeline() bool {
+	return len(run.action.Template.Steps) > 0
+}
+
+// stepConfirmation is the decision of a user on a confirmation step
+type stepConfirmation struct {
+	Confirmed bool
+	Username  string
+}
+
+// ConfirmStep passes the decision of a user to a confirmation step which is waiting for it. Returns false if the step is not waiting
+func (ae *ActionExecutor) ConfirmStep(stepId uint, confirmed bool, username string) bool {
+	ae.lock.Lock()
+	decision, found := ae.confirmations[stepId]
+	delete(ae.confirmations, stepId)
+	ae.lock.Unlock()
+	if !found {
+		return false
+	}
+	decision <- stepConfirmation{Confirmed: confirmed, Username: username}
+	return true
+}
+
+// Runs the steps of the pipeline in order, storing the status and output of each.
+// Returns the exit code of the last script step whose failure is not ignored, and the first failure of a step if the exit code doesn't tell, or errExecutionCancelled
+func (ae *ActionExecutor) runSteps(ctx context.Context, run *pipelineRun, output *executionOutput) (*int, error) {
+	pipeline := run.action.Template.Pipeline()
+	// A previous run of the execution may have left its steps
+	if err := data.DeleteActionExecutionSteps(ae.Database.Pool, run.execution.Id); err != nil {
+		return nil, fmt.Errorf("unable to delete previous steps: %w", err)
+	}
+	records := make([]*data.ActionExecutionStep, len(pipeline))
+	for i, step := range pipeline {
+		record, err := data.CreateActionExecutionStep(ae.Database.Pool, &data.ActionExecutionStep{
+			ActionExecutionId: run.execution.Id,
+			Position:          i,
+			Name:              step.Name,
+			Type:              step.Type,
+			Status:            "pending",
+		})
+		if err != nil {
+			return nil, fmt.Errorf("unable to create step %s: %w", step.Name, err)
+		}
+		records[i] = record
+	}
+
+	var exitCode *int
+	var failure error
+	aborted := false
+	for i, step := range pipeline {
+		record := records[i]
+		if ctx.Err() != nil {
+			ae.finishSteps(records[i:], "cancelled", run.log)
+			return nil, errExecutionCancelled
+		}
+		if aborted && !step.AlwaysRun {
+			ae.finishSteps(records[i:i+1], "skipped", run.log)
+			continue
+		}
+		if run.pipeline() {
+			fmt.Fprintf(output.Stderr(), "nam: step %d/%d: %s\n", i+1, len(pipeline), step.Name)
+		}
+		if step.Type == "confirmation" || step.Type == "wait_for_healthy" {
+			// These may wait for long without using the server, its SSH slot is left to other executions meanwhile
+			run.close()
+		}
+		code, err := ae.runStep(ctx, run, &step, record, output)
+		if errors.Is(err, errExecutionCancelled) {
+			ae.finishSteps(records[i+1:], "cancelled", run.log)
+			return nil, err
+		}
+		if err != nil && step.OnFailure == "ignore" {
+			continue
+		}
+		if code != nil {
+			exitCode = code
+		}
+		if err != nil {
+			if !run.pipeline() {
+				failure = err
+			} else if failure == nil {
+				failure = fmt.Errorf("step %s failed: %w", step.Name, err)
+			}
+			if step.OnFailure == "abort" {
+				aborted = true
+			}
+		}
+	}
+	if failure != nil && (exitCode == nil || *exitCode == 0) {
+		return exitCode, failure
+	}
+	return exitCode, nil
+}
+
+// Marks steps which don't run as skipped or cancelled
+func (ae *ActionExecutor) finishSteps(records []*data.ActionExecutionStep, status string, log *slog.Logger) {
+	for _, record := range records {
+		record.Status = status
+		if err := data.UpdateActionExecutionStep(ae.Database.Pool, record); err != nil {
+			log.Error("Failed to update step", "step_id", record.Id, "status", status, "error", err)
+		}
+	}
+}
+
+// Runs a step including its retries, storing its status and output. Returns the exit code of a script step, and the error of the last attempt
+func (ae *ActionExecutor) runStep(ctx context.Context, run *pipelineRun, step *data.ActionTemplateStep, record *data.ActionExecutionStep, output *executionOutput) (*int, error) {
+	stepOutput := output.Step()
+	startedAt := time.Now()
+	record.Status = "running"
+	record.StartedAt = &startedAt
+	ae.updateStep(record, stepOutput, run.log)
+
+	var exitCode *int
+	var err error
+	for attempt := 1; attempt <= step.Retries+1; attempt++ {
+		if attempt > 1 {
+			delay := time.Duration(step.RetryDelaySeconds) * time.Second
+			fmt.Fprintf(stepOutput.Stderr(), "nam: attempt %d of %d failed: %s, retrying in %s\n", attempt-1, step.Retries+1, err.Error(), delay)
+			select {
+			case <-ctx.Done():
+			case <-time.After(delay):
+			}
+			if ctx.Err() != nil {
+				exitCode, err = nil, errExecutionCancelled
+				break
+			}
+		}
+		record.Attempts = attempt
+		exitCode, err = ae.attemptStep(ctx, run, step, record, stepOutput)
+		if err == nil || errors.Is(err, errExecutionCancelled) {
+			break
+		}
+	}
+
+	completedAt := time.Now()
+	record.CompletedAt = &completedAt
+	record.ExitCode = exitCode
+	switch {
+	case errors.Is(err, errExecutionCancelled):
+		record.Status = "cancelled"
+	case err != nil:
+		record.Status = "failed"
+		if run.pipeline() {
+			fmt.Fprintf(stepOutput.Stderr(), "nam: step %s failed: %s\n", step.Name, err.Error())
+		}
+	default:
+		record.Status = "completed"
+	}
+	stepOutput.Complete()
+	ae.updateStep(record, stepOutput, run.log)
+	return exitCode, err
+}
+
+// Stores the status and output of a step
+func (ae *ActionExecutor) updateStep(record *data.ActionExecutionStep, output *executionOutput, log *slog.Logger) {
+	record.Output, record.ErrorOutput, _ = output.Snapshot()
+	if err := data.UpdateActionExecutionStep(ae.Database.Pool, record); err != nil {
+		log.Error("Failed to update step", "step_id", record.Id, "error", err)
+	}
+}
+
+// Runs a single attempt of a step within its timeout. A script step exiting with another code than 0 fails
+func (ae *ActionExecutor) attemptStep(ctx context.Context, run *pipelineRun, step *data.ActionTemplateStep, record *data.ActionExecutionStep, output *executionOutput) (*int, error) {
+	stepCtx := ctx
+	timeout := time.Duration(step.TimeoutSeconds) * time.Second
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		stepCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	var exitCode *int
+	var err error
+	switch step.Type {
+	case "script":
+		exitCode, err = ae.runScriptStep(stepCtx, run, step.Script, output)
+	case "file_copy":
+		err = ae.runFileCopyStep(stepCtx, run, step.FileTransfer, output)
+	case "wait_for_healthy":
+		err = ae.runWaitForHealthyStep(stepCtx, run, output)
+	case "http":
+		err = ae.runHttpStep(stepCtx, run, step.Http, output)
+	case "maintenance":
+		err = ae.runMaintenanceStep(run, step.Maintenance, output)
+	case "confirmation":
+		err = ae.runConfirmationStep(stepCtx, run, step.Message, record, output)
+	default:
+		err = fmt.Errorf("unknown step type %s", step.Type)
+	}
+
+	if ctx.Err() != nil {
+		return nil, errExecutionCancelled
+	}
+	if stepCtx.Err() != nil {
+		return nil, fmt.Errorf("timed out after %s", timeout)
+	}
+	if err == nil && exitCode != nil && *exitCode != 0 {
+		err = fmt.Errorf("exit code %d", *exitCode)
+	}
+	return exitCode, err
+}
+
+// Renders the script for the execution's instance and runs it with the backend of the template. Returns its exit code,
+// or an error if the script could not be run at all, or errExecutionCancelled if the context was done first
+func (ae *ActionExecutor) runScriptStep(ctx context.Context, run *pipelineRun, script string, output *executionOutput) (*int, error) {
+	rendered, err := ae.Renderer.RenderScript(&run.action.Template, script, run.execution.ApplicationInstanceId, run.action.Parameters, false)
+	if err != nil {
+		return nil, err
+	}
+	secrets := make([]string, 0, len(rendered.Environment))
+	for _, value := range rendered.Environment {
+		secrets = append(secrets, value)
+	}
+	output.Mask(secrets)
+
+	environment := maps.Clone(rendered.Environment)
+	maps.Copy(environment, rendered.Parameters)
+	return run.backend.Run(ctx, rendered.Script, environment, output)
+}
+
+// Copies a file to or from the server of the execution
+func (ae *ActionExecutor) runFileCopyStep(ctx context.Context, run *pipelineRun, transfer *data.ActionTemplateFileTransfer, output *executionOutput) error {
+	client, err := run.ssh(ctx, ae, output)
+	if err != nil {
+		return err
+	}
+	transfers := []data.ActionTemplateFileTransfer{*transfer}
+	if transfer.Direction == "upload" {
+		return ae.uploadFiles(ctx, client, transfers, run.variables, output)
+	}
+	return ae.downloadFiles(ctx, client, run.execution.Id, transfers, run.variables, output)
+}
+
+// Waits until the instance of the execution reports healthy through its healthcheck observer. Only checks which started after the step count
+func (ae *ActionExecutor) runWaitForHealthyStep(ctx context.Context, run *pipelineRun, output *executionOutput) error {
+	instanceId := run.execution.ApplicationInstanceId
+	instance, err := data.GetApplicationInstanceFullById(ae.Database.Pool, uint64(instanceId))
+	if err != nil {
+		return fmt.Errorf("unable to get application instance: %w", err)
+	} else if instance == nil {
+		return fmt.Errorf("application instance %d not found", instanceId)
+	}
+	if instance.ApplicationDefinition.HealthcheckId == nil {
+		fmt.Fprintf(output.Stderr(), "nam: no healthcheck configured for the application, not waiting for the instance to report healthy\n")
+		return nil
+	}
+	err = run.maintenance.suspendedFor(output, func() error {
+		fmt.Fprintf(output.Stderr(), "nam: waiting for the instance to report healthy\n")
+		return ae.Healthchecks.WaitForHealthy(ctx, instanceId, time.Now())
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(output.Stderr(), "nam: instance reported healthy\n")
+	return nil
+}
+
+// Sends the request of the step from NAM. Its URL, headers and body are rendered for the instance, with the values of secrets masked in the output
+func (ae *ActionExecutor) runHttpStep(ctx context.Context, run *pipelineRun, request *data.ActionTemplateHttpRequest, output *executionOutput) error {
+	url, err := run.render(ae, "URL", request.Url, output)
+	if err != nil {
+		return err
+	}
+	body, err := run.render(ae, "body", request.Body, output)
+	if err != nil {
+		return err
+	}
+	requestCtx, cancel := requestContext(ctx, defaultHttpStepTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(requestCtx, request.Method, url, strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for name, value := range request.Headers {
+		if value, err = run.render(ae, "header "+name, value, output); err != nil {
+			return err
+		}
+		req.Header.Set(name, value)
+	}
+
+	fmt.Fprintf(output.Stderr(), "nam: %s %s\n", request.Method, url)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return requestError(ctx, requestCtx, defaultHttpStepTimeout, err)
+	}
+	defer resp.Body.Close()
+	content, err := io.ReadAll(io.LimitReader(resp.Body, maxHttpStepResponseSize))
+	if err != nil {
+		return fmt.Errorf("unable to read response: %w", requestError(ctx, requestCtx, defaultHttpStepTimeout, err))
+	}
+	if len(content) > 0 {
+		output.Stdout().Write(content)
+		if content[len(content)-1] != '\n' {
+			output.Stdout().Write([]byte("\n"))
+		}
+	}
+	fmt.Fprintf(output.Stderr(), "nam: HTTP %s\n", resp.Status)
+
+	if request.ExpectedStatus != 0 && resp.StatusCode != request.ExpectedStatus {
+		return fmt.Errorf("unexpected status %s, expected %d", resp.Status, request.ExpectedStatus)
+	} else if request.ExpectedStatus == 0 && (resp.StatusCode < 200 || resp.StatusCode > 299) {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// Enables or disables maintenance mode of the instance of the execution
+func (ae *ActionExecutor) runMaintenanceStep(run *pipelineRun, maintenance string, output *executionOutput) error {
+	if err := data.ToggleApplicationInstanceMaintenance(ae.Database.Pool, uint64(run.execution.ApplicationInstanceId), maintenance == "enable"); err != nil {
+		return fmt.Errorf("unable to %s maintenance mode: %w", maintenance, err)
+	}
+	fmt.Fprintf(output.Stderr(), "nam: maintenance mode %sd\n", maintenance)
+	return nil
+}
+
+// Waits until a user confirms or rejects the step through ConfirmStep. The step is marked as waiting meanwhile
+func (ae *ActionExecutor) runConfirmationStep(ctx context.Context, run *pipelineRun, message string, record *data.ActionExecutionStep, output *executionOutput) error {
+	message, err := RenderText(message, run.variables)
+	if err != nil {
+		return fmt.Errorf("unable to render message: %w", err)
+	}
+	decision := make(chan stepConfirmation, 1)
+	ae.lock.Lock()
+	ae.confirmations[record.Id] = decision
+	ae.lock.Unlock()
+	defer func() {
+		ae.lock.Lock()
+		delete(ae.confirmations, record.Id)
+		ae.lock.Unlock()
+	}()
+
+	fmt.Fprintf(output.Stderr(), "nam: waiting for confirmation: %s\n", message)
+	record.Status = "waiting"
+	ae.updateStep(record, output, run.log)
+	defer func() {
+		record.Status = "running"
+	}()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case confirmation := <-decision:
+		if !confirmation.Confirmed {
+			return fmt.Errorf("rejected by %s", confirmation.Username)
+		}
+		fmt.Fprintf(output.Stderr(), "nam: confirmed by %s\n", confirmation.Username)
+		return nil
+	}
+}
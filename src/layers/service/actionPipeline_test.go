@@ -0,0 +1,52 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Requests of steps without a timeout of their own don't wait forever for an endpoint which never answers
+func TestRequestContext(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+	send := func(ctx context.Context, defaultTimeout time.Duration) error {
+		requestCtx, cancel := requestContext(ctx, defaultTimeout)
+		defer cancel()
+		req, err := http.NewRequestWithContext(requestCtx, http.MethodGet, server.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err == nil {
+			resp.Body.Close()
+			t.Fatal("expected the request to time out")
+		}
+		return requestError(ctx, requestCtx, defaultTimeout, err)
+	}
+
+	t.Run("default timeout", func(t *testing.T) {
+		err := send(context.Background(), 50*time.Millisecond)
+		if err.Error() != "no response within 50ms" {
+			t.Errorf("err = %v", err)
+		}
+	})
+
+	t.Run("timeout of the step", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		err := send(ctx, time.Hour)
+		if !strings.Contains(err.Error(), "context deadline exceeded") {
+			t.Errorf("err = %v, want the error of the step's context", err)
+		}
+		if waited := time.Since(start); waited > 5*time.Second {
+			t.Errorf("waited %s", waited)
+		}
+	})
+}
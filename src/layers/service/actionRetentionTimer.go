@@ -0,0 +1,155 @@
+package services
+
+import (
+	"kukus/nam/v2/layers/data"
+	"log/slog"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// This file provides a specific implementation of a TimerJob for the retention of the history of actions.
+
+const (
+	DefaultActionRetentionDays = 365
+	DefaultOutputRetentionDays = 30
+	// Bytes kept of the output of executions older than the output retention, the end tells how they finished
+	retainedOutputSize = 64 << 10
+)
+
+// ActionRetention configures how long the history of actions is kept
+type ActionRetention struct {
+	Enabled    bool
+	ActionDays int // Finished actions are deleted along with their executions after this many days
+	OutputDays int // The output of executions and their steps is trimmed to its end after this many days
+}
+
+type ActionRetentionTimer struct {
+	Logger      *slog.Logger
+	DbPool      *pgxpool.Pool
+	Name        string
+	Description string
+	Enabled     atomic.Bool // Read by the timer's goroutine, toggled from the settings page
+	Interval    time.Duration
+	Timer       *time.Timer
+	ActionDays  int
+	OutputDays  int
+	stop        chan struct{} // Closed by Stop, so the goroutine started by Start exits
+}
+
+func NewActionRetentionTimer(interval time.Duration, logger *slog.Logger, dbPool *pgxpool.Pool, retention ActionRetention) *ActionRetentionTimer {
+	if retention.ActionDays <= 0 {
+		retention.ActionDays = DefaultActionRetentionDays
+	}
+	if retention.OutputDays <= 0 {
+		retention.OutputDays = DefaultOutputRetentionDays
+	}
+	timer := &ActionRetentionTimer{
+		Logger:      logger.With("timer", "ActionRetentionTimer"),
+		DbPool:      dbPool,
+		Name:        "Action Retention Timer",
+		Description: "Deletes finished actions with their executions after " + pluralDays(retention.ActionDays) + " and trims the output of executions to its last 64 KiB after " + pluralDays(retention.OutputDays) + ".",
+		Interval:    interval,
+		ActionDays:  retention.ActionDays,
+		OutputDays:  retention.OutputDays,
+	}
+	timer.Enabled.Store(retention.Enabled)
+	return timer
+}
+
+// Start begins the execution of the timer job.
+func (t *ActionRetentionTimer) Start() {
+	timer := time.NewTimer(t.Interval)
+	stop := make(chan struct{})
+	t.Timer = timer
+	t.stop = stop
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			case <-timer.C:
+			}
+			if t.Enabled.Load() {
+				t.Run()
+			}
+			timer.Reset(t.Interval)
+		}
+	}()
+}
+
+// Stop halts the execution of the timer job.
+func (t *ActionRetentionTimer) Stop() {
+	if t.Timer != nil {
+		t.Logger.Info("Stopping timer job")
+		t.Timer.Stop()
+	}
+	if t.stop != nil {
+		close(t.stop)
+		t.stop = nil
+	}
+}
+
+// Run executes the timer job's task.
+func (t *ActionRetentionTimer) Run() {
+	actionsBefore, outputsBefore := t.cutoffs(time.Now())
+	deleted, err := data.DeleteActionsFinishedBefore(t.DbPool, actionsBefore)
+	if err != nil {
+		t.Logger.Error("Deleting old actions failed", "error", err)
+	} else {
+		t.Logger.Info("Deleted old actions", "actions", deleted, "days", t.ActionDays)
+	}
+	trimmed, err := data.TrimActionOutputsFinishedBefore(t.DbPool, outputsBefore, retainedOutputSize)
+	if err != nil {
+		t.Logger.Error("Trimming output of old executions failed", "error", err)
+	} else {
+		t.Logger.Info("Trimmed output of old executions", "executions_and_steps", trimmed, "days", t.OutputDays)
+	}
+}
+
+// Returns the times before which finished actions are deleted and the output of executions is trimmed. Days are calendar days,
+// so across a change of daylight saving time they are an hour shorter or longer
+func (t *ActionRetentionTimer) cutoffs(now time.Time) (actionsBefore, outputsBefore time.Time) {
+	return now.AddDate(0, 0, -t.ActionDays), now.AddDate(0, 0, -t.OutputDays)
+}
+
+// Enable activates the timer job, allowing it to run at its scheduled intervals.
+func (t *ActionRetentionTimer) Enable() {
+	t.Enabled.Store(true)
+	t.Logger.Info("Enabled timer job")
+}
+
+// Disable deactivates the timer job, preventing it from running until re-enabled.
+func (t *ActionRetentionTimer) Disable() {
+	t.Enabled.Store(false)
+	t.Logger.Info("Disabled timer job")
+}
+
+// IsEnabled checks whether the timer job is currently enabled.
+func (t *ActionRetentionTimer) IsEnabled() bool {
+	return t.Enabled.Load()
+}
+
+// GetName returns the name of the timer job.
+func (t *ActionRetentionTimer) GetName() string {
+	return t.Name
+}
+
+// GetDescription returns a brief description of the timer job's purpose.
+func (t *ActionRetentionTimer) GetDescription() string {
+	return t.Description
+}
+
+// GetInterval returns the interval at which the timer job is scheduled to run.
+func (t *ActionRetentionTimer) GetInterval() time.Duration {
+	return t.Interval
+}
+
+func pluralDays(days int) string {
+	if days == 1 {
+		return "1 day"
+	}
+	return strconv.Itoa(days) + " days"
+}
@@ -0,0 +1,60 @@
+package services
+
+import (
+	"log/slog"
+	"testing"
+	"time"
+)
+
+func TestActionRetentionTimerCutoffs(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name        string
+		retention   ActionRetention
+		now         time.Time
+		wantActions time.Time
+		wantOutputs time.Time
+	}{
+		{"configured days", ActionRetention{ActionDays: 90, OutputDays: 7},
+			time.Date(2026, 5, 10, 3, 0, 0, 0, time.UTC), time.Date(2026, 2, 9, 3, 0, 0, 0, time.UTC), time.Date(2026, 5, 3, 3, 0, 0, 0, time.UTC)},
+		{"defaults", ActionRetention{},
+			time.Date(2026, 5, 10, 3, 0, 0, 0, time.UTC), time.Date(2025, 5, 10, 3, 0, 0, 0, time.UTC), time.Date(2026, 4, 10, 3, 0, 0, 0, time.UTC)},
+		{"negative days get defaults", ActionRetention{ActionDays: -1, OutputDays: -1},
+			time.Date(2026, 5, 10, 3, 0, 0, 0, time.UTC), time.Date(2025, 5, 10, 3, 0, 0, 0, time.UTC), time.Date(2026, 4, 10, 3, 0, 0, 0, time.UTC)},
+		{"from a leap day", ActionRetention{ActionDays: 365, OutputDays: 1},
+			time.Date(2028, 2, 29, 12, 0, 0, 0, time.UTC), time.Date(2027, 3, 1, 12, 0, 0, 0, time.UTC), time.Date(2028, 2, 28, 12, 0, 0, 0, time.UTC)},
+		// Berlin springs forward on 2026-03-29, so that day is 23 hours long
+		{"days keep the wall clock across daylight saving time", ActionRetention{ActionDays: 1, OutputDays: 2},
+			time.Date(2026, 3, 30, 12, 0, 0, 0, berlin), time.Date(2026, 3, 29, 12, 0, 0, 0, berlin), time.Date(2026, 3, 28, 12, 0, 0, 0, berlin)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			timer := NewActionRetentionTimer(24*time.Hour, slog.Default(), nil, tt.retention)
+			actions, outputs := timer.cutoffs(tt.now)
+			if !actions.Equal(tt.wantActions) {
+				t.Errorf("actions before %s, want %s", actions, tt.wantActions)
+			}
+			if !outputs.Equal(tt.wantOutputs) {
+				t.Errorf("outputs before %s, want %s", outputs, tt.wantOutputs)
+			}
+		})
+	}
+}
+
+func TestActionRetentionTimerEnabled(t *testing.T) {
+	timer := NewActionRetentionTimer(24*time.Hour, slog.Default(), nil, ActionRetention{Enabled: true})
+	if !timer.IsEnabled() {
+		t.Fatal("timer is not enabled as configured")
+	}
+	timer.Disable()
+	if timer.IsEnabled() {
+		t.Fatal("timer is still enabled")
+	}
+	timer.Enable()
+	if !timer.IsEnabled() {
+		t.Fatal("timer is not enabled again")
+	}
+}
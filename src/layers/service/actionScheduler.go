@@ -0,0 +1,156 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"kukus/nam/v2/layers/data"
+	"log/slog"
+	"time"
+)
+
+// How often the scheduler looks for due schedules, so actions start at most this late
+const actionSchedulerInterval = 30 * time.Second
+
+// ActionScheduler launches the actions of due schedules through the ActionExecutor
+type ActionScheduler struct {
+	Database *data.Database
+	Logger   *slog.Logger
+	Executor *ActionExecutor
+	stop     chan struct{}
+}
+
+func NewActionScheduler(database *data.Database, logger *slog.Logger, executor *ActionExecutor) *ActionScheduler {
+	return &ActionScheduler{
+		Database: database,
+		Logger:   logger.With("service", "ActionScheduler"),
+		Executor: executor,
+		stop:     make(chan struct{}),
+	}
+}
+
+// Start looks for due schedules in the background until Stop is called. Runs missed while NAM was down are launched once right away
+func (as *ActionScheduler) Start() {
+	go func() {
+		ticker := time.NewTicker(actionSchedulerInterval)
+		defer ticker.Stop()
+		for {
+			as.runDue(time.Now())
+			select {
+			case <-as.stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	as.Logger.Info("Action scheduler started", "interval", actionSchedulerInterval)
+}
+
+// Stop stops looking for due schedules, actions which were already launched keep running
+func (as *ActionScheduler) Stop() {
+	close(as.stop)
+}
+
+// NextScheduleRun calculates the next run of a schedule after the given time. Returns nil if the schedule doesn't run anymore
+func NextScheduleRun(schedule *data.ActionSchedule, after time.Time) (*time.Time, error) {
+	if schedule.CronExpression == "" {
+		if schedule.RunAt == nil {
+			return nil, errors.New("schedule has neither a cron expression nor a time to run at")
+		}
+		if schedule.LastRunAt != nil {
+			// One-off schedules only run once
+			return nil, nil
+		}
+		return schedule.RunAt, nil
+	}
+	expression, err := ParseCronExpression(schedule.CronExpression)
+	if err != nil {
+		return nil, err
+	}
+	next := expression.Next(after.Local())
+	if next.IsZero() {
+		return nil, nil
+	}
+	return &next, nil
+}
+
+func (as *ActionScheduler) runDue(now time.Time) {
+	schedules, err := data.GetDueActionSchedules(as.Database.Pool, now)
+	if err != nil {
+		as.Logger.Error("Unable to get due action schedules", "error", err)
+		return
+	}
+	for _, schedule := range *schedules {
+		log := as.Logger.With("schedule_id", schedule.Id, "schedule_name", schedule.Name)
+		claimed, err := as.claim(&schedule, now, log)
+		if err != nil {
+			log.Error("Unable to record run of schedule", "error", err)
+			continue
+		} else if !claimed {
+			log.Debug("Run of schedule was already launched elsewhere")
+			continue
+		}
+		actionId, err := as.launch(&schedule, log)
+		if err != nil {
+			log.Error("Unable to launch scheduled action", "error", err)
+			continue
+		}
+		log.Info("Scheduled action launched", "action_id", actionId)
+	}
+}
+
+// Records the due run of the schedule and moves it on to its next run. Only the NAM instance whose claim succeeds launches the run.
+// The run is recorded before it is launched, so a failing schedule is not retried until its next run
+func (as *ActionScheduler) claim(schedule *data.ActionSchedule, now time.Time, log *slog.Logger) (bool, error) {
+	dueAt := *schedule.NextRunAt
+	schedule.LastRunAt = &now
+	next, err := NextScheduleRun(schedule, now)
+	if err != nil {
+		log.Warn("Unable to calculate the next run, disabling the schedule", "error", err)
+	}
+	return data.ClaimActionScheduleRun(as.Database.Pool, schedule.Id, dueAt, now, next)
+}
+
+// Launches an action for the claimed run of the schedule
+func (as *ActionScheduler) launch(schedule *data.ActionSchedule, log *slog.Logger) (uint, error) {
+	// The template may have changed since the schedule was created
+	template, err := data.GetActionTemplateById(as.Database.Pool, schedule.ActionTemplateId)
+	if err != nil {
+		return 0, fmt.Errorf("unable to get action template: %w", err)
+	}
+	if template == nil {
+		// Deleting the template deletes its schedules as well, this run was claimed just before
+		return 0, fmt.Errorf("action template %d no longer exists", schedule.ActionTemplateId)
+	}
+	action := schedule.NewAction()
+	if action.Parameters, err = data.ResolveActionParameters(template.Parameters, schedule.Parameters); err != nil {
+		return 0, err
+	}
+	// Scheduling an action doesn't approve it, it waits for approval like a manually created one
+	if template.RequiresApproval {
+		action.Status = "pending_approval"
+		action.RequiresApproval = true
+	}
+
+	// Fails if an instance was deleted since the schedule was created, rather than launching the action on the remaining ones
+	created, err := data.CreateAction(as.Database.Pool, action, schedule.InstanceIds)
+	if err != nil {
+		return 0, fmt.Errorf("unable to create action: %w", err)
+	}
+
+	if created.Status == "pending_approval" {
+		log.Info("Scheduled action awaits approval before it can be started", "action_id", created.Id)
+		return created.Id, nil
+	}
+
+	full, err := data.GetActionById(as.Database.Pool, created.Id)
+	if err != nil {
+		return created.Id, fmt.Errorf("unable to get action: %w", err)
+	}
+	if err := as.Executor.StartAction(full); err != nil {
+		if statusErr := data.UpdateActionStatus(as.Database.Pool, created.Id, "failed"); statusErr != nil {
+			log.Error("Unable to mark action as failed", "action_id", created.Id, "error", statusErr)
+		}
+		return created.Id, fmt.Errorf("unable to start action %d: %w", created.Id, err)
+	}
+	return created.Id, nil
+}
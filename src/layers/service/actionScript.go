@@ -0,0 +1,460 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"kukus/nam/v2/layers/data"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// This file provides rendering of action template scripts, shared by the preflight check and the executor, so what is previewed is what runs.
+
+// Secrets are passed to scripts as environment variables named with this prefix and a sequence number, so their values never end up in the script text
+const secretVariablePrefix = "NAM_SECRET_"
+
+// String and enum parameters are passed to scripts as environment variables named with this prefix and the name of the parameter
+const parameterVariablePrefix = "NAM_PARAM_"
+
+// Name of the function appended to every action printing into a script, see quoteParameterOutput
+const parameterOutputFunc = "namParameterOutput"
+
+// scriptParameter is the value of a string or enum parameter while rendering a script. Template logic like eq sees the value itself,
+// while printing it renders a reference to the environment variable holding it
+type scriptParameter string
+
+// ScriptRenderer renders action template scripts for application instances
+type ScriptRenderer struct {
+	pool    *pgxpool.Pool
+	secrets *SecretsService
+}
+
+// RenderedScript is the result of rendering a script for an application instance
+type RenderedScript struct {
+	Script    string            `json:"script"`
+	Variables map[string]string `json:"variables"`         // All variables available to the script, including the built-in ones
+	Missing   []string          `json:"missing_variables"` // Variables referenced by the script, which are not defined for the instance
+	Unused    []string          `json:"unused_variables"`  // Definition and instance variables, which the script doesn't reference
+	Secrets   []string          `json:"secrets"`           // Names of the secrets referenced by the script
+	// Values of the referenced secrets keyed by the name of their environment variable, only resolved when not rendering for a preview
+	Environment map[string]string `json:"-"`
+	// Values of the string and enum parameters keyed by the name of their environment variable. Unlike secrets, they are not masked
+	Parameters map[string]string `json:"-"`
+}
+
+// NewScriptRenderer creates a new script renderer, resolving secrets through the SecretsService
+func NewScriptRenderer(pool *pgxpool.Pool, secrets *SecretsService) *ScriptRenderer {
+	return &ScriptRenderer{
+		pool:    pool,
+		secrets: secrets,
+	}
+}
+
+// GetInstanceVariables gets all variables for an instance: the variables of its application definition, overridden by its own variables,
+// and the built-in variables describing the instance, its server and its application definition
+func GetInstanceVariables(pool *pgxpool.Pool, instanceId uint) (map[string]string, []string, error) {
+	instance, err := data.GetApplicationInstanceById(pool, uint64(instanceId))
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to get application instance: %w", err)
+	} else if instance == nil {
+		return nil, nil, fmt.Errorf("application instance %d not found", instanceId)
+	}
+	server, err := data.GetServerById(pool, instance.ServerID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to get server: %w", err)
+	}
+	app, err := data.GetApplicationDefinitionById(pool, uint64(instance.ApplicationDefinitionID))
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to get application definition: %w", err)
+	}
+	// Contains the inherited variables of the application definition as well
+	instanceVars, err := data.GetApplicationInstanceVariablesByApplicationInstanceId(pool, uint64(instanceId))
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to get instance variables: %w", err)
+	}
+
+	variables := make(map[string]string)
+	if instanceVars != nil {
+		for _, v := range *instanceVars {
+			if v.IsInherited {
+				variables[v.Name] = v.Value
+			}
+		}
+		// Instance variables override the inherited ones
+		for _, v := range *instanceVars {
+			if !v.IsInherited {
+				variables[v.Name] = v.Value
+			}
+		}
+	}
+	names := make([]string, 0, len(variables))
+	for name := range variables {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	// Built-in variables take precedence over the defined ones
+	variables["INSTANCE_ID"] = strconv.FormatUint(uint64(instance.Id), 10)
+	variables["INSTANCE_NAME"] = instance.Name
+	variables["SERVER_ID"] = strconv.FormatUint(uint64(server.Id), 10)
+	variables["SERVER_ALIAS"] = server.Alias
+	variables["SERVER_HOSTNAME"] = server.Hostname
+	variables["SERVER_SSH_USER"] = ""
+	if server.SshUser != nil {
+		variables["SERVER_SSH_USER"] = *server.SshUser
+	}
+	variables["APP_ID"] = strconv.FormatUint(uint64(app.Id), 10)
+	variables["APP_NAME"] = app.Name
+	variables["APP_TYPE"] = app.Type
+	variables["PORT"] = strconv.Itoa(app.Port)
+
+	return variables, names, nil
+}
+
+// Render renders the script of the template for an application instance, for a pipeline its steps as a whole. See RenderScript
+func (r *ScriptRenderer) Render(actionTemplate *data.ActionTemplate, instanceId uint, parameters map[string]string, preview bool) (*RenderedScript, error) {
+	return r.RenderScript(actionTemplate, actionTemplate.Script(), instanceId, parameters, preview)
+}
+
+// RenderScript renders a script of the template for an application instance. {{secret "name"}} renders a reference to an environment variable holding the secret,
+// the values are only resolved into the environment of the result if preview is false.
+// Parameter values take precedence over all variables, the value of a secret parameter is the name of the secret it refers to.
+// Values of string and enum parameters are input of whoever creates the action, so like secrets they are passed as environment variables and never parsed by bash.
+// Missing variables are reported in the result and returned as an error, while unused variables are only reported
+func (r *ScriptRenderer) RenderScript(actionTemplate *data.ActionTemplate, script string, instanceId uint, parameters map[string]string, preview bool) (*RenderedScript, error) {
+	variables, defined, err := GetInstanceVariables(r.pool, instanceId)
+	if err != nil {
+		return nil, err
+	}
+	resolveSecret := func(name string) (string, error) {
+		return r.secret(name, preview)
+	}
+	return renderScript(script, actionTemplate.Parameters, parameters, variables, defined, resolveSecret, preview)
+}
+
+// Renders a script with the variables of an instance, the names of its defined variables and the resolver of secrets. See RenderScript
+func renderScript(script string, declared []data.ActionTemplateParameter, parameters map[string]string, variables map[string]string, defined []string,
+	resolveSecret func(name string) (string, error), preview bool) (*RenderedScript, error) {
+	environment := make(map[string]string)
+	secretVariables := make(map[string]string) // Environment variable names keyed by secret name
+	secret := func(name string) (string, error) {
+		variable, found := secretVariables[name]
+		if !found {
+			value, err := resolveSecret(name)
+			if err != nil {
+				return "", err
+			}
+			variable = secretVariablePrefix + strconv.Itoa(len(secretVariables)+1)
+			secretVariables[name] = variable
+			if !preview {
+				environment[variable] = value
+			}
+		}
+		// Quoted, so the value is neither split nor globbed by bash. Works within double quotes as well
+		return `"${` + variable + `}"`, nil
+	}
+	parameterEnvironment := make(map[string]string)
+	parameterVariables := make(map[scriptParameter]string) // Environment variable names keyed by value
+	quoteParameters := func(args []any) ([]any, error) {
+		quoted := slices.Clone(args)
+		for i, arg := range args {
+			if parameter, ok := arg.(scriptParameter); ok {
+				variable, found := parameterVariables[parameter]
+				if !found {
+					// Derived from a parameter, e.g. with slice
+					return nil, errors.New("values derived from parameters can't be printed, only the parameters themselves")
+				}
+				quoted[i] = `"${` + variable + `}"`
+			}
+		}
+		return quoted, nil
+	}
+	funcs := template.FuncMap{
+		"secret": secret,
+		parameterOutputFunc: func(value any) (any, error) {
+			quoted, err := quoteParameters([]any{value})
+			if err != nil {
+				return nil, err
+			}
+			return quoted[0], nil
+		},
+		// The builtins formatting their arguments print parameters as a reference to their environment variable as well
+		"print": func(args ...any) (string, error) {
+			args, err := quoteParameters(args)
+			return fmt.Sprint(args...), err
+		},
+		"printf": func(format string, args ...any) (string, error) {
+			args, err := quoteParameters(args)
+			return fmt.Sprintf(format, args...), err
+		},
+		"println": func(args ...any) (string, error) {
+			args, err := quoteParameters(args)
+			return fmt.Sprintln(args...), err
+		},
+	}
+	// The escaping builtins would escape the reference instead of the value, and don't quote for bash anyway
+	for name, escape := range map[string]func(...any) string{"html": template.HTMLEscaper, "js": template.JSEscaper, "urlquery": template.URLQueryEscaper} {
+		funcs[name] = func(args ...any) (string, error) {
+			if slices.ContainsFunc(args, func(arg any) bool { _, ok := arg.(scriptParameter); return ok }) {
+				return "", fmt.Errorf("parameters can't be passed to %s", name)
+			}
+			return escape(args...), nil
+		}
+	}
+	tmpl, err := template.New("script").Option("missingkey=error").Funcs(funcs).Parse(script)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse script: %w", err)
+	}
+	// Templates defined by the script print into it as well when they are called
+	trees := scriptTrees(tmpl)
+	for _, tree := range trees {
+		quoteParameterOutput(tree, tree.Root)
+	}
+
+	scriptValues := make(map[string]any)
+	for _, parameter := range declared {
+		// Values of actions are already resolved, but a preview may lack some of them
+		value := parameters[parameter.Name]
+		if value == "" {
+			value = parameter.Default
+		}
+		if value == "" && parameter.Type == "bool" {
+			value = "false"
+		} else if value == "" && parameter.Type != "string" {
+			// Reported as missing if the script uses it
+			continue
+		}
+		switch parameter.Type {
+		case "secret":
+			if value, err = secret(value); err != nil {
+				return nil, fmt.Errorf("parameter %s: %w", parameter.Name, err)
+			}
+		case "string", "enum":
+			variable := parameterVariablePrefix + parameter.Name
+			parameterEnvironment[variable] = value
+			parameterVariables[scriptParameter(value)] = variable
+			scriptValues[parameter.Name] = scriptParameter(value)
+		}
+		variables[parameter.Name] = value
+	}
+
+	result := &RenderedScript{Variables: variables, Environment: environment, Parameters: parameterEnvironment}
+	var referenced, secrets []string
+	for _, tree := range trees {
+		treeReferenced, treeSecrets := scriptReferences(tree.Root)
+		for _, name := range treeReferenced {
+			if !slices.Contains(referenced, name) {
+				referenced = append(referenced, name)
+			}
+		}
+		for _, name := range treeSecrets {
+			if !slices.Contains(secrets, name) {
+				secrets = append(secrets, name)
+			}
+		}
+	}
+	for _, name := range referenced {
+		if _, ok := variables[name]; !ok {
+			result.Missing = append(result.Missing, name)
+		}
+	}
+	for _, name := range defined {
+		if !slices.Contains(referenced, name) {
+			result.Unused = append(result.Unused, name)
+		}
+	}
+	result.Secrets = secrets
+	if len(result.Missing) > 0 {
+		return result, fmt.Errorf("undefined variables: %s", strings.Join(result.Missing, ", "))
+	}
+
+	values := make(map[string]any, len(variables))
+	for name, value := range variables {
+		values[name] = value
+	}
+	maps.Copy(values, scriptValues)
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, values); err != nil {
+		return result, fmt.Errorf("unable to render script: %w", err)
+	}
+	result.Script = buf.String()
+
+	return result, nil
+}
+
+// RenderText renders a text referring to variables like a script does, e.g. the remote path of a file transfer. Secrets are not available
+func RenderText(text string, variables map[string]string) (string, error) {
+	tmpl, err := template.New("text").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, variables); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// RenderValue renders a text which is not passed to a script, like the URL of an http step. {{secret "name"}} renders the value of the secret itself,
+// the values of all referenced secrets are returned as well, so they can be masked
+func (r *ScriptRenderer) RenderValue(text string, variables map[string]string) (string, []string, error) {
+	var secrets []string
+	tmpl, err := template.New("value").Option("missingkey=error").Funcs(template.FuncMap{
+		"secret": func(name string) (string, error) {
+			value, err := r.secret(name, false)
+			secrets = append(secrets, value)
+			return value, err
+		},
+	}).Parse(text)
+	if err != nil {
+		return "", nil, err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, variables); err != nil {
+		return "", secrets, err
+	}
+	return buf.String(), secrets, nil
+}
+
+// Resolves the value of a secret referenced by a script. For a preview, it is only checked that the secret exists
+func (r *ScriptRenderer) secret(name string, preview bool) (string, error) {
+	if preview {
+		// Only check that the secret exists, without decrypting it
+		if _, err := data.GetSecretByName(r.pool, name); err != nil {
+			return "", fmt.Errorf("secret %q not found", name)
+		}
+		return "", nil
+	}
+	secret, err := r.secrets.GetSecretByName(name)
+	if err != nil {
+		return "", fmt.Errorf("secret %q: %w", name, err)
+	}
+	return string(secret.Data), nil
+}
+
+// Returns the parse trees of a script, the script itself first and then the templates it defines in order of their names
+func scriptTrees(tmpl *template.Template) []*parse.Tree {
+	var trees, defined []*parse.Tree
+	for _, t := range tmpl.Templates() {
+		if t.Tree == nil {
+			continue
+		}
+		if t == tmpl {
+			trees = append(trees, t.Tree)
+		} else {
+			defined = append(defined, t.Tree)
+		}
+	}
+	slices.SortFunc(defined, func(a, b *parse.Tree) int { return strings.Compare(a.Name, b.Name) })
+	return append(trees, defined...)
+}
+
+// Appends the parameter output function to the pipeline of every action printing into the script, so parameter values are printed
+// as a reference to their environment variable. Values derived from a parameter, other than with print, printf and println, fail to print
+func quoteParameterOutput(tree *parse.Tree, node parse.Node) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			quoteParameterOutput(tree, child)
+		}
+	case *parse.ActionNode:
+		// Actions declaring or assigning variables print nothing
+		if len(n.Pipe.Decl) == 0 {
+			n.Pipe.Cmds = append(n.Pipe.Cmds, &parse.CommandNode{
+				NodeType: parse.NodeCommand,
+				Pos:      n.Pos,
+				Args:     []parse.Node{parse.NewIdentifier(parameterOutputFunc).SetTree(tree).SetPos(n.Pos)},
+			})
+		}
+	case *parse.IfNode:
+		quoteParameterOutput(tree, n.List)
+		quoteParameterOutput(tree, n.ElseList)
+	case *parse.RangeNode:
+		quoteParameterOutput(tree, n.List)
+		quoteParameterOutput(tree, n.ElseList)
+	case *parse.WithNode:
+		quoteParameterOutput(tree, n.List)
+		quoteParameterOutput(tree, n.ElseList)
+	}
+}
+
+// Collects the variables referenced as {{.NAME}} or {{$.NAME}} and the secrets referenced as {{secret "name"}} by a parsed script, in order of appearance.
+// Fields within the body of range and with refer to another dot, so they are not counted as variables
+func scriptReferences(root *parse.ListNode) ([]string, []string) {
+	var variables, secrets []string
+	add := func(list *[]string, name string) {
+		if !slices.Contains(*list, name) {
+			*list = append(*list, name)
+		}
+	}
+
+	var walk func(node parse.Node, rootDot bool)
+	walk = func(node parse.Node, rootDot bool) {
+		switch n := node.(type) {
+		case *parse.ListNode:
+			if n == nil {
+				return
+			}
+			for _, child := range n.Nodes {
+				walk(child, rootDot)
+			}
+		case *parse.ActionNode:
+			walk(n.Pipe, rootDot)
+		case *parse.PipeNode:
+			if n == nil {
+				return
+			}
+			for _, cmd := range n.Cmds {
+				walk(cmd, rootDot)
+			}
+		case *parse.CommandNode:
+			if len(n.Args) == 2 {
+				if ident, ok := n.Args[0].(*parse.IdentifierNode); ok && ident.Ident == "secret" {
+					if name, ok := n.Args[1].(*parse.StringNode); ok {
+						add(&secrets, name.Text)
+					}
+				}
+			}
+			for _, arg := range n.Args {
+				walk(arg, rootDot)
+			}
+		case *parse.FieldNode:
+			if rootDot {
+				add(&variables, n.Ident[0])
+			}
+		case *parse.VariableNode:
+			if len(n.Ident) > 1 && n.Ident[0] == "$" {
+				add(&variables, n.Ident[1])
+			}
+		case *parse.ChainNode:
+			walk(n.Node, rootDot)
+		case *parse.IfNode:
+			walk(n.Pipe, rootDot)
+			walk(n.List, rootDot)
+			walk(n.ElseList, rootDot)
+		case *parse.RangeNode:
+			walk(n.Pipe, rootDot)
+			walk(n.List, false)
+			walk(n.ElseList, rootDot)
+		case *parse.WithNode:
+			walk(n.Pipe, rootDot)
+			walk(n.List, false)
+			walk(n.ElseList, rootDot)
+		case *parse.TemplateNode:
+			walk(n.Pipe, rootDot)
+		}
+	}
+	walk(root, true)
+
+	return variables, secrets
+}
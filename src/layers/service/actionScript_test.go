@@ -0,0 +1,217 @@
+package services
+
+import (
+	"errors"
+	"kukus/nam/v2/layers/data"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestRenderScript(t *testing.T) {
+	declared := []data.ActionTemplateParameter{
+		{Name: "VERSION", Type: "string"},
+		{Name: "MODE", Type: "enum", Options: []string{"fast", "safe"}},
+		{Name: "COUNT", Type: "int"},
+		{Name: "DRY_RUN", Type: "bool"},
+		{Name: "TOKEN", Type: "secret"},
+	}
+	variables := map[string]string{"APP_NAME": "shop", "LOG_DIR": "/var/log/shop"}
+	secrets := map[string]string{"deploy-token": "s3cr3t"}
+	resolveSecret := func(name string) (string, error) {
+		if value, found := secrets[name]; found {
+			return value, nil
+		}
+		return "", errors.New("not found")
+	}
+
+	tests := []struct {
+		name        string
+		script      string
+		parameters  map[string]string
+		preview     bool
+		want        string
+		environment map[string]string
+		wantParams  map[string]string
+		wantErr     bool
+		missing     []string
+	}{
+		{
+			name:       "string parameter is referenced",
+			script:     "deploy {{.VERSION}}",
+			parameters: map[string]string{"VERSION": "1.2.3"},
+			want:       `deploy "${NAM_PARAM_VERSION}"`,
+			wantParams: map[string]string{"NAM_PARAM_VERSION": "1.2.3"},
+		},
+		{
+			name:       "enum parameter in template logic sees its value",
+			script:     `{{ if eq .MODE "fast" }}skip-checks {{ end }}{{.MODE}}`,
+			parameters: map[string]string{"MODE": "fast"},
+			want:       `skip-checks "${NAM_PARAM_MODE}"`,
+		},
+		{
+			name:       "parameter assigned to a template variable",
+			script:     `{{ $v := .VERSION }}echo {{ $v }}`,
+			parameters: map[string]string{"VERSION": "x; rm -rf ~"},
+			want:       `echo "${NAM_PARAM_VERSION}"`,
+		},
+		{
+			name:       "parameter within with",
+			script:     `{{ with .APP_NAME }}{{ $.VERSION }} {{ . }}{{ else }}none{{ end }}`,
+			parameters: map[string]string{"VERSION": "1"},
+			want:       `"${NAM_PARAM_VERSION}" shop`,
+		},
+		{
+			name:       "parameter within a defined template",
+			script:     `{{ define "tag" }}--tag={{ .VERSION }}{{ end }}docker pull shop {{ template "tag" . }}`,
+			parameters: map[string]string{"VERSION": "x; rm -rf ~"},
+			want:       `docker pull shop --tag="${NAM_PARAM_VERSION}"`,
+		},
+		{
+			name:       "parameter formatted with printf",
+			script:     `echo {{ printf "v%s-%s" .VERSION .MODE }} {{ .VERSION | printf "%q" }}`,
+			parameters: map[string]string{"VERSION": "x; rm -rf ~", "MODE": "fast"},
+			want:       `echo v"${NAM_PARAM_VERSION}"-"${NAM_PARAM_MODE}" "\"${NAM_PARAM_VERSION}\""`,
+		},
+		{
+			name:       "parameter formatted with print",
+			script:     `echo {{ print .VERSION }} {{ print 1 2 }}`,
+			parameters: map[string]string{"VERSION": "1"},
+			want:       `echo "${NAM_PARAM_VERSION}" 1 2`,
+		},
+		{
+			name:       "value derived from a parameter",
+			script:     `echo {{ slice .VERSION 1 }}`,
+			parameters: map[string]string{"VERSION": "x; rm -rf ~"},
+			wantErr:    true,
+		},
+		{
+			name:       "parameter escaped with html",
+			script:     `echo {{ html .VERSION }}`,
+			parameters: map[string]string{"VERSION": "$(reboot)"},
+			wantErr:    true,
+		},
+		{
+			name:   "escaping without parameters",
+			script: `echo {{ html "<b>" }} {{ urlquery .APP_NAME "a b" }}`,
+			want:   `echo &lt;b&gt; shopa+b`,
+		},
+		{
+			name:       "int and bool parameters are printed",
+			script:     "run -n {{.COUNT}} --dry-run={{.DRY_RUN}}",
+			parameters: map[string]string{"COUNT": "3"},
+			want:       "run -n 3 --dry-run=false",
+		},
+		{
+			name:        "variables and secrets",
+			script:      `tail {{.LOG_DIR}}/{{.APP_NAME}}.log; curl -H {{secret "deploy-token"}} -u {{.TOKEN}}`,
+			parameters:  map[string]string{"TOKEN": "deploy-token"},
+			want:        `tail /var/log/shop/shop.log; curl -H "${NAM_SECRET_1}" -u "${NAM_SECRET_1}"`,
+			environment: map[string]string{"NAM_SECRET_1": "s3cr3t"},
+		},
+		{
+			name:        "preview does not resolve secrets",
+			script:      `echo {{secret "deploy-token"}}`,
+			preview:     true,
+			want:        `echo "${NAM_SECRET_1}"`,
+			environment: map[string]string{},
+		},
+		{
+			name:    "unknown secret",
+			script:  `echo {{secret "other"}}`,
+			wantErr: true,
+		},
+		{
+			name:    "missing variables",
+			script:  "echo {{.UNDEFINED}} {{.COUNT}}",
+			wantErr: true,
+			missing: []string{"UNDEFINED", "COUNT"},
+		},
+		{
+			name:    "invalid template",
+			script:  "echo {{.APP_NAME",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rendered, err := renderScript(tt.script, declared, tt.parameters, maps.Clone(variables), []string{"LOG_DIR"}, resolveSecret, tt.preview)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got script %q", rendered.Script)
+				}
+				if tt.missing != nil && (rendered == nil || !slices.Equal(rendered.Missing, tt.missing)) {
+					t.Errorf("expected missing variables %v, got %+v", tt.missing, rendered)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if rendered.Script != tt.want {
+				t.Errorf("script = %q, want %q", rendered.Script, tt.want)
+			}
+			if tt.environment != nil && !maps.Equal(rendered.Environment, tt.environment) {
+				t.Errorf("environment = %v, want %v", rendered.Environment, tt.environment)
+			}
+			if tt.wantParams != nil && !maps.Equal(rendered.Parameters, tt.wantParams) {
+				t.Errorf("parameters = %v, want %v", rendered.Parameters, tt.wantParams)
+			}
+		})
+	}
+}
+
+func TestRenderScriptUnusedVariables(t *testing.T) {
+	rendered, err := renderScript("echo {{.A}}", nil, nil, map[string]string{"A": "1", "B": "2"}, []string{"A", "B"}, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(rendered.Unused, []string{"B"}) {
+		t.Errorf("unused = %v, want [B]", rendered.Unused)
+	}
+}
+
+// Parameter values must reach bash as data, whether the script prints them unquoted or within double quotes
+func TestRenderScriptParametersAreNotCode(t *testing.T) {
+	if _, err := exec.LookPath("bash"); err != nil {
+		t.Skip("bash is not available")
+	}
+	declared := []data.ActionTemplateParameter{{Name: "VALUE", Type: "string"}}
+	marker := filepath.Join(t.TempDir(), "injected")
+	for _, value := range []string{
+		"x; touch " + marker,
+		"$(touch " + marker + ")",
+		"`touch " + marker + "`",
+		`"; touch ` + marker + `; echo "`,
+		"' ; touch " + marker + " ; '",
+	} {
+		for _, script := range []string{
+			`echo {{.VALUE}}`,
+			`echo "value: {{.VALUE}}"`,
+			`V={{.VALUE}}; echo "$V"`,
+			`{{define "value"}}{{.VALUE}}{{end}}echo {{template "value" .}}`,
+			`{{define "value"}}echo {{.}}{{end}}{{template "value" .VALUE}}`,
+			`echo {{printf "--value=%s" .VALUE}}`,
+			`echo "{{.VALUE | printf "%s"}}"`,
+			`echo {{print .VALUE}}`,
+			`echo {{println .VALUE}}`,
+		} {
+			rendered, err := renderScript(script, declared, map[string]string{"VALUE": value}, map[string]string{}, nil, nil, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cmd := exec.Command("bash", "-c", rendered.Script)
+			cmd.Env = append(os.Environ(), "NAM_PARAM_VALUE="+rendered.Parameters["NAM_PARAM_VALUE"])
+			output, err := cmd.CombinedOutput()
+			if err != nil {
+				t.Fatalf("script %q failed: %v: %s", rendered.Script, err, output)
+			}
+			if _, err := os.Stat(marker); err == nil {
+				t.Fatalf("value %q was run as code by %q", value, script)
+			}
+		}
+	}
+}
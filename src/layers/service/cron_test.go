@@ -0,0 +1,161 @@
+package services
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata" // The DST tests need the zone database, which may be missing on the host
+)
+
+func TestParseCronExpression(t *testing.T) {
+	tests := []struct {
+		expression string
+		wantErr    bool
+	}{
+		{"* * * * *", false},
+		{"*/5 9-17 * * mon-fri", false},
+		{"0 0 1,15 jan,JUL *", false},
+		{"5/15 * * * *", false},
+		{"0 0 * * 7", false},
+		{"@daily", false},
+		{" @Weekly ", false},
+		{"", true},
+		{"* * * *", true},
+		{"* * * * * *", true},
+		{"60 * * * *", true},
+		{"* 24 * * *", true},
+		{"* * 0 * *", true},
+		{"* * 32 * *", true},
+		{"* * * 13 *", true},
+		{"* * * * 8", true},
+		{"*/0 * * * *", true},
+		{"*/x * * * *", true},
+		{"17-9 * * * *", true},
+		{"* * * foo *", true},
+		{"1,,2 * * * *", true},
+		{"@reboot", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expression, func(t *testing.T) {
+			_, err := ParseCronExpression(tt.expression)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseCronExpression(%q) error = %v, wantErr %v", tt.expression, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCronExpressionNext(t *testing.T) {
+	at := func(value string) time.Time {
+		parsed, err := time.ParseInLocation("2006-01-02 15:04:05", value, time.UTC)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed
+	}
+	// 2026-01-01 is a Thursday
+	tests := []struct {
+		name       string
+		expression string
+		after      string
+		want       []string // Consecutive runs, an empty string if there is none
+	}{
+		{"every minute", "* * * * *", "2026-01-01 10:00:30", []string{"2026-01-01 10:01:00", "2026-01-01 10:02:00"}},
+		{"exactly on a match is excluded", "0 * * * *", "2026-01-01 10:00:00", []string{"2026-01-01 11:00:00"}},
+		{"step", "*/15 * * * *", "2026-01-01 10:07:00", []string{"2026-01-01 10:15:00", "2026-01-01 10:30:00", "2026-01-01 10:45:00", "2026-01-01 11:00:00"}},
+		{"step from a value", "5/20 * * * *", "2026-01-01 10:00:00", []string{"2026-01-01 10:05:00", "2026-01-01 10:25:00", "2026-01-01 10:45:00", "2026-01-01 11:05:00"}},
+		{"range with step", "0 9-17/4 * * *", "2026-01-01 10:00:00", []string{"2026-01-01 13:00:00", "2026-01-01 17:00:00", "2026-01-02 09:00:00"}},
+		{"list", "0 8,12 * * *", "2026-01-01 09:00:00", []string{"2026-01-01 12:00:00", "2026-01-02 08:00:00"}},
+		{"hour rolls over the day", "30 23 * * *", "2026-01-01 23:30:00", []string{"2026-01-02 23:30:00"}},
+		{"month rolls over the year", "0 0 1 * *", "2026-12-15 00:00:00", []string{"2027-01-01 00:00:00"}},
+		{"month names", "0 0 1 jun,dec *", "2026-01-01 00:00:00", []string{"2026-06-01 00:00:00", "2026-12-01 00:00:00"}},
+		{"weekdays by name", "0 9 * * mon-fri", "2026-01-02 10:00:00", []string{"2026-01-05 09:00:00", "2026-01-06 09:00:00"}},
+		{"sunday as 7", "0 0 * * 7", "2026-01-01 00:00:00", []string{"2026-01-04 00:00:00", "2026-01-11 00:00:00"}},
+		{"sunday as 0", "0 0 * * 0", "2026-01-01 00:00:00", []string{"2026-01-04 00:00:00"}},
+		{"day of month only", "0 0 13 * *", "2026-01-01 00:00:00", []string{"2026-01-13 00:00:00", "2026-02-13 00:00:00"}},
+		{"day of week only", "0 0 * * fri", "2026-01-01 00:00:00", []string{"2026-01-02 00:00:00", "2026-01-09 00:00:00"}},
+		// With both day fields restricted either one has to match
+		{"day of month or day of week", "0 0 13 * fri", "2026-01-08 00:00:00", []string{"2026-01-09 00:00:00", "2026-01-13 00:00:00", "2026-01-16 00:00:00"}},
+		// A stepped day field still counts as unrestricted
+		{"stepped day of week", "0 0 1 * */1", "2026-01-01 00:00:00", []string{"2026-02-01 00:00:00"}},
+		{"leap day", "0 0 29 2 *", "2026-01-01 00:00:00", []string{"2028-02-29 00:00:00"}},
+		{"never", "0 0 30 2 *", "2026-01-01 00:00:00", []string{""}},
+		{"descriptor", "@monthly", "2026-01-15 12:00:00", []string{"2026-02-01 00:00:00"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expression, err := ParseCronExpression(tt.expression)
+			if err != nil {
+				t.Fatal(err)
+			}
+			after := at(tt.after)
+			for _, want := range tt.want {
+				next := expression.Next(after)
+				if want == "" {
+					if !next.IsZero() {
+						t.Fatalf("Next(%s) = %s, want none", after, next)
+					}
+					return
+				}
+				if !next.Equal(at(want)) {
+					t.Fatalf("Next(%s) = %s, want %s", after, next, want)
+				}
+				after = next
+			}
+		})
+	}
+}
+
+func TestCronExpressionNextDst(t *testing.T) {
+	tests := []struct {
+		name       string
+		location   string
+		expression string
+		after      string   // In the location
+		want       []string // RFC 3339, so times repeated when clocks fall back are unambiguous
+	}{
+		// Europe/Berlin springs forward from 02:00 CET to 03:00 CEST on 2026-03-29
+		{"skipped time does not run", "Europe/Berlin", "30 2 * * *", "2026-03-28 12:00",
+			[]string{"2026-03-30T02:30:00+02:00"}},
+		{"hourly across spring forward", "Europe/Berlin", "0 * * * *", "2026-03-29 00:30",
+			[]string{"2026-03-29T01:00:00+01:00", "2026-03-29T03:00:00+02:00", "2026-03-29T04:00:00+02:00"}},
+		{"time after the gap", "Europe/Berlin", "0 3 * * *", "2026-03-29 00:00",
+			[]string{"2026-03-29T03:00:00+02:00", "2026-03-30T03:00:00+02:00"}},
+		// and falls back from 03:00 CEST to 02:00 CET on 2026-10-25
+		{"repeated time runs once", "Europe/Berlin", "30 2 * * *", "2026-10-25 00:00",
+			[]string{"2026-10-25T02:30:00+02:00", "2026-10-26T02:30:00+01:00"}},
+		{"hourly runs in both repeated hours", "Europe/Berlin", "0 * * * *", "2026-10-25 01:30",
+			[]string{"2026-10-25T02:00:00+02:00", "2026-10-25T02:00:00+01:00", "2026-10-25T03:00:00+01:00"}},
+		{"every 20 minutes across fall back", "Europe/Berlin", "*/20 * * * *", "2026-10-25 01:50",
+			[]string{"2026-10-25T02:00:00+02:00", "2026-10-25T02:20:00+02:00", "2026-10-25T02:40:00+02:00", "2026-10-25T02:00:00+01:00", "2026-10-25T02:20:00+01:00"}},
+		{"daily at midnight keeps the wall clock", "Europe/Berlin", "0 0 * * *", "2026-10-24 12:00",
+			[]string{"2026-10-25T00:00:00+02:00", "2026-10-26T00:00:00+01:00"}},
+		// America/New_York springs forward at 02:00 on 2026-03-08 and falls back at 02:00 on 2026-11-01
+		{"new york skipped time", "America/New_York", "15 2 * * *", "2026-03-07 12:00",
+			[]string{"2026-03-09T02:15:00-04:00"}},
+		{"new york repeated time runs once", "America/New_York", "15 1 * * *", "2026-11-01 00:00",
+			[]string{"2026-11-01T01:15:00-04:00", "2026-11-02T01:15:00-05:00"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			location, err := time.LoadLocation(tt.location)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expression, err := ParseCronExpression(tt.expression)
+			if err != nil {
+				t.Fatal(err)
+			}
+			after, err := time.ParseInLocation("2006-01-02 15:04", tt.after, location)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, want := range tt.want {
+				next := expression.Next(after)
+				if next.Format(time.RFC3339) != want {
+					t.Fatalf("Next(%s) = %s, want %s", after.Format(time.RFC3339), next.Format(time.RFC3339), want)
+				}
+				after = next
+			}
+		})
+	}
+}
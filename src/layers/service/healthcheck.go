@@ -5,9 +5,11 @@ import (
 	"crypto/tls"
 	"kukus/nam/v2/layers/data"
 	"log/slog"
+	"net"
 	"runtime/pprof"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/jackc/pgx/v5"
@@ -24,14 +26,20 @@ type HealthcheckService struct {
 	Observers           map[uint]*HealthcheckObserver // Map of healthchecks being monitored with their observers (keyed by instance ID)
 	Logger              *slog.Logger
 	TlsConfig           *tls.Config
+	Ssh                 *SshPool     // Runs the commands of SSH healthchecks, shared with the action executor
+	observersLock       sync.RWMutex // Observers are read by the action executor while the listeners update them
 }
 
-func NewHealthcheckService(database *data.Database, logger *slog.Logger, tlsConfig *tls.Config) *HealthcheckService {
+// How often WaitForHealthy looks at the latest result of an observer
+const healthyPollInterval = 1 * time.Second
+
+func NewHealthcheckService(database *data.Database, logger *slog.Logger, tlsConfig *tls.Config, sshPool *SshPool) *HealthcheckService {
 	hcs := HealthcheckService{
 		Database:  database,
 		Observers: make(map[uint]*HealthcheckObserver),
 		Logger:    logger.With("service", "HealthcheckService"),
 		TlsConfig: tlsConfig,
+		Ssh:       sshPool,
 	}
 	go hcs.Start()
 	return &hcs
@@ -80,11 +88,14 @@ func (hcs *HealthcheckService) Start() error {
 // Syncs all observers from the database, overwrites existing ones
 func (hcs *HealthcheckService) SyncObserversAll() error {
 	// first, clear existing observers
+	hcs.observersLock.Lock()
 	for id := range hcs.Observers {
 		if observer, exists := hcs.Observers[id]; exists {
 			observer.TimerCancel() // Cancel the timer for each observer
 		}
 	}
+	hcs.Observers = make(map[uint]*HealthcheckObserver)
+	hcs.observersLock.Unlock()
 	// Prepare data, fetch application instances
 	ais, err := data.GetAllApplicationInstancesFull(hcs.Database.Pool)
 	if err != nil {
@@ -106,7 +117,6 @@ func (hcs *HealthcheckService) SyncObserversAll() error {
 			healthcheckMap[*hc.Id] = &hc
 		}
 	}
-	hcs.Observers = make(map[uint]*HealthcheckObserver, len(*ais))
 	for _, ai := range *ais {
 		if ai.ApplicationDefinition.HealthcheckId != nil && !ai.MaintenanceMode {
 			hc := healthcheckMap[*ai.ApplicationDefinition.HealthcheckId]
@@ -194,10 +204,7 @@ func (hcs *HealthcheckService) ListenForApplicationInstanceChanges() {
 				}
 				if ai.ApplicationDefinition.HealthcheckId == nil {
 					// No healthcheck assigned, just remove existing observer if exists
-					if observer, exists := hcs.Observers[uint(id)]; exists {
-						observer.TimerCancel()
-						delete(hcs.Observers, uint(id))
-					}
+					hcs.RemoveObserver(uint(id))
 					continue
 				}
 				hc, err := data.GetHealthCheckById(hcs.Database.Pool, *ai.ApplicationDefinition.HealthcheckId)
@@ -208,18 +215,11 @@ func (hcs *HealthcheckService) ListenForApplicationInstanceChanges() {
 				if hc == nil {
 					log.Warn("Healthcheck for updated application instance not found in database", "application instance_id", id, "healthcheck_id", *ai.ApplicationDefinition.HealthcheckId)
 					// Just remove existing observer if exists
-					if observer, exists := hcs.Observers[uint(id)]; exists {
-						observer.TimerCancel()
-						delete(hcs.Observers, uint(id))
-					}
+					hcs.RemoveObserver(uint(id))
 					continue
 				}
 				// Update or create observer for the application instance
-				if observer, exists := hcs.Observers[uint(id)]; exists {
-					// Remove existing observer
-					observer.TimerCancel()
-					delete(hcs.Observers, uint(id))
-				}
+				hcs.RemoveObserver(uint(id))
 				if !ai.MaintenanceMode {
 					// Create new observer
 					hcs.NewObserver(ai, hc)
@@ -229,10 +229,7 @@ func (hcs *HealthcheckService) ListenForApplicationInstanceChanges() {
 			case "DELETE":
 				// Deleted existing application instance. Need to stop and remove the observer that monitor this application instance
 				// Remove observers for the application instance
-				if observer, exists := hcs.Observers[uint(id)]; exists {
-					observer.TimerCancel() // Cancel the timer for the observer
-					delete(hcs.Observers, uint(id))
-				}
+				hcs.RemoveObserver(uint(id))
 				// Existing application instance deleted
 				log.Info("Application instance deleted", "payload", notification.Payload)
 			}
@@ -296,11 +293,7 @@ func (hcs *HealthcheckService) ListenForHealthcheckChanges() {
 				}
 				// Recreate observers for each application instance
 				for _, ai := range *ais {
-					if observer, exists := hcs.Observers[uint(id)]; exists {
-						// Delete existing observer
-						observer.TimerCancel()
-						delete(hcs.Observers, uint(id))
-					}
+					hcs.RemoveObserver(uint(ai.Id))
 					// Create new observer
 					hcs.NewObserver(&ai, hc)
 				}
@@ -316,10 +309,7 @@ func (hcs *HealthcheckService) ListenForHealthcheckChanges() {
 				}
 				// Remove observers for each application instance
 				for _, ai := range *ais {
-					if observer, exists := hcs.Observers[uint(ai.Id)]; exists {
-						observer.TimerCancel() // Cancel the timer for the observer
-						delete(hcs.Observers, uint(ai.Id))
-					}
+					hcs.RemoveObserver(uint(ai.Id))
 				}
 				// Existing healthcheck deleted
 				log.Info("Healthcheck deleted", "payload", notification.Payload)
@@ -328,19 +318,67 @@ func (hcs *HealthcheckService) ListenForHealthcheckChanges() {
 	}
 }
 
+// GetObserver returns the observer of the application instance, if it is being monitored
+func (hcs *HealthcheckService) GetObserver(instanceId uint) (*HealthcheckObserver, bool) {
+	hcs.observersLock.RLock()
+	defer hcs.observersLock.RUnlock()
+	observer, exists := hcs.Observers[instanceId]
+	return observer, exists
+}
+
+// RemoveObserver stops and removes the observer of the application instance, if there is one
+func (hcs *HealthcheckService) RemoveObserver(instanceId uint) {
+	hcs.observersLock.Lock()
+	defer hcs.observersLock.Unlock()
+	if observer, exists := hcs.Observers[instanceId]; exists {
+		observer.TimerCancel() // Cancel the timer for the observer
+		delete(hcs.Observers, instanceId)
+	}
+}
+
+// WaitForHealthy blocks until the observer of the application instance reports a successful check which started after since.
+// The observer is looked up again on every poll, as it is recreated whenever the instance changes (e.g. leaves maintenance mode).
+// Returns the context's error if it is done first
+func (hcs *HealthcheckService) WaitForHealthy(ctx context.Context, instanceId uint, since time.Time) error {
+	ticker := time.NewTicker(healthyPollInterval)
+	defer ticker.Stop()
+	for {
+		if observer, found := hcs.GetObserver(instanceId); found {
+			if result := observer.LastResult(); result != nil && result.IsSuccessful && !result.TimeStart.Before(since) {
+				return nil
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
 // Observer is a struct that monitors a specific healthcheck and its associated application instance.
 // Each instance has its own observer.
 type HealthcheckObserver struct {
 	ApplicationInstance *data.ApplicationInstanceFull // The application instance being monitored
 	Healthcheck         *data.Healthcheck             // The healthcheck being observed
 	Timer               *time.Timer                   // Timer for periodic checks
-	TimerCancel         context.CancelFunc            // Cancel function for the timer
+	TimerCancel         context.CancelFunc            // Stops the observer, along with a probe in progress
 	TargetUrl           string                        // URL to check
-	Context             context.Context               // Context for managing goroutines
+	Context             context.Context               // Context for managing goroutines, done once the observer is stopped
 	DbPool              *pgxpool.Pool                 // Database connection pool
 	ProbeFunc           func()                        // Function to perform the healthcheck probe
 	Logger              *slog.Logger
 	TlsConfig           *tls.Config
+	Ssh                 *SshPool
+	lastResult          *data.HealthcheckResult // Result of the latest probe
+	resultLock          sync.Mutex
+}
+
+// LastResult returns the result of the latest probe, nil if no probe finished yet
+func (hco *HealthcheckObserver) LastResult() *data.HealthcheckResult {
+	hco.resultLock.Lock()
+	defer hco.resultLock.Unlock()
+	return hco.lastResult
 }
 
 // Creates and starts a new observer for the given application instance and healthcheck
@@ -352,6 +390,15 @@ func (hcs *HealthcheckService) NewObserver(ai *data.ApplicationInstanceFull, hc
 	}
 	// Get target url together
 	observer.TargetUrl = hc.Protocol + "://" + ai.Server.Hostname + ":" + strconv.Itoa(int(ai.ApplicationDefinition.Port)) + hc.ReqUrl
+	switch hc.Protocol {
+	case "tcp":
+		// TCP checks only connect to the port of the instance
+		observer.TargetUrl = "tcp://" + net.JoinHostPort(ai.Server.Hostname, strconv.Itoa(int(ai.ApplicationDefinition.Port)))
+	case "ssh":
+		// SSH checks run their command on the server of the instance
+		observer.TargetUrl = "ssh://" + ai.Server.Hostname
+		observer.Ssh = hcs.Ssh
+	}
 	observer.Logger = hcs.Logger.With("application_instance_id", ai.Id, "application_instance_name", ai.Name, "healthcheck_id", *hc.Id, "healthcheck_name", hc.Name)
 	if hc.Protocol == "https" {
 		observer.TlsConfig = hcs.TlsConfig.Clone()
@@ -361,7 +408,9 @@ func (hcs *HealthcheckService) NewObserver(ai *data.ApplicationInstanceFull, hc
 	observer.Context = context.WithValue(observer.Context, "healthcheck_id", *hc.Id)
 	// Start the observer
 	observer.Start(hcs.Database.Pool)
+	hcs.observersLock.Lock()
 	hcs.Observers[ai.Id] = &observer
+	hcs.observersLock.Unlock()
 	hcs.Logger.Debug("Healthcheck observer started", "id", *hc.Id, "name", hc.Name, "for_application_instance_id", ai.Id, "for_application_instance_name", ai.Name)
 	return &observer
 }
@@ -369,25 +418,38 @@ func (hcs *HealthcheckService) NewObserver(ai *data.ApplicationInstanceFull, hc
 func (hco *HealthcheckObserver) Start(pool *pgxpool.Pool) {
 	hco.DbPool = pool
 	hco.Timer = time.NewTimer(hco.Healthcheck.CheckInterval)
+	var cancel context.CancelFunc
+	hco.Context, cancel = context.WithCancel(hco.Context)
 	hco.TimerCancel = func() {
-		cancelCtx := context.WithValue(context.Background(), "component", "healthcheck_observer_timer_cancel")
-		pprof.SetGoroutineLabels(cancelCtx)
-		if !hco.Timer.Stop() {
-			<-hco.Timer.C // Drain the channel if the timer was already fired
-		}
+		cancel()
+		// Since Go 1.23 a stopped timer delivers no stale tick, so its channel must not be drained
+		hco.Timer.Stop()
 	}
 	// Set up the healthcheck probe function
 	hco.ProbeFunc = func() {
 		// Set goroutine labels for better profiling
 		pprof.SetGoroutineLabels(hco.Context)
 		// Perform the healthcheck
-		result, err := hco.Healthcheck.PerformCheck(hco.TargetUrl, hco.TlsConfig)
+		var result *data.HealthcheckResult
+		var err error
+		if hco.Healthcheck.Protocol == "ssh" {
+			result, err = hco.Healthcheck.PerformCommandCheck(hco.Context, hco.runSshCommand)
+		} else {
+			result, err = hco.Healthcheck.PerformCheck(hco.Context, hco.TargetUrl, hco.TlsConfig)
+		}
+		if hco.Context.Err() != nil {
+			// The observer was stopped during the probe, its result is incomplete
+			return
+		}
 		result.ApplicationInstanceID = hco.ApplicationInstance.Id
 		if err != nil {
 			// Happens only if there is something wrong on the network layer
 			hco.Logger.Debug("Healthcheck failed", "instance_id", hco.ApplicationInstance.Id, "url", hco.TargetUrl, "error", err.Error())
 		}
 		hco.Logger.Debug("Healthcheck result", "instance_id", hco.ApplicationInstance.Id, "is_successful", result.IsSuccessful, "status", result.ResStatus, "response_time", result.ResTime)
+		hco.resultLock.Lock()
+		hco.lastResult = result
+		hco.resultLock.Unlock()
 		// Insert the result into the database
 		_, err = result.DbInsert(hco.DbPool)
 		if err != nil {
@@ -399,10 +461,12 @@ func (hco *HealthcheckObserver) Start(pool *pgxpool.Pool) {
 	// Start the healthcheck probe function
 	go func() {
 		hco.ProbeFunc() // Initial call to start the healthcheck immediately
-		for {           // this is okay, ignore linter
+		for {
 			select {
 			case <-hco.Timer.C:
 				hco.ProbeFunc()
+			case <-hco.Context.Done():
+				return
 			}
 		}
 	}()
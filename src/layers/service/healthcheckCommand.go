@@ -0,0 +1,86 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"kukus/nam/v2/layers/data"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// This file provides the runner of SSH healthchecks, which run a command on the server of the instance over its shared
+// SSH connection, e.g. systemctl is-active for daemons without a port to check.
+
+// Only the start of the output of healthcheck commands is kept
+const maxHealthcheckCommandOutput = 64 << 10
+
+// Runs the command on the server of the observed instance. The timeout covers waiting for a free SSH slot as well
+func (hco *HealthcheckObserver) runSshCommand(command string, timeout time.Duration) (int, string, string, error) {
+	if hco.Ssh == nil {
+		return 0, "", "", errors.New("SSH healthchecks are not available")
+	}
+	ctx, cancel := context.WithTimeout(hco.Context, timeout)
+	defer cancel()
+
+	// The instance only carries the hostname of its server, the SSH settings are loaded on every probe so changes apply right away
+	server, err := data.GetServerById(hco.DbPool, hco.ApplicationInstance.Server.Id)
+	if err != nil {
+		return 0, "", "", fmt.Errorf("unable to get server: %w", err)
+	}
+	lease, err := hco.Ssh.Acquire(ctx, server, nil)
+	if err != nil {
+		if ctx.Err() != nil {
+			return 0, "", "", fmt.Errorf("no SSH connection to %s within %s", server.Alias, timeout)
+		}
+		return 0, "", "", err
+	}
+	defer lease.Release()
+	session, err := lease.Client.NewSession()
+	if err != nil {
+		return 0, "", "", fmt.Errorf("unable to open SSH session: %w", err)
+	}
+	defer session.Close()
+
+	stdout := &cappedBuffer{limit: maxHealthcheckCommandOutput}
+	stderr := &cappedBuffer{limit: maxHealthcheckCommandOutput}
+	session.Stdout = stdout
+	session.Stderr = stderr
+	if err := session.Start(command); err != nil {
+		return 0, "", "", fmt.Errorf("unable to start command: %w", err)
+	}
+	finished := make(chan error, 1)
+	go func() {
+		finished <- session.Wait()
+	}()
+	select {
+	case err = <-finished:
+	case <-ctx.Done():
+		// Not every SSH server supports signals, closing the session hangs up on the command either way
+		session.Signal(ssh.SIGKILL)
+		session.Close()
+		return 0, stdout.String(), stderr.String(), fmt.Errorf("command did not finish within %s", timeout)
+	}
+	var exitErr *ssh.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitStatus(), stdout.String(), stderr.String(), nil
+	} else if err != nil {
+		return 0, stdout.String(), stderr.String(), err
+	}
+	return 0, stdout.String(), stderr.String(), nil
+}
+
+// Keeps the first bytes written to it up to its limit, and discards the rest
+type cappedBuffer struct {
+	bytes.Buffer
+	limit int
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - b.Len(); room > 0 {
+		b.Buffer.Write(p[:min(len(p), room)])
+	}
+	return len(p), nil
+}
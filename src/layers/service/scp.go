@@ -0,0 +1,178 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// This file provides file transfers over SSH through the scp protocol, which only requires the scp binary on the server.
+
+// Downloaded files are kept in the database, so their size is limited
+const maxScpDownloadSize = 256 << 20
+
+// ScpUpload copies the content to the remote path on the server, with the given octal permissions.
+// If the remote path is a directory, the file is created in it with the base name of the path
+func ScpUpload(client *ssh.Client, remotePath string, mode string, content []byte) error {
+	permissions, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || permissions > 0o777 {
+		return fmt.Errorf("invalid file mode %q", mode)
+	}
+	session, err := client.NewSession()
+	if err != nil {
+		return fmt.Errorf("unable to open SSH session: %w", err)
+	}
+	defer session.Close()
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	var stderr bytes.Buffer
+	session.Stderr = &stderr
+	if err := session.Start("scp -t " + shellQuote(remotePath)); err != nil {
+		return fmt.Errorf("unable to start scp: %w", err)
+	}
+	reply := bufio.NewReader(stdout)
+
+	transfer := func() error {
+		if err := scpAck(reply); err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(stdin, "C%04o %d %s\n", permissions, len(content), path.Base(remotePath)); err != nil {
+			return err
+		}
+		if err := scpAck(reply); err != nil {
+			return err
+		}
+		if _, err := stdin.Write(content); err != nil {
+			return err
+		}
+		if _, err := stdin.Write([]byte{0}); err != nil {
+			return err
+		}
+		return scpAck(reply)
+	}
+	err = transfer()
+	stdin.Close()
+	if waitErr := session.Wait(); err == nil && waitErr != nil {
+		err = waitErr
+	}
+	if err != nil {
+		return scpError(err, &stderr)
+	}
+	return nil
+}
+
+// ScpDownload copies the file at the remote path from the server. Returns its content and its name
+func ScpDownload(client *ssh.Client, remotePath string) ([]byte, string, error) {
+	session, err := client.NewSession()
+	if err != nil {
+		return nil, "", fmt.Errorf("unable to open SSH session: %w", err)
+	}
+	defer session.Close()
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		return nil, "", err
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return nil, "", err
+	}
+	var stderr bytes.Buffer
+	session.Stderr = &stderr
+	if err := session.Start("scp -f " + shellQuote(remotePath)); err != nil {
+		return nil, "", fmt.Errorf("unable to start scp: %w", err)
+	}
+	reply := bufio.NewReader(stdout)
+
+	var content []byte
+	var name string
+	transfer := func() error {
+		if _, err := stdin.Write([]byte{0}); err != nil {
+			return err
+		}
+		header, err := reply.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		switch header[0] {
+		case 1, 2:
+			return errors.New(strings.TrimSpace(header[1:]))
+		case 'C':
+		default:
+			// Directories (D) are only sent recursively, which is not requested
+			return fmt.Errorf("unexpected scp reply: %q", strings.TrimSpace(header))
+		}
+		// C<mode> <size> <name>
+		fields := strings.SplitN(strings.TrimSpace(header[1:]), " ", 3)
+		if len(fields) != 3 {
+			return fmt.Errorf("unexpected scp header: %q", strings.TrimSpace(header))
+		}
+		size, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("unexpected scp header: %q", strings.TrimSpace(header))
+		}
+		if size > maxScpDownloadSize {
+			return fmt.Errorf("file is %d bytes, larger than the maximum of %d bytes", size, maxScpDownloadSize)
+		}
+		name = fields[2]
+		if _, err := stdin.Write([]byte{0}); err != nil {
+			return err
+		}
+		content = make([]byte, size)
+		if _, err := io.ReadFull(reply, content); err != nil {
+			return err
+		}
+		if err := scpAck(reply); err != nil {
+			return err
+		}
+		_, err = stdin.Write([]byte{0})
+		return err
+	}
+	err = transfer()
+	stdin.Close()
+	if waitErr := session.Wait(); err == nil && waitErr != nil {
+		err = waitErr
+	}
+	if err != nil {
+		return nil, "", scpError(err, &stderr)
+	}
+	return content, name, nil
+}
+
+// Reads the reply of the remote scp to a message: 0 if it is ok, 1 or 2 followed by a message for a warning or an error
+func scpAck(reply *bufio.Reader) error {
+	code, err := reply.ReadByte()
+	if err != nil {
+		return err
+	}
+	if code == 0 {
+		return nil
+	}
+	message, _ := reply.ReadString('\n')
+	return errors.New(strings.TrimSpace(message))
+}
+
+// Adds what the remote scp printed on stderr to the error, it usually explains it best
+func scpError(err error, stderr *bytes.Buffer) error {
+	if message := strings.TrimSpace(stderr.String()); message != "" {
+		return fmt.Errorf("%w: %s", err, message)
+	}
+	return err
+}
+
+// Quotes the value for bash, so it is passed as a single word
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
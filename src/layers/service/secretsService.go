@@ -53,23 +53,32 @@ func (s *SecretsService) CreateSecret(dto *data.SecretDTO, userId *uint64) (*uin
 
 // GetSecret retrieves and decrypts a secret by ID
 func (s *SecretsService) GetSecret(id uint64) (*data.Secret, error) {
-	panic("Not implemented yet")
-	/*
-		secret, err := data.GetSecretById(s.db, id)
-		if err != nil {
-			return nil, nil, fmt.Errorf("failed to retrieve secret: %w", err)
-		}
+	secretDAO, err := data.GetSecretById(s.db, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve secret: %w", err)
+	}
+
+	secret, err := s.crypto.DecryptDAO(secretDAO)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt secret: %w", err)
+	}
 
+	return secret, nil
+}
 
-		// Decrypt the secret data
+// GetSecretByName retrieves and decrypts a secret by its name
+func (s *SecretsService) GetSecretByName(name string) (*data.Secret, error) {
+	secretDAO, err := data.GetSecretByName(s.db, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve secret: %w", err)
+	}
 
-			secretData, err := secret.GetSecretData()
-			if err != nil {
-				return nil, nil, fmt.Errorf("failed to decrypt secret: %w", err)
-			}
+	secret, err := s.crypto.DecryptDAO(secretDAO)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt secret: %w", err)
+	}
 
-			return secret, secretData, nil
-	*/
+	return secret, nil
 }
 
 // GetSecretsMetadata returns all secrets metadata (no decrypted data)
@@ -55,6 +55,12 @@ func (sm *ServiceManager) StopService(name string) error {
 	}
 	return svc.Stop()
 }
+
+// GetService returns the registered service with the given name
+func (sm *ServiceManager) GetService(name string) (Service, bool) {
+	svc, exists := sm.services[name]
+	return svc, exists
+}
 func (sm *ServiceManager) GetServiceStatus(name string) (string, error) {
 	svc, exists := sm.services[name]
 	if !exists {
@@ -0,0 +1,265 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"kukus/nam/v2/layers/data"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"golang.org/x/crypto/ssh"
+)
+
+// This file provides helpers for opening SSH connections to servers, using the SSH settings stored on the Server.
+
+const (
+	defaultSshPort        = 22
+	defaultSshDialTimeout = 30 * time.Second
+	maxSshJumpHosts       = 5 // Jump hosts chained in front of a server, guards against misconfigured chains
+)
+
+// NewSshClientConfig builds the SSH client configuration for the server, decrypting its credentials through the SecretsService.
+// The host key of the server is verified against the pinned one, or pinned on first contact
+func NewSshClientConfig(pool *pgxpool.Pool, server *data.Server, secrets *SecretsService) (*ssh.ClientConfig, error) {
+	if server.SshAuthType == nil || *server.SshAuthType == "" {
+		return nil, fmt.Errorf("server %s has no SSH authentication configured", server.Alias)
+	}
+	if server.SshUser == nil || *server.SshUser == "" {
+		return nil, fmt.Errorf("server %s has no SSH user configured", server.Alias)
+	}
+	if server.SshAuthSecretId == nil {
+		return nil, fmt.Errorf("server %s has no SSH credentials configured", server.Alias)
+	}
+	secret, err := secrets.GetSecret(*server.SshAuthSecretId)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get SSH credentials for server %s: %w", server.Alias, err)
+	}
+
+	var auth []ssh.AuthMethod
+	switch *server.SshAuthType {
+	case "password":
+		password := string(secret.Data)
+		auth = []ssh.AuthMethod{
+			ssh.Password(password),
+			// Some servers only allow keyboard-interactive, answer every prompt with the password
+			ssh.KeyboardInteractive(func(user, instruction string, questions []string, echos []bool) ([]string, error) {
+				answers := make([]string, len(questions))
+				for i := range answers {
+					answers[i] = password
+				}
+				return answers, nil
+			}),
+		}
+	case "private_key":
+		signer, err := ssh.ParsePrivateKey(secret.Data)
+		var passphraseErr *ssh.PassphraseMissingError
+		if errors.As(err, &passphraseErr) {
+			return nil, fmt.Errorf("private key %s is protected by a passphrase, which is not supported", secret.Name)
+		} else if err != nil {
+			return nil, fmt.Errorf("unable to parse private key %s: %w", secret.Name, err)
+		}
+		auth = []ssh.AuthMethod{ssh.PublicKeys(signer)}
+	default:
+		return nil, fmt.Errorf("unsupported SSH authentication type: %s", *server.SshAuthType)
+	}
+
+	return &ssh.ClientConfig{
+		User:              *server.SshUser,
+		Auth:              auth,
+		HostKeyCallback:   hostKeyCallback(pool, server),
+		HostKeyAlgorithms: hostKeyAlgorithms(server),
+		Timeout:           defaultSshDialTimeout,
+	}, nil
+}
+
+// SshAddress returns the host:port address of the server's SSH daemon
+func SshAddress(server *data.Server) string {
+	port := defaultSshPort
+	if server.SshPort != nil && *server.SshPort > 0 {
+		port = *server.SshPort
+	}
+	return net.JoinHostPort(server.Hostname, strconv.Itoa(port))
+}
+
+// DialSsh opens a new SSH connection to the server, tunnelled through its jump hosts.
+// Fails with a HostKeyMismatchError if the server or one of its jump hosts presents another host key than the pinned one
+func DialSsh(pool *pgxpool.Pool, server *data.Server, secrets *SecretsService) (*ssh.Client, error) {
+	config, err := NewSshClientConfig(pool, server, secrets)
+	if err != nil {
+		return nil, err
+	}
+	jumpHosts, err := SshJumpHosts(pool, server)
+	if err != nil {
+		return nil, err
+	}
+	return dialSsh(pool, secrets, jumpHosts, server, config)
+}
+
+// SshJumpHosts returns the chain of jump hosts which connections to the server are tunnelled through, the one to connect to first comes first
+func SshJumpHosts(pool *pgxpool.Pool, server *data.Server) ([]*data.Server, error) {
+	var jumpHosts []*data.Server
+	visited := map[uint]bool{server.Id: true}
+	for current := server; current.SshJumpServerId != nil; {
+		if visited[*current.SshJumpServerId] {
+			return nil, fmt.Errorf("jump hosts of server %s form a loop at server %s", server.Alias, current.Alias)
+		}
+		if len(jumpHosts) == maxSshJumpHosts {
+			return nil, fmt.Errorf("server %s has more than %d jump hosts", server.Alias, maxSshJumpHosts)
+		}
+		jumpHost, err := data.GetServerById(pool, *current.SshJumpServerId)
+		if err != nil {
+			return nil, fmt.Errorf("unable to get jump host of server %s: %w", current.Alias, err)
+		}
+		visited[jumpHost.Id] = true
+		jumpHosts = append([]*data.Server{jumpHost}, jumpHosts...)
+		current = jumpHost
+	}
+	return jumpHosts, nil
+}
+
+// Connects to the jump hosts one after another, each through the previous one, and finally to the server with the config.
+// Every jump host authenticates with its own credentials from the secrets store and has its host key verified
+func dialSsh(pool *pgxpool.Pool, secrets *SecretsService, jumpHosts []*data.Server, server *data.Server, config *ssh.ClientConfig) (*ssh.Client, error) {
+	var jump *ssh.Client
+	for _, jumpHost := range jumpHosts {
+		jumpConfig, err := NewSshClientConfig(pool, jumpHost, secrets)
+		if err != nil {
+			if jump != nil {
+				jump.Close()
+			}
+			return nil, fmt.Errorf("unable to use jump host %s: %w", jumpHost.Alias, err)
+		}
+		if jump, err = dialSshHop(jump, jumpHost, jumpConfig); err != nil {
+			return nil, fmt.Errorf("unable to use jump host %s: %w", jumpHost.Alias, err)
+		}
+	}
+	return dialSshHop(jump, server, config)
+}
+
+// Opens an SSH connection to the server, directly if jump is nil, else tunnelled through it.
+// The jump connection is closed along with the new one, or right away if it fails
+func dialSshHop(jump *ssh.Client, server *data.Server, config *ssh.ClientConfig) (*ssh.Client, error) {
+	address := SshAddress(server)
+	if jump == nil {
+		client, err := ssh.Dial("tcp", address, config)
+		if err != nil {
+			return nil, fmt.Errorf("unable to connect to %s: %w", address, err)
+		}
+		return client, nil
+	}
+
+	conn, err := jump.Dial("tcp", address)
+	if err != nil {
+		jump.Close()
+		return nil, fmt.Errorf("unable to connect to %s through %s: %w", address, jump.RemoteAddr(), err)
+	}
+	// Unlike ssh.Dial, the handshake over the tunnel has no deadline of its own, the jump connection is closed if it takes too long
+	timer := time.AfterFunc(defaultSshDialTimeout, func() { jump.Close() })
+	clientConn, channels, requests, err := ssh.NewClientConn(conn, address, config)
+	if !timer.Stop() && err == nil {
+		clientConn.Close()
+		err = errors.New("handshake timed out")
+	}
+	if err != nil {
+		conn.Close()
+		jump.Close()
+		return nil, fmt.Errorf("unable to connect to %s through %s: %w", address, jump.RemoteAddr(), err)
+	}
+	client := ssh.NewClient(clientConn, channels, requests)
+	go func() {
+		client.Wait()
+		jump.Close()
+	}()
+	return client, nil
+}
+
+// SshConnectionTest is the result of testing the SSH settings of a server
+type SshConnectionTest struct {
+	Success            bool     `json:"success"`
+	Stage              string   `json:"stage,omitempty"` // Where the test failed: config, host_key, connect or command
+	Error              string   `json:"error,omitempty"`
+	Address            string   `json:"address"`
+	User               string   `json:"user"`
+	AuthType           string   `json:"auth_type"`
+	JumpHosts          []string `json:"jump_hosts,omitempty"`           // Aliases of the jump hosts the connection was tunnelled through
+	HostKeyFingerprint string   `json:"host_key_fingerprint,omitempty"` // Of the key the server presented
+	ConnectMillis      float64  `json:"connect_ms"`                     // Including the handshake and authentication
+	CommandMillis      float64  `json:"command_ms"`                     // Round trip of the test command
+	Uname              string   `json:"uname,omitempty"`
+	Os                 string   `json:"os,omitempty"` // PRETTY_NAME of /etc/os-release, if the server has one
+}
+
+// Prints the kernel in the first line and the distribution, if known, in the second
+const sshTestCommand = `uname -srmo; (. /etc/os-release 2>/dev/null && echo "$PRETTY_NAME")`
+
+// TestSshConnection connects to the server with its SSH settings, like an action would, and runs a trivial command on it.
+// The connect time includes the connections to its jump hosts
+func TestSshConnection(pool *pgxpool.Pool, server *data.Server, secrets *SecretsService) *SshConnectionTest {
+	result := &SshConnectionTest{Address: SshAddress(server)}
+	if server.SshUser != nil {
+		result.User = *server.SshUser
+	}
+	if server.SshAuthType != nil {
+		result.AuthType = *server.SshAuthType
+	}
+	failed := func(stage string, err error) *SshConnectionTest {
+		result.Stage = stage
+		result.Error = err.Error()
+		return result
+	}
+
+	config, err := NewSshClientConfig(pool, server, secrets)
+	if err != nil {
+		return failed("config", err)
+	}
+	verify := config.HostKeyCallback
+	config.HostKeyCallback = func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		result.HostKeyFingerprint = ssh.FingerprintSHA256(key)
+		return verify(hostname, remote, key)
+	}
+
+	jumpHosts, err := SshJumpHosts(pool, server)
+	if err != nil {
+		return failed("config", err)
+	}
+	for _, jumpHost := range jumpHosts {
+		result.JumpHosts = append(result.JumpHosts, jumpHost.Alias)
+	}
+
+	start := time.Now()
+	client, err := dialSsh(pool, secrets, jumpHosts, server, config)
+	result.ConnectMillis = float64(time.Since(start).Microseconds()) / 1000
+	var mismatch *HostKeyMismatchError
+	if errors.As(err, &mismatch) {
+		return failed("host_key", err)
+	} else if err != nil {
+		return failed("connect", err)
+	}
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		return failed("command", fmt.Errorf("unable to open SSH session: %w", err))
+	}
+	defer session.Close()
+	// The connection is closed if the command hangs, which ends the session
+	timer := time.AfterFunc(defaultSshDialTimeout, func() { client.Close() })
+	defer timer.Stop()
+	start = time.Now()
+	output, err := session.CombinedOutput(sshTestCommand)
+	result.CommandMillis = float64(time.Since(start).Microseconds()) / 1000
+	if err != nil {
+		return failed("command", fmt.Errorf("test command failed: %w: %s", err, strings.TrimSpace(string(output))))
+	}
+
+	lines := strings.SplitN(strings.TrimSpace(string(output)), "\n", 2)
+	result.Uname = strings.TrimSpace(lines[0])
+	if len(lines) > 1 {
+		result.Os = strings.TrimSpace(lines[1])
+	}
+	result.Success = true
+	return result
+}
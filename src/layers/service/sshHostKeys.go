@@ -0,0 +1,136 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"kukus/nam/v2/layers/data"
+	"net"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"golang.org/x/crypto/ssh"
+)
+
+// This file provides the verification of the host keys of servers. Every server has its host key pinned, either by an Admin
+// or on first contact (trust on first use). Connections to servers presenting another key are refused.
+
+// HostKeyMismatchError is returned when a server presents another host key than the one pinned for it
+type HostKeyMismatchError struct {
+	Server    string
+	Expected  string // Fingerprint of the pinned key
+	Presented string // Fingerprint of the key the server presented
+}
+
+func (e *HostKeyMismatchError) Error() string {
+	return fmt.Sprintf("host key of server %s does not match the pinned key: expected %s, but it presented %s. "+
+		"The server may be impersonated. If its key was changed on purpose, an Admin has to re-pin it", e.Server, e.Expected, e.Presented)
+}
+
+// Verifies the key presented by the server against the one pinned for it. Without a pinned key the presented one is trusted and pinned.
+// If only the fingerprint was pinned, the presented key is recorded once it matches
+func hostKeyCallback(pool *pgxpool.Pool, server *data.Server) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		fingerprint := ssh.FingerprintSHA256(key)
+		if server.SshHostKeyFingerprint != nil && *server.SshHostKeyFingerprint != fingerprint {
+			return &HostKeyMismatchError{Server: server.Alias, Expected: *server.SshHostKeyFingerprint, Presented: fingerprint}
+		}
+		if server.SshHostKey != nil {
+			return nil
+		}
+
+		recorded, err := data.RecordServerHostKey(pool, server.Id, MarshalHostKey(key), fingerprint)
+		if err != nil {
+			return fmt.Errorf("unable to record host key of server %s: %w", server.Alias, err)
+		}
+		if recorded {
+			return nil
+		}
+		// Another connection recorded a key first, which this one has to match as well
+		current, err := data.GetServerById(pool, server.Id)
+		if err != nil {
+			return fmt.Errorf("unable to get host key of server %s: %w", server.Alias, err)
+		}
+		if current.SshHostKeyFingerprint == nil || *current.SshHostKeyFingerprint != fingerprint {
+			expected := "no key"
+			if current.SshHostKeyFingerprint != nil {
+				expected = *current.SshHostKeyFingerprint
+			}
+			return &HostKeyMismatchError{Server: server.Alias, Expected: expected, Presented: fingerprint}
+		}
+		return nil
+	}
+}
+
+// Returns the host key algorithms to negotiate with the server. Once its key is known, only the algorithms of that key are offered,
+// so a server with several host keys presents the pinned one
+func hostKeyAlgorithms(server *data.Server) []string {
+	if server.SshHostKey == nil {
+		return nil
+	}
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(*server.SshHostKey))
+	if err != nil {
+		return nil
+	}
+	if key.Type() == ssh.KeyAlgoRSA {
+		return []string{ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA}
+	}
+	return []string{key.Type()}
+}
+
+// MarshalHostKey returns the key in authorized_keys format, without the trailing newline
+func MarshalHostKey(key ssh.PublicKey) string {
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+}
+
+// ParseHostKeyFingerprint validates a SHA256 fingerprint as printed by ssh-keygen -l, e.g. SHA256:uNiVztksCsDhcc0u9e8BujQXVUpKZIDTMczCvj3tD2s
+func ParseHostKeyFingerprint(fingerprint string) (string, error) {
+	fingerprint = strings.TrimSpace(fingerprint)
+	digest, found := strings.CutPrefix(fingerprint, "SHA256:")
+	if !found {
+		return "", errors.New("fingerprint must be a SHA256 fingerprint, starting with SHA256:")
+	}
+	// 32 bytes in unpadded base64
+	if len(digest) != 43 || strings.Trim(digest, "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/") != "" {
+		return "", errors.New("fingerprint must be 43 characters of base64 after SHA256:")
+	}
+	return fingerprint, nil
+}
+
+// ScanSshHostKey connects to the server only to receive the host key it presents, without authenticating to it.
+// The algorithms of the pinned key are preferred, so the presented key can be compared to it. Servers behind jump hosts
+// are scanned through them, which requires the jump hosts' credentials
+func ScanSshHostKey(pool *pgxpool.Pool, server *data.Server, secrets *SecretsService) (ssh.PublicKey, error) {
+	jumpHosts, err := SshJumpHosts(pool, server)
+	if err != nil {
+		return nil, err
+	}
+	errScanned := errors.New("host key received")
+	scan := func(algorithms []string) (ssh.PublicKey, error) {
+		var presented ssh.PublicKey
+		config := &ssh.ClientConfig{
+			User: "nam",
+			HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+				presented = key
+				return errScanned
+			},
+			HostKeyAlgorithms: algorithms,
+			Timeout:           defaultSshDialTimeout,
+		}
+		client, err := dialSsh(pool, secrets, jumpHosts, server, config)
+		if client != nil {
+			client.Close()
+		}
+		if presented == nil {
+			return nil, fmt.Errorf("unable to receive host key from %s: %w", SshAddress(server), err)
+		}
+		return presented, nil
+	}
+
+	algorithms := hostKeyAlgorithms(server)
+	key, err := scan(algorithms)
+	if err != nil && algorithms != nil {
+		// The server may not have a key of the pinned type anymore
+		key, err = scan(nil)
+	}
+	return key, err
+}
@@ -0,0 +1,297 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"kukus/nam/v2/layers/data"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"golang.org/x/crypto/ssh"
+)
+
+// This file provides the pool of SSH connections used by executions. All executions on a server share one connection, each with
+// its own sessions, and only so many of them hold it at once, per server and in total. The rest wait in line for a free slot.
+
+const (
+	DefaultSshMaxSessions          = 64
+	DefaultSshMaxSessionsPerServer = 8 // Below the MaxSessions default of OpenSSH (10), which also counts the sessions used to cancel scripts
+	// How long an unused connection is kept open for the next execution
+	sshPoolIdleTimeout = 60 * time.Second
+)
+
+// SshPool hands out leases on shared SSH connections to servers
+type SshPool struct {
+	pool                 *pgxpool.Pool
+	secrets              *SecretsService
+	log                  *slog.Logger
+	maxSessionsPerServer int
+	global               chan struct{}          // Slots of all servers, a lease holds one
+	servers              map[uint]*sshPoolSlots // Keyed by server ID
+	lock                 sync.Mutex
+}
+
+// Slots and the connection of a server
+type sshPoolSlots struct {
+	slots      chan struct{}
+	connection *sshPoolConnection // nil until the first lease, or after the connection broke or idled out
+}
+
+// A connection shared by the leases of a server
+type sshPoolConnection struct {
+	client   *ssh.Client
+	err      error         // Why dialing failed
+	dialed   chan struct{} // Closed once dialing finished
+	settings string        // SSH settings of the server the connection was dialed with
+	leases   int
+	idle     *time.Timer // Closes the connection once it's unused for too long
+	retired  bool        // The settings of the server changed, closed once its last lease is released
+}
+
+// SshLease is a slot on a server's shared connection. Sessions are opened on its Client, it must be released once they are closed
+type SshLease struct {
+	Client     *ssh.Client
+	pool       *SshPool
+	serverId   uint
+	slots      chan struct{}
+	connection *sshPoolConnection
+	once       sync.Once
+}
+
+func NewSshPool(pool *pgxpool.Pool, logger *slog.Logger, secrets *SecretsService, maxSessions int, maxSessionsPerServer int) *SshPool {
+	if maxSessions <= 0 {
+		maxSessions = DefaultSshMaxSessions
+	}
+	if maxSessionsPerServer <= 0 {
+		maxSessionsPerServer = DefaultSshMaxSessionsPerServer
+	}
+	return &SshPool{
+		pool:                 pool,
+		secrets:              secrets,
+		log:                  logger.With("service", "SshPool"),
+		maxSessionsPerServer: maxSessionsPerServer,
+		global:               make(chan struct{}, maxSessions),
+		servers:              make(map[uint]*sshPoolSlots),
+	}
+}
+
+// Acquire waits for a free slot on the server and in total, then returns a lease on the server's shared connection, dialing it if there is none.
+// queued is called once if there is no free slot right away. Fails with the context's error if it is done first
+func (p *SshPool) Acquire(ctx context.Context, server *data.Server, queued func(reason string)) (*SshLease, error) {
+	slots := p.slots(server)
+	if !acquireSlot(ctx, slots, queued, fmt.Sprintf("all %d SSH slots of server %s are in use", cap(slots), server.Alias)) {
+		return nil, ctx.Err()
+	}
+	if !acquireSlot(ctx, p.global, queued, fmt.Sprintf("all %d SSH slots of NAM are in use", cap(p.global))) {
+		<-slots
+		return nil, ctx.Err()
+	}
+
+	// The jump hosts are part of the settings the connection depends on, so changes to them apply to the next lease
+	jumpHosts, err := SshJumpHosts(p.pool, server)
+	if err != nil {
+		<-p.global
+		<-slots
+		return nil, err
+	}
+	connection := p.connection(server, jumpHosts)
+	select {
+	case <-connection.dialed:
+	case <-ctx.Done():
+		p.release(server.Id, connection)
+		<-p.global
+		<-slots
+		return nil, ctx.Err()
+	}
+	if connection.err != nil {
+		p.release(server.Id, connection)
+		<-p.global
+		<-slots
+		return nil, connection.err
+	}
+	return &SshLease{Client: connection.client, pool: p, serverId: server.Id, slots: slots, connection: connection}, nil
+}
+
+// Release frees the slot of the lease. The connection stays open for other leases, until it's unused for a while
+func (l *SshLease) Release() {
+	l.once.Do(func() {
+		l.pool.release(l.serverId, l.connection)
+		<-l.pool.global
+		<-l.slots
+	})
+}
+
+// Takes a slot, waiting for one if all are taken
+func acquireSlot(ctx context.Context, slots chan struct{}, queued func(reason string), reason string) bool {
+	select {
+	case slots <- struct{}{}:
+		return true
+	default:
+	}
+	if queued != nil {
+		queued(reason)
+	}
+	select {
+	case slots <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Returns the slots of the server. They are replaced if its limit was changed, leases holding the old ones release them as they are
+func (p *SshPool) slots(server *data.Server) chan struct{} {
+	limit := p.maxSessionsPerServer
+	if server.SshMaxSessions != nil && *server.SshMaxSessions > 0 {
+		limit = *server.SshMaxSessions
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	entry, found := p.servers[server.Id]
+	if !found {
+		entry = &sshPoolSlots{}
+		p.servers[server.Id] = entry
+	}
+	if cap(entry.slots) != limit {
+		entry.slots = make(chan struct{}, limit)
+	}
+	return entry.slots
+}
+
+// Returns the shared connection to the server, counting the new lease. A new one is dialed if there is none,
+// or the SSH settings of the server or its jump hosts changed since it was dialed
+func (p *SshPool) connection(server *data.Server, jumpHosts []*data.Server) *sshPoolConnection {
+	settings := sshSettings(server, jumpHosts)
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	entry := p.servers[server.Id]
+	connection := entry.connection
+	if connection != nil && connection.settings != settings {
+		p.log.Debug("SSH settings of server changed, retiring its connection", "server_id", server.Id)
+		p.retire(entry)
+		connection = nil
+	}
+	if connection == nil {
+		connection = &sshPoolConnection{dialed: make(chan struct{}), settings: settings}
+		entry.connection = connection
+		go p.dial(server, jumpHosts, connection)
+	}
+	if connection.idle != nil {
+		connection.idle.Stop()
+		connection.idle = nil
+	}
+	connection.leases++
+	return connection
+}
+
+// Dials the connection through the jump hosts, it is forgotten again if it fails or once it breaks
+func (p *SshPool) dial(server *data.Server, jumpHosts []*data.Server, connection *sshPoolConnection) {
+	client, err := p.dialSsh(server, jumpHosts)
+	p.lock.Lock()
+	connection.client, connection.err = client, err
+	if err != nil {
+		p.forget(server.Id, connection)
+	} else if connection.leases == 0 {
+		// Every lease waiting for it gave up meanwhile
+		p.idle(server.Id, connection)
+	}
+	p.lock.Unlock()
+	close(connection.dialed)
+	if err != nil {
+		return
+	}
+	p.log.Debug("Opened shared SSH connection", "server_id", server.Id, "address", SshAddress(server))
+	go func() {
+		client.Wait()
+		p.log.Debug("Shared SSH connection closed", "server_id", server.Id)
+		p.lock.Lock()
+		p.forget(server.Id, connection)
+		p.lock.Unlock()
+	}()
+}
+
+// Dials the server like DialSsh, through the jump hosts its settings were taken with
+func (p *SshPool) dialSsh(server *data.Server, jumpHosts []*data.Server) (*ssh.Client, error) {
+	config, err := NewSshClientConfig(p.pool, server, p.secrets)
+	if err != nil {
+		return nil, err
+	}
+	return dialSsh(p.pool, p.secrets, jumpHosts, server, config)
+}
+
+// Counts a released lease
+func (p *SshPool) release(serverId uint, connection *sshPoolConnection) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	connection.leases--
+	if connection.leases == 0 && connection.client != nil {
+		p.idle(serverId, connection)
+	}
+}
+
+// Closes a connection without leases after the idle timeout, or right away if it was retired. Must be called with the lock held
+func (p *SshPool) idle(serverId uint, connection *sshPoolConnection) {
+	if connection.retired {
+		connection.client.Close()
+		return
+	}
+	connection.idle = time.AfterFunc(sshPoolIdleTimeout, func() {
+		p.lock.Lock()
+		defer p.lock.Unlock()
+		if connection.leases == 0 {
+			p.forget(serverId, connection)
+			connection.client.Close()
+		}
+	})
+}
+
+// Removes the connection from the server, if it still is the server's connection. Must be called with the lock held
+func (p *SshPool) forget(serverId uint, connection *sshPoolConnection) {
+	if entry := p.servers[serverId]; entry != nil && entry.connection == connection {
+		entry.connection = nil
+	}
+}
+
+// Detaches the server's connection, so the next lease dials a new one. Must be called with the lock held
+func (p *SshPool) retire(entry *sshPoolSlots) {
+	connection := entry.connection
+	entry.connection = nil
+	connection.retired = true
+	if connection.idle != nil {
+		connection.idle.Stop()
+	}
+	if connection.leases == 0 && connection.client != nil {
+		connection.client.Close()
+	}
+}
+
+// Returns the settings a connection to the server through the jump hosts depends on, including their pinned host keys.
+// Pinning another key or forgetting it thereby retires the connections which trusted the old one
+func sshSettings(server *data.Server, jumpHosts []*data.Server) string {
+	var settings string
+	for _, host := range append(slices.Clone(jumpHosts), server) {
+		if settings != "" {
+			settings += "|via|"
+		}
+		settings += sshHostSettings(host)
+	}
+	return settings
+}
+
+// Returns the settings a connection to a single host depends on
+func sshHostSettings(server *data.Server) string {
+	settings := fmt.Sprintf("%d|%s", server.Id, SshAddress(server))
+	for _, value := range []*string{server.SshUser, server.SshAuthType, server.SshHostKeyFingerprint} {
+		settings += "|"
+		if value != nil {
+			settings += *value
+		}
+	}
+	if server.SshAuthSecretId != nil {
+		settings += fmt.Sprintf("|secret:%d", *server.SshAuthSecretId)
+	}
+	return settings
+}
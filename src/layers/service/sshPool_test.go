@@ -0,0 +1,45 @@
+package services
+
+import (
+	"kukus/nam/v2/layers/data"
+	"testing"
+)
+
+func TestSshSettings(t *testing.T) {
+	user, key, otherKey := "deploy", "SHA256:abc", "SHA256:def"
+	jumpId := uint(2)
+	server := func() *data.Server {
+		return &data.Server{Id: 1, Hostname: "app.example.com", SshUser: &user, SshHostKeyFingerprint: &key, SshJumpServerId: &jumpId}
+	}
+	jumpHost := func() *data.Server {
+		return &data.Server{Id: 2, Hostname: "bastion.example.com", SshUser: &user, SshHostKeyFingerprint: &key}
+	}
+	base := sshSettings(server(), []*data.Server{jumpHost()})
+
+	tests := []struct {
+		name   string
+		change func(server, jumpHost *data.Server)
+	}{
+		{"host key pinned", func(server, jumpHost *data.Server) { server.SshHostKeyFingerprint = &otherKey }},
+		{"host key forgotten", func(server, jumpHost *data.Server) { server.SshHostKeyFingerprint = nil }},
+		{"jump host key pinned", func(server, jumpHost *data.Server) { jumpHost.SshHostKeyFingerprint = &otherKey }},
+		{"jump host key forgotten", func(server, jumpHost *data.Server) { jumpHost.SshHostKeyFingerprint = nil }},
+		{"jump host address", func(server, jumpHost *data.Server) { jumpHost.Hostname = "other.example.com" }},
+		{"user", func(server, jumpHost *data.Server) { server.SshUser = nil }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changedServer, changedJumpHost := server(), jumpHost()
+			tt.change(changedServer, changedJumpHost)
+			if sshSettings(changedServer, []*data.Server{changedJumpHost}) == base {
+				t.Error("settings did not change")
+			}
+		})
+	}
+	if sshSettings(server(), []*data.Server{jumpHost()}) != base {
+		t.Error("settings of the same server changed")
+	}
+	if sshSettings(server(), nil) == base {
+		t.Error("settings without the jump host are the same")
+	}
+}
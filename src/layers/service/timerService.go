@@ -18,7 +18,7 @@ type TimerService struct {
 
 var TimerSvc *TimerService
 
-func NewTimerService(pool *pgxpool.Pool, logger *slog.Logger) {
+func NewTimerService(pool *pgxpool.Pool, logger *slog.Logger, actionRetention ActionRetention) {
 	if TimerSvc != nil {
 		logger.Warn("TimerService already initialized, skipping re-initialization")
 		return
@@ -29,8 +29,12 @@ func NewTimerService(pool *pgxpool.Pool, logger *slog.Logger) {
 		Jobs: map[int]TimerJob{
 			0: NewDatabaseCleanupTimer(24*time.Hour, logger, pool),
 			1: NewDatabaseHealthCheckResultFlusher(logger, pool),
+			2: NewActionRetentionTimer(24*time.Hour, logger, pool, actionRetention),
 		},
 	}
+	// Only the Action Retention Timer runs on its own, the other jobs are run from the settings page.
+	// It keeps ticking while disabled, so it runs once it is enabled
+	ts.Jobs[2].Start()
 	TimerSvc = ts
 }
 
@@ -21,6 +21,11 @@ type Application struct {
 	Configuration ApplicationConfiguration
 	Services      *services.ServiceManager
 	TlsConfig     *tls.Config
+	Crypto        *services.CryptoService
+	Secrets       *services.SecretsService
+	SshPool       *services.SshPool        // Shared by the action executor and SSH healthchecks
+	Renderer      *services.ScriptRenderer // Shared by the preflight checks and the executor, so what is previewed is what runs
+	Executor      *services.ActionExecutor
 }
 
 var App Application
@@ -88,23 +93,42 @@ func main() {
 	App.Database = db
 	log.Info("Successfully initialised database connection and migrated to latest schema")
 
-	if App.Configuration.WebServer.Enabled {
-		// Start web server
-		go InitWebServer(&App, rotlog)
-	} else {
-		log.Warn("Web server is disabled in the configuration, skipping web server initialization")
-	}
-
 	// Init Services
 	log.Debug("Initializing services")
 	App.Services = services.NewServiceManager(*log)
+	App.Crypto = services.NewCryptoService("nam-secrets-salt-2025", []byte("nam-secrets-salt-2025"))
+	App.Secrets = services.NewSecretsService(App.Database.Pool, log, App.Crypto)
+	// Executions and SSH healthchecks on the same server share one SSH connection
+	App.SshPool = services.NewSshPool(App.Database.Pool, log, App.Secrets, App.Configuration.Ssh.MaxSessions, App.Configuration.Ssh.MaxSessionsPerServer)
 	if enabled, found := App.Configuration.Services["HealthcheckService"]; enabled && found {
 		log.Info("HealthcheckService is enabled, initializing")
-		healthcheckService := services.NewHealthcheckService(App.Database, log, App.TlsConfig)
+		healthcheckService := services.NewHealthcheckService(App.Database, log, App.TlsConfig, App.SshPool)
 		App.Services.RegisterService(healthcheckService)
 	}
 	services.NewDashboardCacheService(App.Database.Pool, log)
-	services.NewTimerService(App.Database.Pool, log)
+	// Healthcheck gated actions need the HealthcheckService, which is optional
+	var healthcheckService *services.HealthcheckService
+	if svc, found := App.Services.GetService("HealthcheckService"); found {
+		healthcheckService = svc.(*services.HealthcheckService)
+	}
+	App.Renderer = services.NewScriptRenderer(App.Database.Pool, App.Secrets)
+	App.Executor = services.NewActionExecutor(App.Database, log, App.Secrets, App.SshPool, App.Renderer, healthcheckService, App.Configuration.Actions.LocalExecutor)
+	// Schedules run whether or not the web server is enabled
+	services.NewActionScheduler(App.Database, log, App.Executor).Start()
+	services.NewTimerService(App.Database.Pool, log, services.ActionRetention{
+		Enabled:    App.Configuration.Retention.Enabled,
+		ActionDays: App.Configuration.Retention.ActionDays,
+		OutputDays: App.Configuration.Retention.OutputDays,
+	})
+
+	// The web server is started after the services, as the action controllers depend on the executor
+	if App.Configuration.WebServer.Enabled {
+		// Start web server
+		go InitWebServer(&App, rotlog)
+	} else {
+		log.Warn("Web server is disabled in the configuration, skipping web server initialization")
+	}
+
 	for {
 		status, _ := App.Services.GetServiceStatus("HealthcheckService")
 		fmt.Println("HealthcheckService status:", status)
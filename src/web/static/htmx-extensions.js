@@ -34,4 +34,57 @@ function handleRestApiResponse(event, redirect) {
     } else {
         showErrorMessage(event.detail.xhr.responseText)
     }
-}
\ No newline at end of file
+}
+// Extension for following Server-Sent Events via hx-ext="sse"
+// sse-connect="<url>" opens the event stream on the element.
+// sse-append="<event>,<event>" on the element or a child appends the lines of these events as text (never as HTML),
+// every line in its own div with the classes from sse-line-class and "sse-<event>".
+// sse-close="<event>" closes the stream once the event is received, instead of letting the browser reconnect.
+// Every event above, and the ones listed in sse-trigger, is also triggered on the element as "sse:<event>" with the data in the detail.
+htmx.defineExtension('sse', {
+    onEvent: function (name, evt) {
+        const elt = evt.detail.elt
+        if (name === "htmx:afterProcessNode" && elt.hasAttribute && elt.hasAttribute("sse-connect")) {
+            connectEventSource(elt)
+        } else if (name === "htmx:beforeCleanupElement" && elt.sseSource) {
+            elt.sseSource.close()
+            delete elt.sseSource
+        }
+    }
+})
+
+function connectEventSource(elt) {
+    if (elt.sseSource) {
+        return
+    }
+    const source = new EventSource(elt.getAttribute("sse-connect"))
+    elt.sseSource = source
+    const splitEvents = (value) => (value || "").split(",").map(e => e.trim()).filter(e => e !== "")
+    const listened = new Set(splitEvents(elt.getAttribute("sse-trigger")))
+
+    const appenders = [elt, ...elt.querySelectorAll("[sse-append]")].filter(e => e.hasAttribute("sse-append"))
+    appenders.forEach(target => {
+        splitEvents(target.getAttribute("sse-append")).forEach(event => {
+            listened.add(event)
+            source.addEventListener(event, function (e) {
+                const lineClass = target.getAttribute("sse-line-class") || ""
+                e.data.split("\n").forEach(line => {
+                    const div = document.createElement("div")
+                    div.className = (lineClass + " sse-" + event).trim()
+                    div.textContent = line
+                    target.appendChild(div)
+                })
+            })
+        })
+    })
+
+    const closeEvent = elt.getAttribute("sse-close")
+    if (closeEvent) {
+        listened.add(closeEvent)
+        source.addEventListener(closeEvent, () => source.close())
+    }
+    listened.forEach(event => {
+        source.addEventListener(event, e => htmx.trigger(elt, "sse:" + event, { data: e.data }))
+    })
+    source.onerror = () => htmx.trigger(elt, "sse:error", {})
+}
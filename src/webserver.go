@@ -84,6 +84,7 @@ func InitWebServer(app *Application, loggerWriter *lumberjack.Logger) {
 	app.Engine.FuncMap["derefBool"] = derefBool
 	app.Engine.FuncMap["derefInt"] = derefInt
 	app.Engine.FuncMap["derefInt64"] = derefInt64
+	app.Engine.FuncMap["derefUint"] = derefUint
 	app.Engine.FuncMap["derefUint64"] = derefUint64
 	app.Engine.FuncMap["derefStr"] = derefStr
 	app.Engine.FuncMap["title"] = func(s string) string {
@@ -109,13 +110,16 @@ func InitWebServer(app *Application, loggerWriter *lumberjack.Logger) {
 	}
 	// Alias to shorten code
 	dbPool := App.Database.Pool
-	cryptoService := services.NewCryptoService("nam-secrets-salt-2025", []byte("nam-secrets-salt-2025"))
+	cryptoService := App.Crypto
+	secretService := App.Secrets
+	scriptRenderer := App.Renderer
+	actionExecutor := App.Executor
 	{ // REST
 		restV1group := App.Engine.Group("/api/rest/v1")
 		restV1group.Use(AuthMiddleware())
 		restV1group.Use(RequireRole(dbPool, "Viewer")) // All endpoint require at least Viewer role
 		{                                              // Servers
-			serverController := apiRestV1.NewServerController(App.Database)
+			serverController := apiRestV1.NewServerController(App.Database, secretService)
 			serverGroup := restV1group.Group("/servers")
 			serverGroup.POST("/", RequireRole(dbPool, "Operator"), serverController.NewServer)
 			serverGroup.GET("/", serverController.GetAll)
@@ -124,6 +128,11 @@ func InitWebServer(app *Application, loggerWriter *lumberjack.Logger) {
 				serverIdGroup.GET("/", serverController.GetById)
 				serverIdGroup.PUT("/", RequireRole(dbPool, "Operator"), serverController.UpdateById)
 				serverIdGroup.DELETE("/", RequireRole(dbPool, "Operator"), serverController.RemoveById)
+				serverIdGroup.POST("/test", RequireRole(dbPool, "Operator"), serverController.TestConnection)
+				// Host keys are only pinned by Admins
+				serverIdGroup.POST("/hostkey/scan", RequireRole(dbPool, "Admin"), serverController.ScanHostKey)
+				serverIdGroup.PUT("/hostkey", RequireRole(dbPool, "Admin"), serverController.PinHostKey)
+				serverIdGroup.DELETE("/hostkey", RequireRole(dbPool, "Admin"), serverController.ForgetHostKey)
 			}
 		}
 		{ // Healthchecks
@@ -191,7 +200,6 @@ func InitWebServer(app *Application, loggerWriter *lumberjack.Logger) {
 			userGroup.PUT("/:id/password", userHandler.UpdatePassword)
 		}
 		{ // Secrets
-			secretService := services.NewSecretsService(App.Database.Pool, log, cryptoService)
 			secretHandler := apiRestV1.NewSecretsHandler(secretService)
 			secretGroup := restV1group.Group("/secrets")
 			secretGroup.Use(RequireRole(dbPool, "Operator"))       // Only admin and operator can manage secrets
@@ -206,7 +214,7 @@ func InitWebServer(app *Application, loggerWriter *lumberjack.Logger) {
 			profileGroup.PUT("/password", profileHandler.UpdatePassword)
 		}
 		{ // Actions
-			actionController := apiRestV1.NewActionController(App.Database)
+			actionController := apiRestV1.NewActionController(App.Database, actionExecutor, scriptRenderer)
 			actionGroup := restV1group.Group("/actions")
 
 			// Action Templates
@@ -220,6 +228,28 @@ func InitWebServer(app *Application, loggerWriter *lumberjack.Logger) {
 				actionTemplateIdGroup.DELETE("/", RequireRole(dbPool, "Operator"), actionController.DeleteActionTemplate)
 			}
 
+			// Action Schedules
+			actionSchedulesGroup := actionGroup.Group("/schedules")
+			actionSchedulesGroup.GET("/", actionController.GetAllActionSchedules)
+			actionSchedulesGroup.POST("/", RequireRole(dbPool, "Operator"), actionController.CreateActionSchedule)
+			actionScheduleIdGroup := actionSchedulesGroup.Group("/:scheduleId")
+			{
+				actionScheduleIdGroup.GET("/", actionController.GetActionScheduleById)
+				actionScheduleIdGroup.POST("/enable", RequireRole(dbPool, "Operator"), actionController.EnableActionSchedule)
+				actionScheduleIdGroup.POST("/disable", RequireRole(dbPool, "Operator"), actionController.DisableActionSchedule)
+				actionScheduleIdGroup.DELETE("/", RequireRole(dbPool, "Operator"), actionController.DeleteActionSchedule)
+			}
+
+			// Action Files, uploaded to servers by action templates
+			actionFilesGroup := actionGroup.Group("/files")
+			actionFilesGroup.GET("/", actionController.GetAllActionFiles)
+			actionFilesGroup.POST("/", RequireRole(dbPool, "Operator"), actionController.CreateActionFile)
+			actionFileIdGroup := actionFilesGroup.Group("/:fileId")
+			{
+				actionFileIdGroup.GET("/download", RequireRole(dbPool, "Operator"), actionController.DownloadActionFile) // Files and artifacts may hold credentials or customer data
+				actionFileIdGroup.DELETE("/", RequireRole(dbPool, "Operator"), actionController.DeleteActionFile)
+			}
+
 			// Actions
 			actionGroup.GET("/", actionController.GetAllActions)
 			actionGroup.POST("/", RequireRole(dbPool, "Operator"), actionController.CreateAction)
@@ -230,11 +260,20 @@ func InitWebServer(app *Application, loggerWriter *lumberjack.Logger) {
 				actionIdGroup.GET("/", actionController.GetActionById)
 				actionIdGroup.POST("/start", RequireRole(dbPool, "Operator"), actionController.StartAction)
 				actionIdGroup.POST("/cancel", RequireRole(dbPool, "Operator"), actionController.CancelAction)
+				actionIdGroup.POST("/approve", RequireRole(dbPool, "Operator"), actionController.ApproveAction)
+				actionIdGroup.POST("/reject", RequireRole(dbPool, "Operator"), actionController.RejectAction)
+				actionIdGroup.POST("/rerun", RequireRole(dbPool, "Operator"), actionController.RerunAction)
+				actionIdGroup.POST("/retry", RequireRole(dbPool, "Operator"), actionController.RetryFailedAction)
 				actionIdGroup.GET("/status", actionController.GetActionStatus)
+				actionIdGroup.GET("/export", actionController.ExportAction)
 			}
 
 			// Execution logs
 			restV1group.GET("/executions/:executionId/logs", actionController.GetExecutionLogs)
+			restV1group.GET("/executions/:executionId/stream", actionController.StreamExecutionOutput)
+			restV1group.POST("/executions/:executionId/steps/:stepId/confirm", RequireRole(dbPool, "Operator"), actionController.ConfirmExecutionStep)
+			restV1group.POST("/executions/:executionId/steps/:stepId/reject", RequireRole(dbPool, "Operator"), actionController.RejectExecutionStep)
+			restV1group.GET("/artifacts/:artifactId/download", RequireRole(dbPool, "Operator"), actionController.DownloadExecutionArtifact)
 		}
 	}
 	{ // HTMX
@@ -289,6 +328,7 @@ func InitWebServer(app *Application, loggerWriter *lumberjack.Logger) {
 		// Healthcheck viewing - accessible to Viewers and above
 		rootGroup.GET("/healthchecks", RequireRole(dbPool, "Viewer"), hcv.GetPageHealthchecks)
 		rootGroup.GET("/healthchecks/:id/details", RequireRole(dbPool, "Viewer"), hcv.GetPageHealthcheckDetails)
+		rootGroup.GET("/healthchecks/certificates", RequireRole(dbPool, "Viewer"), hcv.GetPageHealthcheckCertificates)
 		// Healthcheck management - accessible to Operators and above
 		rootGroup.GET("/healthchecks/create", RequireRole(dbPool, "Operator"), hcv.GetPageHealthcheckCreate)
 		rootGroup.GET("/healthchecks/:id/edit", RequireRole(dbPool, "Operator"), hcv.GetPageHealthcheckEdit)
@@ -316,7 +356,7 @@ func InitWebServer(app *Application, loggerWriter *lumberjack.Logger) {
 		secretGroup.GET("/:id/details", psh.GetPageViewSecret)
 	}
 	{ // Actions
-		av := handlers.NewActionView(App.Database)
+		av := handlers.NewActionView(App.Database, scriptRenderer, actionExecutor)
 		routeGroup := rootGroup.Group("/actions")
 		routeGroup.Use(RequireRole(dbPool, "Viewer")) // All authenticated users can view actions
 
@@ -331,6 +371,13 @@ func InitWebServer(app *Application, loggerWriter *lumberjack.Logger) {
 		routeGroup.POST("/templates/:id/edit", RequireRole(dbPool, "Operator"), av.PostPageActionTemplateEdit)
 		routeGroup.GET("/templates/:id/details", av.GetPageActionTemplateDetails)
 
+		// Action Schedules
+		routeGroup.GET("/schedules", av.GetPageActionSchedules)
+		routeGroup.GET("/schedules/:id/details", av.GetPageActionScheduleDetails)
+
+		// Action Files
+		routeGroup.GET("/files", av.GetPageActionFiles)
+
 		// Action Details
 		routeGroup.GET("/:id/details", av.GetPageActionDetails)
 	}
@@ -518,6 +565,13 @@ func derefInt64(i *int64) int64 {
 	return *i
 }
 
+func derefUint(i *uint) uint {
+	if i == nil {
+		return 0
+	}
+	return *i
+}
+
 func derefUint64(i *uint64) uint64 {
 	if i == nil {
 		return 0